@@ -0,0 +1,199 @@
+package Cache
+
+import (
+	"bytes"
+	"container/list"
+	"github.com/asatisomnath/ProgImage/Service"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// ConverterCache keeps the most recently served converted images in memory, bounded by their total size. It's shared
+// by the converters returned from Wrap.
+type ConverterCache struct {
+	MaxBytes int64
+
+	hits   uint64
+	misses uint64
+
+	mu    sync.Mutex
+	items map[string]*list.Element
+	lru   *list.List // front is most recently used
+	size  int64
+}
+
+// ConverterCacheStats is a snapshot of a ConverterCache.
+type ConverterCacheStats struct {
+	Hits   uint64
+	Misses uint64
+	Items  int
+	Bytes  int64
+}
+
+// cached is a converted image held by the cache.
+type cached struct {
+	key         string
+	contentType string
+	data        []byte
+}
+
+// NewConverterCache provides an initialised ConverterCache holding at most maxBytes of converted images.
+func NewConverterCache(maxBytes int64) *ConverterCache {
+	return &ConverterCache{
+		MaxBytes: maxBytes,
+		items:    make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// Wrap returns a ProgImage.ImageTypeConverter that serves conversions from the cache, falling back to conv. name
+// identifies the conversion and must be unique among the wrapped converters.
+func (c *ConverterCache) Wrap(name string, conv Service.ImageTypeConverter) Service.ImageTypeConverter {
+	return &cachingConverter{cache: c, name: name, conv: conv}
+}
+
+// Stats returns the current hit and miss counts and size of the cache.
+func (c *ConverterCache) Stats() ConverterCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return ConverterCacheStats{
+		Hits:   atomic.LoadUint64(&c.hits),
+		Misses: atomic.LoadUint64(&c.misses),
+		Items:  len(c.items),
+		Bytes:  c.size,
+	}
+}
+
+func (c *ConverterCache) get(key string) (*cached, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		atomic.AddUint64(&c.misses, 1)
+		return nil, false
+	}
+	atomic.AddUint64(&c.hits, 1)
+	c.lru.MoveToFront(el)
+	return el.Value.(*cached), true
+}
+
+func (c *ConverterCache) put(item *cached) {
+	size := int64(len(item.data))
+	if size > c.MaxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[item.key]; ok {
+		c.remove(el)
+	}
+	c.items[item.key] = c.lru.PushFront(item)
+	c.size += size
+
+	for c.size > c.MaxBytes {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove drops the cached image, must be called with mu held.
+func (c *ConverterCache) remove(el *list.Element) {
+	item := c.lru.Remove(el).(*cached)
+	delete(c.items, item.key)
+	c.size -= int64(len(item.data))
+}
+
+// cachingConverter is a ProgImage.ImageTypeConverter decorator backed by a ConverterCache.
+type cachingConverter struct {
+	cache *ConverterCache
+	name  string
+	conv  Service.ImageTypeConverter
+}
+
+// Convert serves the converted image from the cache, or converts it and caches the output as it's read.
+func (cc *cachingConverter) Convert(img Service.Image, ec chan error, transformers ...Service.Transformer) (Service.Image, error) {
+	key := cc.name + "/" + img.ID
+	for _, tr := range transformers {
+		key += "/" + tr.String()
+	}
+	if item, ok := cc.cache.get(key); ok {
+		ec <- nil
+		return Service.Image{ID: img.ID, ContentType: item.contentType, Data: bytes.NewReader(item.data)}, nil
+	}
+
+	inner := make(chan error, 1)
+	out, err := cc.conv.Convert(img, inner, transformers...)
+	if err != nil {
+		return out, err
+	}
+	if out.ContentType == img.ContentType && len(transformers) == 0 {
+		// nothing was converted, don't hold a copy of the original
+		ec <- <-inner
+		return out, nil
+	}
+
+	out.Data = &recordingReader{
+		src:   out.Data,
+		limit: cc.cache.MaxBytes,
+		inner: inner,
+		ec:    ec,
+		store: func(data []byte) {
+			cc.cache.put(&cached{key: key, contentType: out.ContentType, data: data})
+		},
+	}
+	return out, nil
+}
+
+// recordingReader copies the converted image as it's read and stores it once it has been read in full without an
+// encoding error. The converter's error is passed on to ec once the data has been read or the reader closed.
+type recordingReader struct {
+	src   io.Reader
+	buf   bytes.Buffer
+	limit int64
+	inner chan error
+	ec    chan error
+	store func([]byte)
+	once  sync.Once
+}
+
+func (r *recordingReader) Read(p []byte) (int, error) {
+	n, err := r.src.Read(p)
+	if int64(r.buf.Len()+n) <= r.limit {
+		r.buf.Write(p[:n]) // nolint: errcheck,gas
+	} else {
+		// too big to cache, stop recording
+		r.limit = -1
+	}
+	if err == io.EOF {
+		r.finish(true)
+	}
+	return n, err
+}
+
+// Close stops reading early, closing the converted data so the encoder isn't left blocked.
+func (r *recordingReader) Close() error {
+	var err error
+	if c, ok := r.src.(io.Closer); ok {
+		err = c.Close()
+	}
+	r.finish(false)
+	return err
+}
+
+func (r *recordingReader) finish(complete bool) {
+	r.once.Do(func() {
+		if !complete {
+			// the encoder reports once it notices the closed pipe
+			go func() { r.ec <- <-r.inner }()
+			return
+		}
+		err := <-r.inner
+		if err == nil && r.limit >= 0 {
+			r.store(r.buf.Bytes())
+		}
+		r.ec <- err
+	})
+}
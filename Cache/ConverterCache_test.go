@@ -0,0 +1,137 @@
+package Cache_test
+
+import (
+	"bytes"
+	"github.com/asatisomnath/ProgImage/Service"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/asatisomnath/ProgImage/Cache"
+	_ "github.com/asatisomnath/ProgImage/Convertors/formats" // register formats, do not remove
+	"github.com/asatisomnath/ProgImage/Convertors/png"
+)
+
+// countingConverter counts the conversions that reach the wrapped converter.
+type countingConverter struct {
+	Service.ImageTypeConverter
+	calls int
+}
+
+func (cc *countingConverter) Convert(img Service.Image, ec chan error, transformers ...Service.Transformer) (Service.Image, error) {
+	cc.calls++
+	return cc.ImageTypeConverter.Convert(img, ec, transformers...)
+}
+
+// convert runs a conversion of the test gif and returns the output.
+func convert(t *testing.T, conv Service.ImageTypeConverter, ID string) Service.Image {
+	fp, err := os.Open("../testimages/test.gif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fp.Close()
+
+	ec := make(chan error, 1)
+	out, err := conv.Convert(Service.Image{ID: ID, ContentType: "image/gif", Data: fp}, ec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(out.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-ec; err != nil {
+		t.Fatal(err)
+	}
+	out.Data = bytes.NewReader(b)
+	return out
+}
+
+func TestConverterCache_Hit(t *testing.T) {
+	cc := &countingConverter{ImageTypeConverter: png.Converter}
+	c := Cache.NewConverterCache(1 << 20)
+	conv := c.Wrap("png", cc)
+
+	first := convert(t, conv, "foo")
+	second := convert(t, conv, "foo")
+
+	if cc.calls != 1 {
+		t.Errorf("expected 1 conversion, got %d", cc.calls)
+	}
+	if second.ContentType != "image/png" {
+		t.Errorf("expected content type to be image/png, got %s", second.ContentType)
+	}
+	a, _ := ioutil.ReadAll(first.Data)
+	b, _ := ioutil.ReadAll(second.Data)
+	if !bytes.Equal(a, b) {
+		t.Error("expected cached data to be equal to converted data, data not equal")
+	}
+
+	stats := c.Stats()
+	if stats.Hits != 1 || stats.Misses != 1 || stats.Items != 1 || stats.Bytes != int64(len(a)) {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestConverterCache_Evict(t *testing.T) {
+	cc := &countingConverter{ImageTypeConverter: png.Converter}
+	size := int64(convert(t, png.Converter, "foo").Data.(*bytes.Reader).Len())
+
+	// room for 2 conversions
+	c := Cache.NewConverterCache(size * 2)
+	conv := c.Wrap("png", cc)
+
+	convert(t, conv, "first")
+	convert(t, conv, "second")
+	// use the first so the second is the least recently used
+	convert(t, conv, "first")
+	convert(t, conv, "third")
+
+	if stats := c.Stats(); stats.Items != 2 || stats.Bytes != size*2 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	calls := cc.calls
+	convert(t, conv, "first")
+	convert(t, conv, "third")
+	if cc.calls != calls {
+		t.Errorf("expected first and third to be cached")
+	}
+	convert(t, conv, "second")
+	if cc.calls != calls+1 {
+		t.Errorf("expected second to be evicted")
+	}
+
+	// too big to ever fit
+	small := Cache.NewConverterCache(10)
+	convert(t, small.Wrap("png", png.Converter), "foo")
+	if stats := small.Stats(); stats.Items != 0 || stats.Bytes != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestConverterCache_SameType(t *testing.T) {
+	c := Cache.NewConverterCache(1 << 20)
+	conv := c.Wrap("png", png.Converter)
+
+	d, err := ioutil.ReadFile("../testimages/test.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ec := make(chan error, 1)
+	out, err := conv.Convert(Service.Image{ID: "foo", ContentType: "image/png", Data: bytes.NewReader(d)}, ec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(out.Data); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-ec; err != nil {
+		t.Fatal(err)
+	}
+
+	// originals aren't held by the cache
+	if stats := c.Stats(); stats.Items != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
@@ -0,0 +1,314 @@
+package Cache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/asatisomnath/ProgImage/Service"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const metaExt = ".meta"
+const tmpPrefix = ".tmp-"
+
+var _ Service.ImageService = &ImageService{}
+
+// ImageService wraps a ProgImage.ImageService with a read-through cache of originals on disk, so repeated Gets don't
+// go back to the backend. The least recently used files are removed to keep the cache within MaxBytes and files are
+// checked against their checksum before they're served.
+type ImageService struct {
+	Service.ImageService
+	Dir      string
+	MaxBytes int64
+
+	mu    sync.Mutex
+	items map[string]*list.Element
+	lru   *list.List // front is most recently used
+	size  int64
+}
+
+// cacheEntry is a cached original, it's also written alongside the data so the cache survives restarts.
+type cacheEntry struct {
+	ID          string `json:"id"`
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256"`
+}
+
+// NewImageService provides an initialised ImageService caching up to maxBytes of is's originals in dir.
+func NewImageService(is Service.ImageService, dir string, maxBytes int64) *ImageService {
+	return &ImageService{
+		ImageService: is,
+		Dir:          dir,
+		MaxBytes:     maxBytes,
+		items:        make(map[string]*list.Element),
+		lru:          list.New(),
+	}
+}
+
+// Load creates Dir if needed and picks up anything cached by a previous run, oldest first.
+func (is *ImageService) Load() error {
+	if err := os.MkdirAll(is.Dir, 0755); err != nil {
+		return errors.Wrap(err, "error creating cache directory")
+	}
+	files, err := ioutil.ReadDir(is.Dir)
+	if err != nil {
+		return errors.Wrap(err, "error reading cache directory")
+	}
+
+	type loaded struct {
+		e       *cacheEntry
+		modTime int64
+	}
+	var found []loaded
+	known := map[string]bool{}
+	for _, fi := range files {
+		name := fi.Name()
+		if !strings.HasSuffix(name, metaExt) {
+			continue
+		}
+		e, err := is.readEntry(strings.TrimSuffix(name, metaExt))
+		if err != nil {
+			log.Printf("dropping cache entry %s, %s", name, err)
+			continue
+		}
+		dfi, err := os.Stat(filepath.Join(is.Dir, e.ID))
+		if err != nil || dfi.Size() != e.Size {
+			continue
+		}
+		known[e.ID] = true
+		known[e.ID+metaExt] = true
+		found = append(found, loaded{e: e, modTime: dfi.ModTime().UnixNano()})
+	}
+
+	// anything else is left over from an interrupted write or a dropped entry
+	for _, fi := range files {
+		if !fi.IsDir() && !known[fi.Name()] {
+			os.Remove(filepath.Join(is.Dir, fi.Name())) // nolint: gas,errcheck
+		}
+	}
+
+	sort.Slice(found, func(i, j int) bool { return found[i].modTime < found[j].modTime })
+
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	for _, l := range found {
+		if el, ok := is.items[l.e.ID]; ok {
+			is.remove(el)
+		}
+		is.items[l.e.ID] = is.lru.PushFront(l.e)
+		is.size += l.e.Size
+	}
+	is.evict()
+	return nil
+}
+
+// Size returns the total size of the cached originals.
+func (is *ImageService) Size() int64 {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	return is.size
+}
+
+// Get retrieves the Image with the given id from the cache, falling back to the wrapped ImageService and caching the
+// result.
+func (is *ImageService) Get(ID string) (Service.Image, error) {
+	if !validName(ID) {
+		return is.ImageService.Get(ID)
+	}
+
+	if img, ok := is.cached(ID); ok {
+		return img, nil
+	}
+
+	img, err := is.ImageService.Get(ID)
+	if err != nil {
+		return img, err
+	}
+	defer closeData(img.Data)
+
+	tmp, err := ioutil.TempFile(is.Dir, tmpPrefix)
+	if err != nil {
+		return Service.Image{}, errors.Wrap(err, "error creating cache file")
+	}
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), img.Data)
+	if err != nil {
+		tmp.Close()           // nolint: gas,errcheck
+		os.Remove(tmp.Name()) // nolint: gas,errcheck
+		return Service.Image{}, errors.Wrapf(err, "error reading %s", ID)
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		tmp.Close()           // nolint: gas,errcheck
+		os.Remove(tmp.Name()) // nolint: gas,errcheck
+		return Service.Image{}, errors.Wrap(err, "error rewinding cache file")
+	}
+
+	e := &cacheEntry{ID: ID, ContentType: img.ContentType, Size: size, SHA256: hex.EncodeToString(h.Sum(nil))}
+	if err := is.add(e, tmp.Name()); err != nil {
+		// serve it anyway, it's only the caching that failed
+		log.Printf("error caching %s, %s", ID, err)
+		return Service.Image{ID: ID, ContentType: img.ContentType, Data: &removeOnClose{tmp}}, nil
+	}
+	return Service.Image{ID: ID, ContentType: img.ContentType, Data: tmp}, nil
+}
+
+// Delete removes the Image with the given id from the wrapped ImageService and the cache.
+func (is *ImageService) Delete(ID string) error {
+	err := is.ImageService.Delete(ID)
+
+	is.mu.Lock()
+	if el, ok := is.items[ID]; ok {
+		is.remove(el)
+	}
+	is.mu.Unlock()
+
+	return err
+}
+
+// cached opens the cached copy of the Image with the given id if there is one and it matches its checksum.
+func (is *ImageService) cached(ID string) (Service.Image, bool) {
+	is.mu.Lock()
+	el, ok := is.items[ID]
+	if !ok {
+		is.mu.Unlock()
+		return Service.Image{}, false
+	}
+	is.lru.MoveToFront(el)
+	e := el.Value.(*cacheEntry)
+	// opened with the lock held so it can't be evicted in between, once open it can still be read after removal
+	f, err := os.Open(filepath.Join(is.Dir, ID))
+	is.mu.Unlock()
+
+	if err == nil {
+		err = verify(f, e)
+		if err != nil {
+			f.Close() // nolint: gas,errcheck
+		}
+	}
+	if err != nil {
+		log.Printf("dropping cached %s, %s", ID, err)
+		is.mu.Lock()
+		if cur, ok := is.items[ID]; ok && cur == el {
+			is.remove(el)
+		}
+		is.mu.Unlock()
+		return Service.Image{}, false
+	}
+	return Service.Image{ID: ID, ContentType: e.ContentType, Data: f}, true
+}
+
+// add moves the file at name into the cache as e, evicting older files to make room.
+func (is *ImageService) add(e *cacheEntry, name string) error {
+	if e.Size > is.MaxBytes {
+		return errors.Errorf("%d bytes exceeds cache size of %d bytes", e.Size, is.MaxBytes)
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return errors.Wrap(err, "error encoding cache entry")
+	}
+
+	is.mu.Lock()
+	defer is.mu.Unlock()
+
+	// another Get may have cached it in the meantime
+	if el, ok := is.items[e.ID]; ok {
+		is.remove(el)
+	}
+	if err := ioutil.WriteFile(filepath.Join(is.Dir, e.ID+metaExt), b, 0644); err != nil {
+		return errors.Wrap(err, "error writing cache entry")
+	}
+	if err := os.Rename(name, filepath.Join(is.Dir, e.ID)); err != nil {
+		os.Remove(filepath.Join(is.Dir, e.ID+metaExt)) // nolint: gas,errcheck
+		return errors.Wrap(err, "error moving cache file into place")
+	}
+	is.items[e.ID] = is.lru.PushFront(e)
+	is.size += e.Size
+	is.evict()
+	return nil
+}
+
+// readEntry reads the cache entry written alongside the data of the given id.
+func (is *ImageService) readEntry(ID string) (*cacheEntry, error) {
+	b, err := ioutil.ReadFile(filepath.Join(is.Dir, ID+metaExt))
+	if err != nil {
+		return nil, err
+	}
+	e := &cacheEntry{}
+	if err := json.Unmarshal(b, e); err != nil {
+		return nil, err
+	}
+	if e.ID != ID {
+		return nil, errors.Errorf("entry is for %s", e.ID)
+	}
+	return e, nil
+}
+
+// evict removes the least recently used files until the cache is within MaxBytes, must be called with mu held.
+func (is *ImageService) evict() {
+	for is.size > is.MaxBytes {
+		el := is.lru.Back()
+		if el == nil {
+			return
+		}
+		is.remove(el)
+	}
+}
+
+// remove drops the cached file, must be called with mu held.
+func (is *ImageService) remove(el *list.Element) {
+	e := is.lru.Remove(el).(*cacheEntry)
+	delete(is.items, e.ID)
+	is.size -= e.Size
+	os.Remove(filepath.Join(is.Dir, e.ID))         // nolint: gas,errcheck
+	os.Remove(filepath.Join(is.Dir, e.ID+metaExt)) // nolint: gas,errcheck
+}
+
+// verify checks f matches the size and checksum of e, leaving it ready to read from the start.
+func verify(f *os.File, e *cacheEntry) error {
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return errors.Wrap(err, "error reading cache file")
+	}
+	if n != e.Size {
+		return errors.Errorf("expected %d bytes, got %d", e.Size, n)
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != e.SHA256 {
+		return errors.Errorf("checksum mismatch, expected %s, got %s", e.SHA256, sum)
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	return err
+}
+
+// validName reports whether the id can be used as a file name in the cache directory.
+func validName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, `/\`) && !strings.HasPrefix(name, ".") && !strings.HasSuffix(name, metaExt)
+}
+
+func closeData(r io.Reader) {
+	if c, ok := r.(io.Closer); ok {
+		c.Close() // nolint: gas,errcheck
+	}
+}
+
+// removeOnClose is a temporary file that's removed once it has been read.
+type removeOnClose struct {
+	*os.File
+}
+
+func (r *removeOnClose) Close() error {
+	err := r.File.Close()
+	os.Remove(r.File.Name()) // nolint: gas,errcheck
+	return err
+}
@@ -0,0 +1,202 @@
+package Cache_test
+
+import (
+	"bytes"
+	"github.com/asatisomnath/ProgImage/Service"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/asatisomnath/ProgImage/Cache"
+	_ "github.com/asatisomnath/ProgImage/Convertors/formats" // register formats, do not remove
+	"github.com/asatisomnath/ProgImage/MemoryStorageService"
+	"github.com/google/uuid"
+)
+
+// countingService counts the Gets that reach the wrapped ImageService.
+type countingService struct {
+	Service.ImageService
+	gets int
+}
+
+func (cs *countingService) Get(ID string) (Service.Image, error) {
+	cs.gets++
+	return cs.ImageService.Get(ID)
+}
+
+// setupCache wraps a memory store holding the test images in a disk cache in a new temporary directory.
+func setupCache(t *testing.T, maxBytes int64) (*Cache.ImageService, *countingService, map[string][]byte, func()) {
+	dir, err := ioutil.TempDir("", "progimage-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backend := &countingService{ImageService: MemoryStorageService.NewImageService(0, uuid.New)}
+	stored := map[string][]byte{}
+	for _, p := range []string{"../testimages/test.png", "../testimages/test.gif"} {
+		d, err := ioutil.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := backend.Upload(bytes.NewReader(d))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored[res.ID] = d
+	}
+
+	is := Cache.NewImageService(backend, filepath.Join(dir, "cache"), maxBytes)
+	if err := is.Load(); err != nil {
+		t.Fatal(err)
+	}
+	return is, backend, stored, func() {
+		os.RemoveAll(dir)
+	}
+}
+
+// get reads the Image with the given id in full.
+func get(t *testing.T, is Service.ImageService, ID string) []byte {
+	img, err := is.Get(ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(img.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, ok := img.Data.(io.Closer); ok {
+		c.Close()
+	}
+	return b
+}
+
+func TestImageService_ReadThrough(t *testing.T) {
+	is, backend, stored, teardown := setupCache(t, 1<<20)
+	defer teardown()
+
+	for ID, d := range stored {
+		for i := 0; i < 3; i++ {
+			if !bytes.Equal(get(t, is, ID), d) {
+				t.Errorf("expected data of %s to be equal to stored data, data not equal", ID)
+			}
+		}
+	}
+	if backend.gets != len(stored) {
+		t.Errorf("expected %d gets from the backend, got %d", len(stored), backend.gets)
+	}
+
+	if _, err := is.Get("foo"); err != Service.ErrImageNotFound {
+		t.Errorf("expected ProgImage.ErrImageNotFound, got %v", err)
+	}
+
+	// a new cache over the same directory picks up what's there
+	reloaded := Cache.NewImageService(backend, is.Dir, is.MaxBytes)
+	if err := reloaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.Size() != is.Size() {
+		t.Errorf("expected reloaded size to be %d, got %d", is.Size(), reloaded.Size())
+	}
+	gets := backend.gets
+	for ID := range stored {
+		get(t, reloaded, ID)
+	}
+	if backend.gets != gets {
+		t.Errorf("expected reloaded cache to serve all images, got %d gets", backend.gets-gets)
+	}
+}
+
+func TestImageService_Evict(t *testing.T) {
+	is, backend, stored, teardown := setupCache(t, 0)
+	defer teardown()
+
+	var IDs []string
+	for ID := range stored {
+		IDs = append(IDs, ID)
+	}
+	small, large := IDs[0], IDs[1]
+	if len(stored[small]) > len(stored[large]) {
+		small, large = large, small
+	}
+	// room for only the larger image
+	is.MaxBytes = int64(len(stored[large]))
+
+	get(t, is, small)
+	get(t, is, large)
+	if is.Size() != int64(len(stored[large])) {
+		t.Errorf("expected size to be %d, got %d", len(stored[large]), is.Size())
+	}
+
+	gets := backend.gets
+	get(t, is, large)
+	if backend.gets != gets {
+		t.Error("expected larger image to be cached")
+	}
+	get(t, is, small)
+	if backend.gets != gets+1 {
+		t.Error("expected smaller image to be evicted")
+	}
+
+	// files on disk stay within the limit
+	var total int64
+	files, err := ioutil.ReadDir(is.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fi := range files {
+		if filepath.Ext(fi.Name()) != ".meta" {
+			total += fi.Size()
+		}
+	}
+	if total > is.MaxBytes {
+		t.Errorf("expected at most %d bytes on disk, got %d", is.MaxBytes, total)
+	}
+}
+
+func TestImageService_Checksum(t *testing.T) {
+	is, backend, stored, teardown := setupCache(t, 1<<20)
+	defer teardown()
+
+	for ID, d := range stored {
+		get(t, is, ID)
+
+		// corrupt the cached copy
+		p := filepath.Join(is.Dir, ID)
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b[len(b)/2] ^= 0xff
+		if err := ioutil.WriteFile(p, b, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		gets := backend.gets
+		if !bytes.Equal(get(t, is, ID), d) {
+			t.Errorf("expected corrupted copy of %s not to be served", ID)
+		}
+		if backend.gets != gets+1 {
+			t.Errorf("expected %s to be fetched again", ID)
+		}
+	}
+}
+
+func TestImageService_Delete(t *testing.T) {
+	is, _, stored, teardown := setupCache(t, 1<<20)
+	defer teardown()
+
+	for ID := range stored {
+		get(t, is, ID)
+		if err := is.Delete(ID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := is.Get(ID); err != Service.ErrImageNotFound {
+			t.Errorf("expected ProgImage.ErrImageNotFound, got %v", err)
+		}
+	}
+	if is.Size() != 0 {
+		t.Errorf("expected size to be 0, got %d", is.Size())
+	}
+}
@@ -0,0 +1,232 @@
+package Connection
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/asatisomnath/ProgImage/Service"
+	"image"
+	"image/gif"
+	"io"
+	"log"
+	"net/http"
+
+	primage "github.com/asatisomnath/ProgImage/Convertors"
+	pigif "github.com/asatisomnath/ProgImage/Convertors/gif"
+	"github.com/asatisomnath/ProgImage/Transformers/resize"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+)
+
+// MaxAnimationFrames is the most frames an animation can be assembled from.
+const MaxAnimationFrames = 100
+
+// MaxAnimationPixels is the most pixels an assembled animation can have across all its frames.
+const MaxAnimationPixels = 50 * 1000 * 1000
+
+// errAnimationPixels is returned for an animation that would be over MaxAnimationPixels.
+var errAnimationPixels = errors.Errorf("animation is over %d pixels", MaxAnimationPixels)
+
+// defaultFrameDelay is the delay of frames when none is given, in 100ths of a second.
+const defaultFrameDelay = 10
+
+// animationRequest is the body of a POST assembling an animation. Delays are in 100ths of a second, one per frame,
+// and LoopCount is as in image/gif, 0 loops forever. Frames are scaled to fit Width by Height, which default to the
+// size of the first frame.
+type animationRequest struct {
+	IDs       []string `json:"ids"`
+	Delays    []int    `json:"delays"`
+	LoopCount int      `json:"loopCount"`
+	Width     int      `json:"width"`
+	Height    int      `json:"height"`
+}
+
+func (req animationRequest) validate() error {
+	if len(req.IDs) == 0 {
+		return errors.New("ids are required")
+	}
+	if len(req.IDs) > MaxAnimationFrames {
+		return errors.Errorf("at most %d frames are allowed", MaxAnimationFrames)
+	}
+	if len(req.Delays) > 0 && len(req.Delays) != len(req.IDs) {
+		return errors.New("give a delay for each frame or none at all")
+	}
+	for _, d := range req.Delays {
+		if d < 0 || d > 0xffff {
+			return errors.New("delays must be between 0 and 65535")
+		}
+	}
+	if req.LoopCount < -1 || req.LoopCount > 0xffff {
+		return errors.New("loopCount must be between -1 and 65535")
+	}
+	if req.Width < 0 || req.Height < 0 || req.Width > resize.MaxDimension || req.Height > resize.MaxDimension {
+		return errors.Errorf("width and height must be between 1 and %d", resize.MaxDimension)
+	}
+	return nil
+}
+
+// handleCreateAnimation assembles an animated GIF from stored images and stores it as a new image.
+func (h *ImageHandler) handleCreateAnimation(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	req := animationRequest{}
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxStepsBytes)).Decode(&req); err != nil {
+		http.Error(w, "invalid request body, "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := req.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	a := primage.Animation{LoopCount: req.LoopCount}
+	width, height := req.Width, req.Height
+	// the frames are held until the animation is stored, only the first waits for memory as waiting while holding some
+	// could deadlock with others assembling animations
+	var held int64
+	defer func() { h.Budget.Release(held) }()
+	for i, ID := range req.IDs {
+		frame, n, err := h.decodeFrame(ID, held, func(c image.Config) error {
+			if i > 0 {
+				return nil
+			}
+			width, height = frameSize(image.Rect(0, 0, c.Width, c.Height), width, height)
+			if width*height*len(req.IDs) > MaxAnimationPixels {
+				return errAnimationPixels
+			}
+			return nil
+		})
+		if err != nil {
+			frameError(w, i, ID, err)
+			return
+		}
+		held += n
+
+		if b := frame.Bounds(); b.Dx() != width || b.Dy() != height {
+			rs := resize.Resize{Width: width, Height: height, Fit: resize.FitContain}
+			_, scaling, err := rs.Size(b.Size())
+			if err == nil {
+				err = h.Budget.AcquireMore(held, scaling)
+			}
+			if err != nil {
+				frameError(w, i, ID, err)
+				return
+			}
+			held += scaling
+			if frame, err = rs.Transform(frame); err != nil {
+				http.Error(w, fmt.Sprintf("frame %d: %s", i, err), http.StatusInternalServerError)
+				return
+			}
+			// only the scaled frame is kept
+			keep := primage.PixelBytes(image.Config{Width: width, Height: height})
+			h.Budget.Release(n + scaling - keep)
+			held -= n + scaling - keep
+		}
+
+		delay := defaultFrameDelay
+		if len(req.Delays) > 0 {
+			delay = req.Delays[i]
+		}
+		a.Frames = append(a.Frames, frame)
+		a.Delays = append(a.Delays, delay)
+		// frames are whole, clear each away so transparent padding doesn't show the one before
+		a.Disposals = append(a.Disposals, gif.DisposalBackground)
+	}
+
+	buf := bytes.Buffer{}
+	if err := pigif.EncodeAnimation(&buf, a, primage.Options{}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	res, err := h.ImageService.Upload(&buf)
+	if err != nil {
+		uploadError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_, err = w.Write([]byte(fmt.Sprintf(`{"id": "%s", "deduplicated": %t}`, res.ID, res.Deduplicated)))
+	if err != nil {
+		log.Println("error writing handleCreateAnimation response", err.Error())
+	}
+}
+
+// decodeFrame fetches and decodes a stored image to use as a frame, animated images give their first frame. check is
+// given its size before it's decoded, and the n bytes decoding it takes are reserved from the Budget for the caller to
+// release, waiting for them only when the caller holds none.
+func (h *ImageHandler) decodeFrame(ID string, held int64, check func(image.Config) error) (frame image.Image, n int64, err error) {
+	img, err := h.ImageService.Get(ID)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer closeImage(img)
+	c, n, r, err := primage.DecodeConfig(img.Data)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := check(c); err != nil {
+		return nil, 0, err
+	}
+	acquire := h.Budget.Acquire
+	if held > 0 {
+		acquire = func(n int64) error { return h.Budget.AcquireMore(held, n) }
+	}
+	if err := acquire(n); err != nil {
+		return nil, 0, err
+	}
+	if frame, err = primage.Decode(r); err != nil {
+		h.Budget.Release(n)
+		return nil, 0, err
+	}
+	return frame, n, nil
+}
+
+// frameError answers err assembling frame i, the image ID, of an animation.
+func frameError(w http.ResponseWriter, i int, ID string, err error) {
+	if err == Service.ErrImageNotFound {
+		http.Error(w, fmt.Sprintf("frame %d: image %s not found", i, ID), http.StatusBadRequest)
+		return
+	}
+	if err == errAnimationPixels {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, ok := err.(*Service.LimitError); ok {
+		http.Error(w, fmt.Sprintf("frame %d: %s", i, err), http.StatusUnprocessableEntity)
+		return
+	}
+	if err == primage.ErrTooLarge {
+		http.Error(w, fmt.Sprintf("frame %d: %s", i, err), http.StatusUnprocessableEntity)
+		return
+	}
+	if err == primage.ErrOverBudget {
+		// other conversions should have finished by then
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	http.Error(w, fmt.Sprintf("frame %d: %s", i, err), http.StatusInternalServerError)
+}
+
+// frameSize is the size frames are scaled to, from the first frame b when width or height aren't given.
+func frameSize(b image.Rectangle, width, height int) (int, int) {
+	switch {
+	case width == 0 && height == 0:
+		return b.Dx(), b.Dy()
+	case width == 0:
+		return scaledSide(b.Dx(), height, b.Dy()), height
+	case height == 0:
+		return width, scaledSide(b.Dy(), width, b.Dx())
+	}
+	return width, height
+}
+
+// scaledSide is n scaled by num/den, at least 1.
+func scaledSide(n, num, den int) int {
+	if den == 0 {
+		return 1
+	}
+	if v := (n*num + den/2) / den; v > 0 {
+		return v
+	}
+	return 1
+}
@@ -0,0 +1,190 @@
+package Connection_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/asatisomnath/ProgImage/Service"
+	"image/gif"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	pihttp "github.com/asatisomnath/ProgImage/Connection"
+	primage "github.com/asatisomnath/ProgImage/Convertors"
+	"github.com/asatisomnath/ProgImage/MemoryStorageService"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+func TestCreateAnimation(t *testing.T) {
+	is := MemoryStorageService.NewImageService(0, uuid.New)
+	h := pihttp.NewImageHandler(is)
+	h.Budget = primage.NewBudget(1<<30, 0)
+
+	var ids []string
+	for _, path := range []string{"../testimages/test.png", "../testimages/test.jpg", "../testimages/test.gif"} {
+		d, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := is.Upload(bytes.NewReader(d))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, res.ID)
+	}
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "/animations", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	body, err := json.Marshal(map[string]interface{}{"ids": ids, "delays": []int{50, 20, 30}, "loopCount": 2, "width": 120})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := post(string(body))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected: %v got: %v, %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	created := struct {
+		ID string `json:"id"`
+	}{}
+	if err := json.NewDecoder(rr.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := is.Get(created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.ContentType != "image/gif" {
+		t.Errorf("expected a gif, got %s", img.ContentType)
+	}
+	g, err := gif.DecodeAll(img.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// sized from the first frame, 1000x476
+	if len(g.Image) != 3 || g.Config.Width != 120 || g.Config.Height != 57 {
+		t.Errorf("expected 3 120x57 frames, got %d %dx%d", len(g.Image), g.Config.Width, g.Config.Height)
+	}
+	for i, f := range g.Image {
+		if f.Bounds().Dx() != 120 || f.Bounds().Dy() != 57 {
+			t.Errorf("expected frame %d to be 120x57, got %v", i, f.Bounds())
+		}
+		if len(f.Palette) != len(g.Image[0].Palette) {
+			t.Errorf("expected frame %d to share the palette", i)
+		}
+	}
+	if g.LoopCount != 2 || g.Delay[0] != 50 || g.Delay[2] != 30 {
+		t.Errorf("unexpected timing, loop count %d and delays %v", g.LoopCount, g.Delay)
+	}
+	if h.Budget.Used() != 0 {
+		t.Errorf("expected the frames to be released once stored, got %d", h.Budget.Used())
+	}
+
+	tests := []struct {
+		Name string
+		Body string
+	}{
+		{Name: "no ids", Body: `{"ids": []}`},
+		{Name: "not json", Body: `ids`},
+		{Name: "not found", Body: `{"ids": ["` + ids[0] + `", "missing"]}`},
+		{Name: "delays", Body: `{"ids": ["` + ids[0] + `", "` + ids[1] + `"], "delays": [10]}`},
+		{Name: "negative delay", Body: `{"ids": ["` + ids[0] + `"], "delays": [-10]}`},
+		{Name: "loop count", Body: `{"ids": ["` + ids[0] + `"], "loopCount": -2}`},
+		{Name: "size", Body: `{"ids": ["` + ids[0] + `"], "width": 100000}`},
+		{Name: "too many pixels", Body: `{"ids": ["` + ids[0] + `", "` + ids[1] + `"], "width": 10000, "height": 10000}`},
+	}
+	for _, item := range tests {
+		t.Run(item.Name, func(t *testing.T) {
+			if rr := post(item.Body); rr.Code != http.StatusBadRequest {
+				t.Errorf("expected: %v got: %v, %s", http.StatusBadRequest, rr.Code, rr.Body.String())
+			}
+		})
+	}
+	if rr := post(`{"ids": ["missing"]}`); !strings.Contains(rr.Body.String(), "frame 0: image missing not found") {
+		t.Errorf("expected the missing image to be named, got %s", rr.Body.String())
+	}
+
+	// the second frame can never fit alongside the first
+	h.Budget = primage.NewBudget(1000*476*4*3/2, time.Millisecond)
+	rr = post(`{"ids": ["` + ids[0] + `", "` + ids[0] + `"]}`)
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected: %v got: %v, %s", http.StatusUnprocessableEntity, rr.Code, rr.Body.String())
+	}
+	if h.Budget.Used() != 0 {
+		t.Errorf("expected the frames to be released, got %d", h.Budget.Used())
+	}
+
+	// the second frame doesn't fit while other memory is in use, and isn't waited for holding the first
+	h.Budget = primage.NewBudget(1000*476*4*2, time.Hour)
+	if err := h.Budget.Acquire(1000 * 476 * 4 / 2); err != nil {
+		t.Fatal(err)
+	}
+	rr = post(`{"ids": ["` + ids[0] + `", "` + ids[0] + `"]}`)
+	if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Retry-After") == "" {
+		t.Errorf("expected: %v got: %v, %s", http.StatusServiceUnavailable, rr.Code, rr.Body.String())
+	}
+	if h.Budget.Used() != 1000*476*4/2 {
+		t.Errorf("expected the frames to be released, got %d", h.Budget.Used())
+	}
+}
+
+// TestCreateAnimation_Upload checks the animation being refused by the store is answered as an upload would be.
+func TestCreateAnimation_Upload(t *testing.T) {
+	h := NewImageHandler()
+	h.ImageService.GetFunc = func(ID string) (Service.Image, error) {
+		fp, err := os.Open("../testimages/test.png")
+		if err != nil {
+			return Service.Image{}, err
+		}
+		return Service.Image{ID: ID, Data: fp, ContentType: "image/png"}, nil
+	}
+
+	tests := []struct {
+		Name   string
+		Err    error
+		Status int
+	}{
+		{
+			Name:   "limits",
+			Err:    &Service.LimitError{Width: 1, Height: 1, Limit: "max width 0"},
+			Status: http.StatusUnprocessableEntity,
+		},
+		{
+			Name:   "store size",
+			Err:    errors.Wrap(Service.ErrImageTooLarge, "store full"),
+			Status: http.StatusRequestEntityTooLarge,
+		},
+		{Name: "unrecognised", Err: Service.ErrUnrecognisedImageType, Status: http.StatusBadRequest},
+		{Name: "storage", Err: errors.New("unavailable"), Status: http.StatusInternalServerError},
+	}
+	for _, item := range tests {
+		t.Run(item.Name, func(t *testing.T) {
+			h.ImageService.StoreFunc = func(io.Reader) (Service.UploadResult, error) {
+				return Service.UploadResult{}, item.Err
+			}
+			req, err := http.NewRequest("POST", "/animations", strings.NewReader(`{"ids": ["a", "b"], "width": 20}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+			if rr.Code != item.Status {
+				t.Errorf("expected: %v got: %v, %s", item.Status, rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
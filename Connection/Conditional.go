@@ -0,0 +1,113 @@
+package Connection
+
+import (
+	"fmt"
+	"github.com/asatisomnath/ProgImage/Service"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultCacheControl is the Cache-Control sent with images unless ImageHandler.CacheControl is changed.
+const DefaultCacheControl = "public, max-age=86400"
+
+// validators identify the version of an image response for conditional requests.
+type validators struct {
+	ETag         string
+	LastModified time.Time
+}
+
+// imageValidators derives the validators of the Image with the given id, converted to ext when it's set. Stored images
+// never change so their checksum and upload time are enough and nothing needs fetching to compare them.
+func (h *ImageHandler) imageValidators(ID, ext string) (validators, error) {
+	meta, err := h.ImageService.Meta(ID)
+	if err != nil {
+		return validators{}, err
+	}
+	return metaValidators(meta, ext), nil
+}
+
+// metaValidators derives the validators of an image from its metadata, converted to ext when it's set.
+func metaValidators(meta Service.ImageMeta, ext string) validators {
+	v := validators{LastModified: meta.Uploaded}
+	if meta.SHA256 != "" {
+		if ext == "" {
+			v.ETag = fmt.Sprintf(`"%s"`, meta.SHA256)
+		} else {
+			v.ETag = fmt.Sprintf(`"%s.%s"`, meta.SHA256, ext)
+		}
+	}
+	return v
+}
+
+// checkConditional looks up the validators of the image and responds when the request can be answered without the
+// image body, either with a 304 or a 404. found reports whether the image was seen to exist and done whether a response
+// was written.
+func (h *ImageHandler) checkConditional(w http.ResponseWriter, r *http.Request, ID, ext string) (v validators, found, done bool) {
+	v, err := h.imageValidators(ID, ext)
+	if err != nil {
+		if err == Service.ErrImageNotFound {
+			http.Error(w, fmt.Sprintf("image %s not found", ID), http.StatusNotFound)
+			return v, false, true
+		}
+		// serve it without validators rather than failing the request
+		log.Printf("error getting meta of %s, %s", ID, err)
+		return validators{}, false, false
+	}
+
+	if !notModified(r, v) {
+		return v, true, false
+	}
+	h.writeNotModified(w, v)
+	return v, true, true
+}
+
+// writeNotModified responds with a 304 carrying the validators.
+func (h *ImageHandler) writeNotModified(w http.ResponseWriter, v validators) {
+	h.setCacheHeaders(w, v)
+	w.WriteHeader(http.StatusNotModified)
+}
+
+// setCacheHeaders sets the validators and Cache-Control of an image response.
+func (h *ImageHandler) setCacheHeaders(w http.ResponseWriter, v validators) {
+	if v.ETag != "" {
+		w.Header().Set("ETag", v.ETag)
+	}
+	if !v.LastModified.IsZero() {
+		w.Header().Set("Last-Modified", v.LastModified.UTC().Format(http.TimeFormat))
+	}
+	if h.CacheControl != "" {
+		w.Header().Set("Cache-Control", h.CacheControl)
+	}
+}
+
+// notModified reports whether the client's copy is current. If-None-Match takes precedence over If-Modified-Since as
+// per RFC 7232.
+func notModified(r *http.Request, v validators) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return v.ETag != "" && etagMatch(inm, v.ETag)
+	}
+
+	ims := r.Header.Get("If-Modified-Since")
+	if ims == "" || v.LastModified.IsZero() {
+		return false
+	}
+	t, err := http.ParseTime(ims)
+	if err != nil {
+		return false
+	}
+	// Last-Modified only has second precision
+	return !v.LastModified.Truncate(time.Second).After(t)
+}
+
+// etagMatch reports whether the If-None-Match list matches etag, using weak comparison.
+func etagMatch(list, etag string) bool {
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
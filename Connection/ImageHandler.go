@@ -1,17 +1,21 @@
 package Connection
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"github.com/asatisomnath/ProgImage/Service"
 	"io"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 
-	"github.com/asatisomnath/ProgImage/Convertors/gif"
-	"github.com/asatisomnath/ProgImage/Convertors/jpeg"
-	"github.com/asatisomnath/ProgImage/Convertors/png"
+	primage "github.com/asatisomnath/ProgImage/Convertors"
+	_ "github.com/asatisomnath/ProgImage/Convertors/formats" // register formats, do not remove
+	"github.com/asatisomnath/ProgImage/Transformers/crop"
 	"github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
 )
 
 const maxReadBytes = 50 * 1024 * 1024 // 50mb
@@ -22,104 +26,341 @@ type ImageHandler struct {
 
 	Converters   map[string]Service.ImageTypeConverter
 	ImageService Service.ImageService
+	Variants     Service.VariantStore // optional, converted images are stored and reused when set
+	CacheControl string               // sent with images, empty to omit
+	// Negotiate are the extensions offered by Accept to GETs without one, most preferred first with the last as the
+	// fallback. Empty serves images as they're stored.
+	Negotiate []string
+	// EncodeDefaults and EncodeCaps are the Options defaults and caps of the converters, variants are keyed by the
+	// options they're encoded with
+	EncodeDefaults primage.Options
+	EncodeCaps     primage.Options
+	// Budget is the memory shared with the converters for decoded pixels, nil for no limit
+	Budget *primage.Budget
 }
 
 var _ http.Handler = ImageHandler{} // via httprouter.Router
 
-// NewImageHandler returns an initialised Convertors handler.
+// NewImageHandler returns an initialised image handler converting to the registered formats, converted images are
+// stored in is when it's also a Service.VariantStore.
 func NewImageHandler(is Service.ImageService) *ImageHandler {
-	h := ImageHandler{
+	vs, _ := is.(Service.VariantStore)
+	h := &ImageHandler{
 		Router:       httprouter.New(),
 		ImageService: is,
-		Converters: map[string]Service.ImageTypeConverter{
-			"png": png.Converter,
-			"jpg": jpeg.Converter,
-			"gif": gif.Converter,
-		},
+		Variants:     vs,
+		CacheControl: DefaultCacheControl,
+		Converters:   map[string]Service.ImageTypeConverter{},
+	}
+	for _, f := range Service.Formats() {
+		if f.Converter != nil {
+			h.Converters[f.Ext] = f.Converter
+		}
 	}
 	h.POST("/image/create", h.handleCreateImage)
 	h.GET("/image/:id", h.handleGetImage)
-	return &h
+	h.HEAD("/image/:id", h.handleHeadImage)
+	h.GET("/image/:id/meta", h.handleGetImageMeta)
+	h.DELETE("/image/:id", h.handleDeleteImage)
+	h.GET("/images", h.handleListImages)
+	h.GET("/image/:id/t/*steps", h.handleTransformImage)
+	h.POST("/transform/:id", h.handleTransformImage)
+	h.POST("/animations", h.handleCreateAnimation)
+	return h
 }
 
-func (h ImageHandler) handleCreateImage(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+func (h *ImageHandler) handleCreateImage(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
 	// don't allow an attacker to send an unlimited stream of bytes
 	lr := io.LimitReader(r.Body, maxReadBytes)
 
-	ID, err := h.ImageService.Upload(lr)
+	res, err := h.ImageService.Upload(lr)
 	if err != nil {
-		if err == Service.ErrUnrecognisedImageType {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+		uploadError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_, err = w.Write([]byte(fmt.Sprintf(`{"id": "%s", "deduplicated": %t}`, res.ID, res.Deduplicated)))
+	if err != nil {
+		log.Println("error writing handleCreateImage response", err.Error())
+	}
+}
+
+// uploadError answers err uploading an image.
+func uploadError(w http.ResponseWriter, err error) {
+	if Service.IsUnsupported(err) {
+		http.Error(w, fmt.Sprintf("%s, supported formats are %s", err, supportedFormats()), http.StatusUnsupportedMediaType)
+		return
+	}
+	if _, ok := err.(*Service.LimitError); ok {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if err == Service.ErrUnrecognisedImageType {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Cause(err) == Service.ErrImageTooLarge {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+func (h *ImageHandler) handleDeleteImage(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	ID := params.ByName("id")
+
+	if err := h.ImageService.Delete(ID); err != nil {
+		if err == Service.ErrImageNotFound {
+			http.Error(w, fmt.Sprintf("image %s not found", ID), http.StatusNotFound)
 			return
 		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *ImageHandler) handleListImages(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	q := r.URL.Query()
 
+	limit := 0
+	if l := q.Get("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil || limit < 1 {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+	}
+
+	list, err := h.ImageService.List(q.Get("cursor"), limit)
+	if err != nil {
+		if err == Service.ErrInvalidCursor {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	_, err = w.Write([]byte(fmt.Sprintf(`{"id": "%s"}`, ID)))
+	if err := json.NewEncoder(w).Encode(list); err != nil {
+		log.Println("error writing handleListImages response", err.Error())
+	}
+}
+
+func (h *ImageHandler) handleGetImageMeta(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	ID := params.ByName("id")
+
+	meta, err := h.ImageService.Meta(ID)
 	if err != nil {
-		log.Println("error writing handleCreateImage response", err.Error())
+		if err == Service.ErrImageNotFound {
+			http.Error(w, fmt.Sprintf("image %s not found", ID), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(meta); err != nil {
+		log.Println("error writing handleGetImageMeta response", err.Error())
 	}
 }
 
-func (h ImageHandler) handleGetImage(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+func (h *ImageHandler) handleGetImage(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
 	ID := params.ByName("id")
 
+	transformers, err := parseTransformers(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	s := strings.Split(ID, ".")
 	if len(s) == 2 {
-		h.handleGetImageWithExt(w, r, s[0], s[1])
+		h.handleGetImageWithExt(w, r, s[0], s[1], transformers)
+		return
+	}
+	if len(h.Negotiate) > 0 {
+		// the format depends on Accept, caches have to tell the responses apart by it
+		w.Header().Add("Vary", "Accept")
+		if ext := h.negotiateExt(r, ID); ext != "" {
+			h.handleGetImageWithExt(w, r, ID, ext, transformers)
+			return
+		}
+	}
+	if len(transformers) > 0 {
+		// transformed in its own format
+		h.handleGetImageWithExt(w, r, ID, "", transformers)
 		return
 	}
 
 	h.handleGetImageNoExt(w, r, ID)
 }
 
-func (h ImageHandler) handleGetImageNoExt(w http.ResponseWriter, r *http.Request, ID string) {
+func (h *ImageHandler) handleGetImageNoExt(w http.ResponseWriter, r *http.Request, ID string) {
+	cv, _, done := h.checkConditional(w, r, ID, "")
+	if done {
+		return
+	}
+
 	img, err := h.ImageService.Get(ID)
 	if err != nil {
 		if err == Service.ErrImageNotFound {
-			http.Error(w, fmt.Sprintf("Convertors %s not found", ID), http.StatusNotFound)
+			http.Error(w, fmt.Sprintf("image %s not found", ID), http.StatusNotFound)
 			return
 		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	defer closeImage(img)
 
 	w.Header().Set("Content-Type", img.ContentType)
-	_, err = io.Copy(w, img.Data)
+	h.setCacheHeaders(w, cv)
+	serveImage(w, r, img, cv, "handleGetImageNoExt")
+}
+
+// handleHeadImage describes an image without fetching it, converted images are converted to find their size.
+func (h *ImageHandler) handleHeadImage(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	ID := params.ByName("id")
+	if transformers, err := parseTransformers(r.URL.Query()); err != nil || len(transformers) > 0 || strings.Contains(ID, ".") || len(h.Negotiate) > 0 {
+		// the body is discarded by the server
+		h.handleGetImage(w, r, params)
+		return
+	}
+
+	meta, err := h.ImageService.Meta(ID)
 	if err != nil {
-		log.Println("error writing handleGetImageNoExt response", err.Error())
+		if err == Service.ErrImageNotFound {
+			http.Error(w, fmt.Sprintf("image %s not found", ID), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	cv := metaValidators(meta, "")
+	if notModified(r, cv) {
+		h.writeNotModified(w, cv)
+		return
 	}
+
+	w.Header().Set("Content-Type", meta.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(meta.Size, 10))
+	w.Header().Set("Accept-Ranges", "bytes")
+	h.setCacheHeaders(w, cv)
+	w.WriteHeader(http.StatusOK)
 }
 
-func (h ImageHandler) handleGetImageWithExt(w http.ResponseWriter, r *http.Request, ID, ext string) {
-	tr, ok := h.Converters[ext]
-	if !ok {
-		http.Error(w, "unsupported Convertors type", http.StatusBadRequest)
+// serveImage writes the image data, honouring Range requests when the data is seekable.
+func serveImage(w http.ResponseWriter, r *http.Request, img Service.Image, cv validators, handler string) {
+	if rs, ok := img.Data.(io.ReadSeeker); ok {
+		// the validators are already set so ServeContent handles If-Range using them
+		http.ServeContent(w, r, "", cv.LastModified, rs)
 		return
 	}
+	if _, err := io.Copy(w, img.Data); err != nil {
+		log.Printf("error writing %s response %s", handler, err.Error())
+	}
+}
+
+// handleGetImageWithExt converts the image to ext after applying the transformers, an empty ext keeps its format.
+func (h *ImageHandler) handleGetImageWithExt(w http.ResponseWriter, r *http.Request, ID, ext string, transformers []Service.Transformer) {
+	var tr Service.ImageTypeConverter
+	if ext != "" {
+		var ok bool
+		if ext, ok = h.canonicalExt(ext); !ok {
+			http.Error(w, "unsupported image type", http.StatusBadRequest)
+			return
+		}
+		tr = h.Converters[ext]
+	}
+
+	key := variantKey(ext, h.keyTransformers(transformers))
+	cv, found, done := h.checkConditional(w, r, ID, key)
+	if done {
+		return
+	}
+
+	// a variant stored as the original was deleted can be left behind, only serve one while the original exists
+	if h.Variants != nil && found {
+		v, err := h.Variants.GetVariant(ID, storageKey(key))
+		if err == nil {
+			defer closeImage(v)
+			w.Header().Set("Content-Type", v.ContentType)
+			h.setCacheHeaders(w, cv)
+			serveImage(w, r, v, cv, "handleGetImageWithExt")
+			return
+		}
+		if err != Service.ErrImageNotFound {
+			log.Printf("error getting %s variant of %s, %s", key, ID, err)
+		}
+	}
+
 	imgOrig, err := h.ImageService.Get(ID)
 	if err != nil {
 		if err == Service.ErrImageNotFound {
-			http.Error(w, fmt.Sprintf("Convertors %s not found", ID), http.StatusNotFound)
+			http.Error(w, fmt.Sprintf("image %s not found", ID), http.StatusNotFound)
 			return
 		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	defer closeImage(imgOrig)
+
+	if tr == nil {
+		var ok bool
+		if tr, ok = h.Converters[formatExt(imgOrig.ContentType)]; !ok {
+			http.Error(w, "unsupported image type", http.StatusBadRequest)
+			return
+		}
+	}
 
 	ec := make(chan error, 1)
-	imgConv, err := tr.Convert(imgOrig, ec)
+	imgConv, err := tr.Convert(imgOrig, ec, transformers...)
 	if err != nil {
+		if errors.Cause(err) == primage.ErrFrameOutOfRange {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, ok := errors.Cause(err).(*crop.OutsideError); ok {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, ok := errors.Cause(err).(*Service.LimitError); ok {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		if err == primage.ErrTooLarge {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		if err == primage.ErrOverBudget {
+			// other conversions should have finished by then
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	// unblocks the encoder if we stop reading early
+	defer closeImage(imgConv)
+
+	// keep a copy of the converted image to store, there's nothing to store when no conversion was needed
+	var out io.Writer = w
+	var buf *bytes.Buffer
+	if h.Variants != nil && (imgConv.ContentType != imgOrig.ContentType || len(transformers) > 0) {
+		buf = new(bytes.Buffer)
+		out = io.MultiWriter(w, buf)
+	}
 
 	w.Header().Set("Content-Type", imgConv.ContentType)
-	written, err := io.Copy(w, imgConv.Data)
+	h.setCacheHeaders(w, cv)
+	written, err := io.Copy(out, imgConv.Data)
 	if err != nil {
 		if written == 0 {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -132,5 +373,59 @@ func (h ImageHandler) handleGetImageWithExt(w http.ResponseWriter, r *http.Reque
 				imgOrig.ID,
 			)
 		}
+		return
+	}
+
+	if buf == nil {
+		return
+	}
+	// an encoding error closes the pipe early, don't store a truncated image
+	if err := <-ec; err != nil {
+		log.Printf("error converting %s to %s (id: %s), %s", imgOrig.ContentType, imgConv.ContentType, ID, err)
+		return
+	}
+	v := Service.Image{ID: ID, ContentType: imgConv.ContentType, Data: buf}
+	if err := h.Variants.PutVariant(ID, storageKey(key), v); err != nil {
+		log.Printf("error storing %s variant of %s, %s", key, ID, err)
+	}
+}
+
+// canonicalExt resolves ext, which can be an alias such as jpeg, to the extension of its converter. ok is false when
+// there's no converter for it.
+func (h *ImageHandler) canonicalExt(ext string) (string, bool) {
+	if _, ok := h.Converters[ext]; ok {
+		return ext, true
+	}
+	f, ok := Service.FormatByExt(ext)
+	if !ok {
+		return "", false
+	}
+	_, ok = h.Converters[f.Ext]
+	return f.Ext, ok
+}
+
+// formatExt is the extension of the registered format with the content type, empty when there isn't one.
+func formatExt(contentType string) string {
+	f, _ := Service.FormatByContentType(contentType)
+	return f.Ext
+}
+
+// supportedFormats lists the names of the registered formats images can be uploaded in.
+func supportedFormats() string {
+	var names []string
+	for _, f := range Service.Formats() {
+		if f.Decode != nil {
+			names = append(names, f.Name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// closeImage releases anything held open by the image data, eg a file.
+func closeImage(img Service.Image) {
+	if c, ok := img.Data.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			log.Println("error closing image data", err.Error())
+		}
 	}
 }
@@ -2,17 +2,29 @@ package Connection_test
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"github.com/asatisomnath/ProgImage/Service"
+	"hash/crc32"
+	"image"
+	"image/gif"
+	"image/png"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	pihttp "github.com/asatisomnath/ProgImage/Connection"
+	"github.com/asatisomnath/ProgImage/MemoryStorageService"
 	"github.com/asatisomnath/ProgImage/Mock"
+	"github.com/google/uuid"
 )
 
 // ImageHandler is test wrapper that uses a mocked image service.
@@ -24,6 +36,10 @@ type ImageHandler struct {
 
 func NewImageHandler() *ImageHandler {
 	is := new(Mock.ImageService)
+	// image GETs look up metadata for their cache headers, by default there's nothing to go on
+	is.MetaFunc = func(ID string) (Service.ImageMeta, error) {
+		return Service.ImageMeta{ID: ID}, nil
+	}
 	ih := pihttp.NewImageHandler(is)
 	return &ImageHandler{ih, is}
 }
@@ -147,9 +163,9 @@ func TestStore_OK(t *testing.T) {
 
 	expectedID := "foo"
 	dataIn := new(bytes.Buffer)
-	h.ImageService.StoreFunc = func(r io.Reader) (string, error) {
+	h.ImageService.StoreFunc = func(r io.Reader) (Service.UploadResult, error) {
 		io.Copy(dataIn, r)
-		return expectedID, nil
+		return Service.UploadResult{ID: expectedID}, nil
 	}
 
 	imgData := "some img data"
@@ -167,7 +183,8 @@ func TestStore_OK(t *testing.T) {
 	}
 
 	type respData struct {
-		ID string
+		ID           string
+		Deduplicated bool
 	}
 	rd := new(respData)
 	if err := json.NewDecoder(rr.Body).Decode(rd); err != nil {
@@ -177,17 +194,48 @@ func TestStore_OK(t *testing.T) {
 	if expectedID != rd.ID {
 		t.Errorf("expected id to be: %v got: %v", expectedID, rd)
 	}
+	if rd.Deduplicated {
+		t.Errorf("expected deduplicated to be false")
+	}
 
 	if imgData != dataIn.String() {
 		t.Errorf("expected data read to be '%s', got '%s'", imgData, dataIn.String())
 	}
 }
 
+func TestStore_Deduplicated(t *testing.T) {
+	h := NewImageHandler()
+
+	h.ImageService.StoreFunc = func(r io.Reader) (Service.UploadResult, error) {
+		return Service.UploadResult{ID: "foo", Deduplicated: true}, nil
+	}
+
+	req, err := http.NewRequest("POST", "/image/create", bytes.NewReader([]byte("some img data")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	h.ServeHTTP(rr, req)
+
+	rd := struct {
+		ID           string
+		Deduplicated bool
+	}{}
+	if err := json.NewDecoder(rr.Body).Decode(&rd); err != nil {
+		t.Fatal(err)
+	}
+	if rd.ID != "foo" || !rd.Deduplicated {
+		t.Errorf("expected deduplicated id foo, got: %+v", rd)
+	}
+}
+
 func TestStore_UnregognisedImagetype(t *testing.T) {
 	h := NewImageHandler()
 
-	h.ImageService.StoreFunc = func(r io.Reader) (string, error) {
-		return "", Service.ErrUnrecognisedImageType
+	h.ImageService.StoreFunc = func(r io.Reader) (Service.UploadResult, error) {
+		return Service.UploadResult{}, Service.ErrUnrecognisedImageType
 	}
 
 	req, err := http.NewRequest("POST", "/image/create", new(bytes.Reader))
@@ -203,3 +251,903 @@ func TestStore_UnregognisedImagetype(t *testing.T) {
 		t.Errorf("expected: %v got: %v", http.StatusBadRequest, status)
 	}
 }
+
+// TestStore_UnsupportedMediaType checks data that isn't in a supported format is refused with a 415 that says why.
+func TestStore_UnsupportedMediaType(t *testing.T) {
+	h := pihttp.NewImageHandler(MemoryStorageService.NewImageService(0, uuid.New))
+
+	for name, item := range map[string]struct {
+		Data   string
+		Status int
+		Body   string
+	}{
+		"heic":    {Data: "\x00\x00\x00\x18ftypheic\x00\x00\x00\x00mif1heic", Status: http.StatusUnsupportedMediaType, Body: "heic"},
+		"avif":    {Data: "\x00\x00\x00\x1cftypavif\x00\x00\x00\x00avifmif1miaf", Status: http.StatusUnsupportedMediaType, Body: "avif"},
+		"garbage": {Data: "not an image", Status: http.StatusUnsupportedMediaType, Body: "known"},
+		"broken":  {Data: "\x89PNG\r\n\x1a\n" + strings.Repeat("\xff", 64), Status: http.StatusBadRequest},
+	} {
+		t.Run(name, func(t *testing.T) {
+			req, err := http.NewRequest("POST", "/image/create", strings.NewReader(item.Data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+			if status := rr.Code; status != item.Status {
+				t.Errorf("expected: %v got: %v", item.Status, status)
+			}
+			if !strings.Contains(rr.Body.String(), item.Body) {
+				t.Errorf("expected %q in the response, got: %s", item.Body, rr.Body.String())
+			}
+		})
+	}
+}
+
+// TestStore_TooLarge checks an image whose header claims more pixels than are allowed is refused before it's decoded.
+func TestStore_TooLarge(t *testing.T) {
+	h := pihttp.NewImageHandler(MemoryStorageService.NewImageService(0, uuid.New))
+
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+	d := buf.Bytes()
+	// claim 50000x50000 in the IHDR chunk after the signature
+	binary.BigEndian.PutUint32(d[16:], 50000)
+	binary.BigEndian.PutUint32(d[20:], 50000)
+	binary.BigEndian.PutUint32(d[29:], crc32.ChecksumIEEE(d[12:29]))
+
+	req, err := http.NewRequest("POST", "/image/create", bytes.NewReader(d))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusUnprocessableEntity {
+		t.Errorf("expected: %v got: %v", http.StatusUnprocessableEntity, status)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	for name, item := range map[string]struct {
+		Err    error
+		Status int
+	}{
+		"ok":        {Err: nil, Status: http.StatusNoContent},
+		"not found": {Err: Service.ErrImageNotFound, Status: http.StatusNotFound},
+		"error":     {Err: errors.New("boom"), Status: http.StatusInternalServerError},
+	} {
+		t.Run(name, func(t *testing.T) {
+			h := NewImageHandler()
+
+			var deletedID string
+			h.ImageService.DeleteFunc = func(ID string) error {
+				deletedID = ID
+				return item.Err
+			}
+
+			req, err := http.NewRequest("DELETE", "/image/foo", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rr := httptest.NewRecorder()
+
+			h.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != item.Status {
+				t.Errorf("expected: %v got: %v", item.Status, status)
+			}
+			if deletedID != "foo" {
+				t.Errorf("expected id to be: foo got: %v", deletedID)
+			}
+		})
+	}
+}
+
+func TestList(t *testing.T) {
+	h := NewImageHandler()
+
+	var gotCursor string
+	var gotLimit int
+	h.ImageService.ListFunc = func(cursor string, limit int) (Service.ImageList, error) {
+		gotCursor, gotLimit = cursor, limit
+		if cursor == "bad" {
+			return Service.ImageList{}, Service.ErrInvalidCursor
+		}
+		return Service.ImageList{
+			Images: []Service.ImageInfo{{ID: "foo", Size: 10, ContentType: "image/png"}},
+			Cursor: "next",
+		}, nil
+	}
+
+	req, err := http.NewRequest("GET", "/images?cursor=abc&limit=10", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("expected: %v got: %v", http.StatusOK, status)
+	}
+	if gotCursor != "abc" || gotLimit != 10 {
+		t.Errorf("expected cursor abc and limit 10, got: %v and %v", gotCursor, gotLimit)
+	}
+
+	list := Service.ImageList{}
+	if err := json.NewDecoder(rr.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Images) != 1 || list.Images[0].ID != "foo" || list.Cursor != "next" {
+		t.Errorf("unexpected list %+v", list)
+	}
+
+	for _, q := range []string{"limit=0", "limit=foo", "cursor=bad"} {
+		req, err := http.NewRequest("GET", "/images?"+q, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("%s expected: %v got: %v", q, http.StatusBadRequest, status)
+		}
+	}
+}
+
+func TestGetMeta(t *testing.T) {
+	h := NewImageHandler()
+
+	h.ImageService.MetaFunc = func(ID string) (Service.ImageMeta, error) {
+		if ID != "foo" {
+			return Service.ImageMeta{}, Service.ErrImageNotFound
+		}
+		return Service.ImageMeta{ID: ID, Width: 640, Height: 480, Format: "png"}, nil
+	}
+
+	req, err := http.NewRequest("GET", "/image/foo/meta", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("expected: %v got: %v", http.StatusOK, status)
+	}
+	meta := Service.ImageMeta{}
+	if err := json.NewDecoder(rr.Body).Decode(&meta); err != nil {
+		t.Fatal(err)
+	}
+	if meta.ID != "foo" || meta.Width != 640 || meta.Height != 480 || meta.Format != "png" {
+		t.Errorf("unexpected meta %+v", meta)
+	}
+
+	req, err = http.NewRequest("GET", "/image/bar/meta", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("expected: %v got: %v", http.StatusNotFound, status)
+	}
+}
+
+// TestUploadConvertDownload runs the whole upload, convert and download path against an in-memory store.
+func TestUploadConvertDownload(t *testing.T) {
+	h := pihttp.NewImageHandler(MemoryStorageService.NewImageService(0, uuid.New))
+
+	fp, err := os.Open("../testimages/test.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fp.Close()
+
+	req, err := http.NewRequest("POST", "/image/create", fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusCreated {
+		t.Fatalf("expected: %v got: %v", http.StatusCreated, status)
+	}
+
+	rd := struct{ ID string }{}
+	if err := json.NewDecoder(rr.Body).Decode(&rd); err != nil {
+		t.Fatal(err)
+	}
+
+	for ext, typ := range map[string]string{"": "jpeg", ".png": "png", ".gif": "gif", ".bmp": "bmp", ".tiff": "tiff", ".webp": "webp"} {
+		req, err := http.NewRequest("GET", "/image/"+rd.ID+ext, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("expected: %v got: %v", http.StatusOK, status)
+		}
+
+		if rr.Header().Get("Content-Type") != "image/"+typ {
+			t.Errorf("expected Content-Type image/%s, got: %v", typ, rr.Header().Get("Content-Type"))
+		}
+		if _, got, err := image.Decode(rr.Body); err != nil {
+			t.Error(err)
+		} else if got != typ {
+			t.Errorf("expected downloaded image to be %s, got %s", typ, got)
+		}
+	}
+}
+
+// TestUploadTiff checks TIFFs, as scanners produce, are stored as they are and can be converted.
+func TestUploadTiff(t *testing.T) {
+	h := pihttp.NewImageHandler(MemoryStorageService.NewImageService(0, uuid.New))
+
+	fp, err := os.Open("../testimages/test.tiff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fp.Close()
+
+	req, err := http.NewRequest("POST", "/image/create", fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusCreated {
+		t.Fatalf("expected: %v got: %v", http.StatusCreated, status)
+	}
+	rd := struct{ ID string }{}
+	if err := json.NewDecoder(rr.Body).Decode(&rd); err != nil {
+		t.Fatal(err)
+	}
+
+	for ext, typ := range map[string]string{"": "tiff", ".png": "png"} {
+		req, err := http.NewRequest("GET", "/image/"+rd.ID+ext, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("expected: %v got: %v", http.StatusOK, status)
+		}
+		if rr.Header().Get("Content-Type") != "image/"+typ {
+			t.Errorf("expected Content-Type image/%s, got: %v", typ, rr.Header().Get("Content-Type"))
+		}
+		if _, got, err := image.Decode(rr.Body); err != nil {
+			t.Error(err)
+		} else if got != typ {
+			t.Errorf("expected downloaded image to be %s, got %s", typ, got)
+		}
+	}
+}
+
+// TestGet_Alias checks extensions are matched without regard to case and aliases are served as their format.
+func TestGet_Alias(t *testing.T) {
+	is := MemoryStorageService.NewImageService(0, uuid.New)
+	h := pihttp.NewImageHandler(is)
+
+	d, err := ioutil.ReadFile("../testimages/test.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := is.Upload(bytes.NewReader(d))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for ext, typ := range map[string]string{".jpeg": "jpeg", ".JPG": "jpeg", ".tif": "tiff", ".PNG": "png"} {
+		req, err := http.NewRequest("GET", "/image/"+res.ID+ext, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("%s expected: %v got: %v", ext, http.StatusOK, status)
+		}
+		if rr.Header().Get("Content-Type") != "image/"+typ {
+			t.Errorf("%s expected Content-Type image/%s, got: %v", ext, typ, rr.Header().Get("Content-Type"))
+		}
+		if _, got, err := image.Decode(rr.Body); err != nil {
+			t.Error(err)
+		} else if got != typ {
+			t.Errorf("%s expected downloaded image to be %s, got %s", ext, typ, got)
+		}
+	}
+}
+
+// TestVariantStored checks converted images are stored and served again, and removed with the original.
+func TestVariantStored(t *testing.T) {
+	is := MemoryStorageService.NewImageService(0, uuid.New)
+	h := pihttp.NewImageHandler(is)
+
+	d, err := ioutil.ReadFile("../testimages/test.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := is.Upload(bytes.NewReader(d))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func() *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", "/image/"+res.ID+".gif", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	first := get()
+	if status := first.Code; status != http.StatusOK {
+		t.Fatalf("expected: %v got: %v", http.StatusOK, status)
+	}
+
+	v, err := is.GetVariant(res.ID, storageKey("gif"))
+	if err != nil {
+		t.Fatalf("expected variant to be stored, got %s", err)
+	}
+	stored, err := ioutil.ReadAll(v.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(stored, first.Body.Bytes()) {
+		t.Error("expected stored variant to equal the converted response")
+	}
+
+	// swap the variant to prove it's what gets served
+	if err := is.PutVariant(res.ID, storageKey("gif"), Service.Image{ContentType: "image/gif", Data: strings.NewReader("stored")}); err != nil {
+		t.Fatal(err)
+	}
+	if second := get(); second.Body.String() != "stored" || second.Header().Get("Content-Type") != "image/gif" {
+		t.Errorf("expected stored variant to be served, got %q", second.Body.String())
+	}
+
+	req, err := http.NewRequest("DELETE", "/image/"+res.ID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if rr := get(); rr.Code != http.StatusNotFound {
+		t.Errorf("expected: %v got: %v", http.StatusNotFound, rr.Code)
+	}
+}
+
+func TestGet_Conditional(t *testing.T) {
+	h := NewImageHandler()
+
+	uploaded := time.Date(2020, 3, 1, 12, 30, 15, 500, time.UTC)
+	h.ImageService.MetaFunc = func(ID string) (Service.ImageMeta, error) {
+		if ID != "foo" {
+			return Service.ImageMeta{}, Service.ErrImageNotFound
+		}
+		return Service.ImageMeta{ID: ID, SHA256: "abc", Uploaded: uploaded}, nil
+	}
+	h.ImageService.GetFunc = func(ID string) (Service.Image, error) {
+		fp, err := os.Open("../testimages/test.jpg")
+		if err != nil {
+			return Service.Image{}, err
+		}
+		return Service.Image{ID: ID, Data: fp, ContentType: "image/jpeg"}, nil
+	}
+
+	get := func(path string, header http.Header) *httptest.ResponseRecorder {
+		h.ImageService.GetInvoked = false
+		req, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	lastModified := "Sun, 01 Mar 2020 12:30:15 GMT"
+	tests := []struct {
+		Name   string
+		Path   string
+		Header http.Header
+		Status int
+		ETag   string
+	}{
+		{Name: "plain", Path: "/image/foo", Status: http.StatusOK, ETag: `"abc"`},
+		{Name: "converted", Path: "/image/foo.png", Status: http.StatusOK, ETag: `"abc.png"`},
+		{Name: "etag match", Path: "/image/foo", Header: http.Header{"If-None-Match": {`"abc"`}}, Status: http.StatusNotModified, ETag: `"abc"`},
+		{Name: "etag in list", Path: "/image/foo.png", Header: http.Header{"If-None-Match": {`"xyz", W/"abc.png"`}}, Status: http.StatusNotModified, ETag: `"abc.png"`},
+		{Name: "etag of other format", Path: "/image/foo.png", Header: http.Header{"If-None-Match": {`"abc"`}}, Status: http.StatusOK, ETag: `"abc.png"`},
+		{Name: "etag star", Path: "/image/foo.gif", Header: http.Header{"If-None-Match": {"*"}}, Status: http.StatusNotModified, ETag: `"abc.gif"`},
+		{Name: "modified since", Path: "/image/foo", Header: http.Header{"If-Modified-Since": {lastModified}}, Status: http.StatusNotModified, ETag: `"abc"`},
+		{Name: "modified before", Path: "/image/foo", Header: http.Header{"If-Modified-Since": {"Sun, 01 Mar 2020 12:30:14 GMT"}}, Status: http.StatusOK, ETag: `"abc"`},
+		{
+			Name:   "etag takes precedence",
+			Path:   "/image/foo",
+			Header: http.Header{"If-None-Match": {`"xyz"`}, "If-Modified-Since": {lastModified}},
+			Status: http.StatusOK,
+			ETag:   `"abc"`,
+		},
+	}
+	for _, item := range tests {
+		t.Run(item.Name, func(t *testing.T) {
+			rr := get(item.Path, item.Header)
+			if rr.Code != item.Status {
+				t.Errorf("expected: %v got: %v", item.Status, rr.Code)
+			}
+			if etag := rr.Header().Get("ETag"); etag != item.ETag {
+				t.Errorf("expected ETag %s, got %s", item.ETag, etag)
+			}
+			if lm := rr.Header().Get("Last-Modified"); lm != lastModified {
+				t.Errorf("expected Last-Modified %s, got %s", lastModified, lm)
+			}
+			if cc := rr.Header().Get("Cache-Control"); cc != pihttp.DefaultCacheControl {
+				t.Errorf("expected Cache-Control %s, got %s", pihttp.DefaultCacheControl, cc)
+			}
+			if notModified := item.Status == http.StatusNotModified; notModified == h.ImageService.GetInvoked {
+				t.Errorf("expected image to be fetched only when modified, fetched: %t", h.ImageService.GetInvoked)
+			}
+			if item.Status == http.StatusNotModified && rr.Body.Len() != 0 {
+				t.Errorf("expected empty body, got %d bytes", rr.Body.Len())
+			}
+		})
+	}
+
+	h.CacheControl = "no-cache"
+	if cc := get("/image/foo", nil).Header().Get("Cache-Control"); cc != "no-cache" {
+		t.Errorf("expected Cache-Control no-cache, got %s", cc)
+	}
+
+	if rr := get("/image/bar", nil); rr.Code != http.StatusNotFound || h.ImageService.GetInvoked {
+		t.Errorf("expected: %v without fetching, got: %v", http.StatusNotFound, rr.Code)
+	}
+}
+
+// TestGet_ETagEncoding checks ETags follow the options images are encoded with, after the server's defaults and caps.
+func TestGet_ETagEncoding(t *testing.T) {
+	h := NewImageHandler()
+	h.ImageService.MetaFunc = func(ID string) (Service.ImageMeta, error) {
+		return Service.ImageMeta{ID: ID, SHA256: "abc"}, nil
+	}
+	etag := func(path string) string {
+		req, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("If-None-Match", "*")
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if rr.Code != http.StatusNotModified {
+			t.Fatalf("%s expected: %v got: %v", path, http.StatusNotModified, rr.Code)
+		}
+		return rr.Header().Get("ETag")
+	}
+
+	plain := etag("/image/foo.jpg")
+	h.EncodeDefaults.Quality = 80
+	if got := etag("/image/foo.jpg"); got == plain {
+		t.Errorf("expected the default quality to change the ETag, got %s", got)
+	}
+	if got, explicit := etag("/image/foo.jpg"), etag("/image/foo.jpg?q=80"); got != explicit {
+		t.Errorf("expected the default quality to match asking for it, got %s and %s", got, explicit)
+	}
+
+	h.EncodeCaps.Quality = 50
+	if a, b := etag("/image/foo.jpg?q=60"), etag("/image/foo.jpg?q=70"); a != b || a != etag("/image/foo.jpg?q=50") {
+		t.Errorf("expected qualities capped to 50 to share an ETag, got %s and %s", a, b)
+	}
+}
+
+func TestGet_Range(t *testing.T) {
+	is := MemoryStorageService.NewImageService(0, uuid.New)
+	h := pihttp.NewImageHandler(is)
+
+	d, err := ioutil.ReadFile("../testimages/test.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := is.Upload(bytes.NewReader(d))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(rng string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", "/image/"+res.ID, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rng != "" {
+			req.Header.Set("Range", rng)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	full := get("")
+	if full.Code != http.StatusOK || !bytes.Equal(full.Body.Bytes(), d) {
+		t.Errorf("expected full image with %v, got %v", http.StatusOK, full.Code)
+	}
+	if ar := full.Header().Get("Accept-Ranges"); ar != "bytes" {
+		t.Errorf("expected Accept-Ranges bytes, got %s", ar)
+	}
+
+	partial := get("bytes=100-149")
+	if partial.Code != http.StatusPartialContent {
+		t.Errorf("expected: %v got: %v", http.StatusPartialContent, partial.Code)
+	}
+	if cr := partial.Header().Get("Content-Range"); cr != "bytes 100-149/8408" {
+		t.Errorf("expected Content-Range bytes 100-149/8408, got %s", cr)
+	}
+	if !bytes.Equal(partial.Body.Bytes(), d[100:150]) {
+		t.Error("expected partial body to match the requested range")
+	}
+	if ct := partial.Header().Get("Content-Type"); ct != "image/png" {
+		t.Errorf("expected Content-Type image/png, got %s", ct)
+	}
+
+	multi := get("bytes=0-7,100-149")
+	if multi.Code != http.StatusPartialContent {
+		t.Errorf("expected: %v got: %v", http.StatusPartialContent, multi.Code)
+	}
+	if ct := multi.Header().Get("Content-Type"); !strings.HasPrefix(ct, "multipart/byteranges") {
+		t.Errorf("expected multipart/byteranges, got %s", ct)
+	}
+
+	if rr := get("bytes=9000-"); rr.Code != http.StatusRequestedRangeNotSatisfiable {
+		t.Errorf("expected: %v got: %v", http.StatusRequestedRangeNotSatisfiable, rr.Code)
+	}
+}
+
+func TestHead(t *testing.T) {
+	h := NewImageHandler()
+
+	h.ImageService.MetaFunc = func(ID string) (Service.ImageMeta, error) {
+		if ID != "foo" {
+			return Service.ImageMeta{}, Service.ErrImageNotFound
+		}
+		return Service.ImageMeta{ID: ID, ContentType: "image/png", Size: 8408, SHA256: "abc"}, nil
+	}
+
+	head := func(path string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("HEAD", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := head("/image/foo")
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected: %v got: %v", http.StatusOK, rr.Code)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "image/png" {
+		t.Errorf("expected Content-Type image/png, got %s", ct)
+	}
+	if cl := rr.Header().Get("Content-Length"); cl != "8408" {
+		t.Errorf("expected Content-Length 8408, got %s", cl)
+	}
+	if ar := rr.Header().Get("Accept-Ranges"); ar != "bytes" {
+		t.Errorf("expected Accept-Ranges bytes, got %s", ar)
+	}
+	if etag := rr.Header().Get("ETag"); etag != `"abc"` {
+		t.Errorf(`expected ETag "abc", got %s`, etag)
+	}
+	if rr.Body.Len() != 0 {
+		t.Errorf("expected no body, got %d bytes", rr.Body.Len())
+	}
+	if h.ImageService.GetInvoked {
+		t.Error("expected image not to be fetched")
+	}
+
+	if rr := head("/image/bar"); rr.Code != http.StatusNotFound {
+		t.Errorf("expected: %v got: %v", http.StatusNotFound, rr.Code)
+	}
+}
+
+func TestGet_Resize(t *testing.T) {
+	is := MemoryStorageService.NewImageService(0, uuid.New)
+	h := pihttp.NewImageHandler(is)
+
+	d, err := ioutil.ReadFile("../testimages/test.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := is.Upload(bytes.NewReader(d))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	tests := []struct {
+		Name          string
+		Query         string
+		Ext           string
+		ContentType   string
+		Width, Height int
+	}{
+		{Name: "width", Query: "w=200", ContentType: "image/jpeg", Width: 200, Height: 150},
+		{Name: "with ext", Query: "w=200&filter=nearest", Ext: ".png", ContentType: "image/png", Width: 200, Height: 150},
+		{Name: "cover", Query: "w=100&h=100&fit=cover", ContentType: "image/jpeg", Width: 100, Height: 100},
+		{Name: "contain", Query: "w=100&h=100&fit=contain&filter=bilinear", Ext: ".gif", ContentType: "image/gif", Width: 100, Height: 100},
+		{Name: "inside", Query: "w=100&h=100&fit=inside&filter=catmullrom", ContentType: "image/jpeg", Width: 100, Height: 75},
+		{Name: "crop", Query: "crop=100,200,300,400", Ext: ".png", ContentType: "image/png", Width: 300, Height: 400},
+		{Name: "crop then resize", Query: "crop=0,0,400,400&w=100", ContentType: "image/jpeg", Width: 100, Height: 100},
+		{Name: "smart crop", Query: "crop=smart&aspect=1:1&w=100", ContentType: "image/jpeg", Width: 100, Height: 100},
+		{Name: "smart crop to size", Query: "crop=smart&w=160&h=90", ContentType: "image/jpeg", Width: 160, Height: 90},
+		{Name: "rotate", Query: "rotate=90&w=300", Ext: ".png", ContentType: "image/png", Width: 300, Height: 400},
+		{Name: "flip", Query: "flip=h&orient=keep&w=200", ContentType: "image/jpeg", Width: 200, Height: 150},
+		{Name: "quality", Query: "q=20", ContentType: "image/jpeg", Width: 1920, Height: 1440},
+		{Name: "options", Query: "w=100&compression=speed&colors=16", Ext: ".png", ContentType: "image/png", Width: 100, Height: 75},
+		{Name: "dithered", Query: "w=100&colors=16&quantizer=mediancut&dither=bayer", Ext: ".gif", ContentType: "image/gif", Width: 100, Height: 75},
+	}
+	for _, item := range tests {
+		t.Run(item.Name, func(t *testing.T) {
+			rr := get("/image/" + res.ID + item.Ext + "?" + item.Query)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("expected: %v got: %v, %s", http.StatusOK, rr.Code, rr.Body.String())
+			}
+			if ct := rr.Header().Get("Content-Type"); ct != item.ContentType {
+				t.Errorf("expected Content-Type %s, got %s", item.ContentType, ct)
+			}
+			cfg, _, err := image.DecodeConfig(rr.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Width != item.Width || cfg.Height != item.Height {
+				t.Errorf("expected %dx%d, got %dx%d", item.Width, item.Height, cfg.Width, cfg.Height)
+			}
+		})
+	}
+
+	// resized copies are stored separately from plain conversions
+	if _, err := is.GetVariant(res.ID, storageKey("resize:w=200,h=0,fit=cover,filter=nearest.png")); err != nil {
+		t.Errorf("expected resized variant to be stored, got %v", err)
+	}
+	if _, err := is.GetVariant(res.ID, storageKey("png")); err != Service.ErrImageNotFound {
+		t.Errorf("expected no plain png variant, got %v", err)
+	}
+	if _, err := is.GetVariant(res.ID, storageKey("encode:q=20")); err != nil {
+		t.Errorf("expected re-encoded variant to be stored, got %v", err)
+	}
+	etag := get("/image/" + res.ID + ".png?w=200&filter=nearest").Header().Get("ETag")
+	if etag == get("/image/"+res.ID+".png").Header().Get("ETag") {
+		t.Error("expected resized and plain conversions to have different ETags")
+	}
+
+	for _, q := range []string{"w=abc", "w=0", "w=-5", "w=100000", "fit=cover", "w=10&fit=stretch", "w=10&filter=box", "crop=1,2,3", "crop=0,0,0,10", "crop=smart", "crop=smart&w=10", "crop=smart&aspect=4", "aspect=1:1", "rotate=45", "flip=x", "orient=auto", "q=0", "q=abc", "compression=max", "colors=1000", "quantizer=octree", "dither=random"} {
+		if rr := get("/image/" + res.ID + "?" + q); rr.Code != http.StatusBadRequest {
+			t.Errorf("expected %v for %s, got: %v", http.StatusBadRequest, q, rr.Code)
+		}
+	}
+
+	// over Service.ImageLimits, the second only on the way to its output
+	for _, q := range []string{"w=10000&h=10000", "w=10000&h=1&fit=cover"} {
+		if rr := get("/image/" + res.ID + "?" + q); rr.Code != http.StatusUnprocessableEntity {
+			t.Errorf("expected %v for %s, got: %v", http.StatusUnprocessableEntity, q, rr.Code)
+		}
+	}
+}
+
+// TestGet_CropOutside checks a crop that misses the image is the client's mistake.
+func TestGet_CropOutside(t *testing.T) {
+	is := MemoryStorageService.NewImageService(0, uuid.New)
+	h := pihttp.NewImageHandler(is)
+
+	d, err := ioutil.ReadFile("../testimages/test.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := is.Upload(bytes.NewReader(d))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"?crop=5000,0,10,10", ".jpg?crop=0,5000,10,10", "/t/crop:x=5000,y=0,w=10,h=10"} {
+		req, err := http.NewRequest("GET", "/image/"+res.ID+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected %v for %s, got: %v", http.StatusBadRequest, path, rr.Code)
+		}
+		if !strings.Contains(rr.Body.String(), "outside the 1000x476 image") {
+			t.Errorf("expected the image size in the error, got: %s", rr.Body.String())
+		}
+	}
+}
+
+func TestGet_Animated(t *testing.T) {
+	is := MemoryStorageService.NewImageService(0, uuid.New)
+	h := pihttp.NewImageHandler(is)
+
+	d, err := ioutil.ReadFile("../testimages/test.gif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := is.Upload(bytes.NewReader(d))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := get("/image/" + res.ID + "?w=32&colors=64")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected: %v got: %v, %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	g, err := gif.DecodeAll(rr.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Image) != 35 || g.Config.Width != 32 || g.Config.Height != 32 {
+		t.Errorf("expected 35 32x32 frames, got %d %dx%d", len(g.Image), g.Config.Width, g.Config.Height)
+	}
+	if g.Delay[0] != 4 || g.Disposal[0] != gif.DisposalBackground {
+		t.Errorf("expected timing to be kept, got delay %d disposal %d", g.Delay[0], g.Disposal[0])
+	}
+
+	rr = get("/image/" + res.ID + ".png?frame=10")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected: %v got: %v, %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	if _, typ, err := image.Decode(rr.Body); err != nil || typ != "png" {
+		t.Errorf("expected a png still, got %s %v", typ, err)
+	}
+
+	for _, q := range []string{".png?frame=35", "?frame=-1", "?frame=x"} {
+		if rr := get("/image/" + res.ID + q); rr.Code != http.StatusBadRequest {
+			t.Errorf("expected %v for %s, got: %v", http.StatusBadRequest, q, rr.Code)
+		}
+	}
+}
+
+func TestTransform(t *testing.T) {
+	is := MemoryStorageService.NewImageService(0, uuid.New)
+	h := pihttp.NewImageHandler(is)
+
+	d, err := ioutil.ReadFile("../testimages/test.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := is.Upload(bytes.NewReader(d))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	tests := []struct {
+		Name          string
+		Method        string
+		Path          string
+		Body          string
+		ContentType   string
+		Width, Height int
+	}{
+		{Name: "path", Method: "GET", Path: "/image/" + res.ID + "/t/resize:w=400/resize:h=100,fit=inside/format:png", ContentType: "image/png", Width: 133, Height: 100},
+		{Name: "keep format", Method: "GET", Path: "/image/" + res.ID + "/t/resize:200", ContentType: "image/jpeg", Width: 200, Height: 150},
+		{Name: "ext", Method: "GET", Path: "/image/" + res.ID + ".gif/t/resize:200", ContentType: "image/gif", Width: 200, Height: 150},
+		{
+			Name:        "post",
+			Method:      "POST",
+			Path:        "/transform/" + res.ID,
+			Body:        `{"steps": ["resize:w=300", {"op": "resize", "w": 100, "h": 100, "fit": "cover"}, {"op": "format", "type": "png"}]}`,
+			ContentType: "image/png",
+			Width:       100,
+			Height:      100,
+		},
+	}
+	for _, item := range tests {
+		t.Run(item.Name, func(t *testing.T) {
+			rr := do(item.Method, item.Path, item.Body)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("expected: %v got: %v, %s", http.StatusOK, rr.Code, rr.Body.String())
+			}
+			if ct := rr.Header().Get("Content-Type"); ct != item.ContentType {
+				t.Errorf("expected Content-Type %s, got %s", item.ContentType, ct)
+			}
+			cfg, _, err := image.DecodeConfig(rr.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Width != item.Width || cfg.Height != item.Height {
+				t.Errorf("expected %dx%d, got %dx%d", item.Width, item.Height, cfg.Width, cfg.Height)
+			}
+		})
+	}
+
+	// the same steps through either route are the same variant
+	path := do("GET", "/image/"+res.ID+"/t/resize:w=300/format:png", "")
+	post := do("POST", "/transform/"+res.ID, `{"steps": [{"op": "resize", "w": 300}, "format:png"]}`)
+	if path.Header().Get("ETag") == "" || path.Header().Get("ETag") != post.Header().Get("ETag") {
+		t.Errorf("expected matching ETags, got %s and %s", path.Header().Get("ETag"), post.Header().Get("ETag"))
+	}
+
+	errTests := []struct {
+		Name   string
+		Method string
+		Path   string
+		Body   string
+		Index  int
+		Step   string
+	}{
+		{Name: "unknown op", Method: "GET", Path: "/image/" + res.ID + "/t/resize:w=300/blur:5", Index: 1, Step: "blur:5"},
+		{Name: "bad arg", Method: "GET", Path: "/image/" + res.ID + "/t/resize:w=abc", Index: 0, Step: "resize:w=abc"},
+		{Name: "ext conflict", Method: "GET", Path: "/image/" + res.ID + ".gif/t/resize:w=10/format:png", Index: 1, Step: "format:png"},
+		{Name: "post", Method: "POST", Path: "/transform/" + res.ID, Body: `{"steps": ["resize:w=10", {"op": "format", "type": "heic"}]}`, Index: 1, Step: `{"op": "format", "type": "heic"}`},
+	}
+	for _, item := range errTests {
+		t.Run(item.Name, func(t *testing.T) {
+			rr := do(item.Method, item.Path, item.Body)
+			if rr.Code != http.StatusBadRequest {
+				t.Fatalf("expected: %v got: %v", http.StatusBadRequest, rr.Code)
+			}
+			se := struct {
+				Error   string
+				Index   int
+				Step    string
+				Message string
+			}{}
+			if err := json.NewDecoder(rr.Body).Decode(&se); err != nil {
+				t.Fatal(err)
+			}
+			if se.Error != "invalid step" || se.Index != item.Index || se.Step != item.Step || se.Message == "" {
+				t.Errorf("unexpected error %+v", se)
+			}
+		})
+	}
+
+	if rr := do("POST", "/transform/"+res.ID, "not json"); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected: %v got: %v", http.StatusBadRequest, rr.Code)
+	}
+	if rr := do("GET", "/image/foo/t/resize:w=10", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected: %v got: %v", http.StatusNotFound, rr.Code)
+	}
+}
+
+// storageKey is what the handler stores the variant with the key under.
+func storageKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
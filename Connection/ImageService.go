@@ -4,11 +4,17 @@ import (
 	"encoding/json"
 	"github.com/asatisomnath/ProgImage/Service"
 	"io"
+	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strconv"
 
 	"github.com/pkg/errors"
 )
 
+// ErrRangeNotSatisfiable represents a ranged read starting beyond the end of the image.
+var ErrRangeNotSatisfiable = errors.New("range not satisfiable")
+
 type GetterDoer interface {
 	Get(string) (*http.Response, error)
 	Do(r *http.Request) (*http.Response, error)
@@ -37,33 +43,168 @@ func (is ImageService) Get(ID string) (Service.Image, error) {
 	}
 
 	ret.ID = ID
-	ret.ContentType = resp.Header.Get("ContentType")
+	ret.ContentType = resp.Header.Get("Content-Type")
 	ret.Data = resp.Body
 	return ret, nil
 }
 
+// GetRange gets length bytes of the image for the given ID starting at offset, a length of 0 reads to the end.
+func (is ImageService) GetRange(ID string, offset, length int64) (Service.Image, error) {
+	ret := Service.Image{}
+	if offset < 0 || length < 0 {
+		return ret, errors.Errorf("invalid range, offset %d length %d", offset, length)
+	}
+
+	req, err := http.NewRequest("GET", is.BaseURL+"/image/"+ID, nil)
+	if err != nil {
+		return ret, errors.Wrap(err, "unable to create new Connection request")
+	}
+	rng := "bytes=" + strconv.FormatInt(offset, 10) + "-"
+	if length > 0 {
+		rng += strconv.FormatInt(offset+length-1, 10)
+	}
+	req.Header.Set("Range", rng)
+
+	resp, err := is.Client.Do(req)
+	if err != nil {
+		return ret, errors.Wrap(err, "unable to make get request")
+	}
+
+	var data io.Reader = resp.Body
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		// the server ignored the range, skip to it ourselves
+		if _, err := io.CopyN(ioutil.Discard, resp.Body, offset); err != nil {
+			resp.Body.Close() // nolint: errcheck,gas
+			if err == io.EOF {
+				return ret, ErrRangeNotSatisfiable
+			}
+			return ret, errors.Wrap(err, "error skipping to range")
+		}
+		if length > 0 {
+			data = struct {
+				io.Reader
+				io.Closer
+			}{io.LimitReader(resp.Body, length), resp.Body}
+		}
+	case http.StatusNotFound:
+		resp.Body.Close() // nolint: errcheck,gas
+		return ret, Service.ErrImageNotFound
+	case http.StatusRequestedRangeNotSatisfiable:
+		resp.Body.Close() // nolint: errcheck,gas
+		return ret, ErrRangeNotSatisfiable
+	default:
+		resp.Body.Close() // nolint: errcheck,gas
+		return ret, errors.Errorf("unknown error getting image range, status code %d", resp.StatusCode)
+	}
+
+	ret.ID = ID
+	ret.ContentType = resp.Header.Get("Content-Type")
+	ret.Data = data
+	return ret, nil
+}
+
 // Store an Convertors.
-func (is ImageService) Upload(imgRdr io.Reader) (string, error) {
+func (is ImageService) Upload(imgRdr io.Reader) (Service.UploadResult, error) {
+	ret := Service.UploadResult{}
 	req, err := http.NewRequest("POST", is.BaseURL+"/image/create", imgRdr)
 	if err != nil {
-		return "", errors.Wrap(err, "unable to create new Connection request")
+		return ret, errors.Wrap(err, "unable to create new Connection request")
 	}
 	resp, err := is.Client.Do(req)
 	if err != nil {
-		return "", errors.Wrap(err, "unable to make post request")
+		return ret, errors.Wrap(err, "unable to make post request")
 	}
 	if resp.StatusCode != http.StatusCreated {
-		return "", errors.Errorf("unknown error creating new image, status code %d", resp.StatusCode)
+		return ret, errors.Errorf("unknown error creating new image, status code %d", resp.StatusCode)
 	}
 
 	rd := new(respData)
 	if err := json.NewDecoder(resp.Body).Decode(rd); err != nil {
-		return "", errors.Wrap(err, "error decoding resp")
+		return ret, errors.Wrap(err, "error decoding resp")
+	}
+
+	ret.ID = rd.ID
+	ret.Deduplicated = rd.Deduplicated
+	return ret, nil
+}
+
+// Delete the image with the given ID.
+func (is ImageService) Delete(ID string) error {
+	req, err := http.NewRequest("DELETE", is.BaseURL+"/image/"+ID, nil)
+	if err != nil {
+		return errors.Wrap(err, "unable to create new Connection request")
+	}
+	resp, err := is.Client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "unable to make delete request")
 	}
+	defer resp.Body.Close() // nolint: errcheck
 
-	return rd.ID, nil
+	switch resp.StatusCode {
+	case http.StatusNoContent:
+		return nil
+	case http.StatusNotFound:
+		return Service.ErrImageNotFound
+	default:
+		return errors.Errorf("unknown error deleting image, status code %d", resp.StatusCode)
+	}
+}
+
+// Meta gets the metadata of the image with the given ID.
+func (is ImageService) Meta(ID string) (Service.ImageMeta, error) {
+	ret := Service.ImageMeta{}
+	resp, err := is.Client.Get(is.BaseURL + "/image/" + ID + "/meta")
+	if err != nil {
+		return ret, errors.Wrap(err, "unable to make get request")
+	}
+	defer resp.Body.Close() // nolint: errcheck
+
+	if resp.StatusCode != http.StatusOK {
+		if resp.StatusCode == http.StatusNotFound {
+			return ret, Service.ErrImageNotFound
+		}
+		return ret, errors.Errorf("unknown error getting image metadata, status code %d", resp.StatusCode)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&ret); err != nil {
+		return ret, errors.Wrap(err, "error decoding resp")
+	}
+	return ret, nil
+}
+
+// List a page of stored images, pass the returned cursor to get the next page.
+func (is ImageService) List(cursor string, limit int) (Service.ImageList, error) {
+	ret := Service.ImageList{}
+	q := url.Values{}
+	if cursor != "" {
+		q.Set("cursor", cursor)
+	}
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+
+	resp, err := is.Client.Get(is.BaseURL + "/images?" + q.Encode())
+	if err != nil {
+		return ret, errors.Wrap(err, "unable to make get request")
+	}
+	defer resp.Body.Close() // nolint: errcheck
+
+	if resp.StatusCode != http.StatusOK {
+		if resp.StatusCode == http.StatusBadRequest {
+			return ret, Service.ErrInvalidCursor
+		}
+		return ret, errors.Errorf("unknown error listing images, status code %d", resp.StatusCode)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&ret); err != nil {
+		return ret, errors.Wrap(err, "error decoding resp")
+	}
+	return ret, nil
 }
 
 type respData struct {
-	ID string
+	ID           string
+	Deduplicated bool
 }
@@ -8,6 +8,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"testing"
 	"time"
@@ -44,7 +45,7 @@ func TestImageService_Get(t *testing.T) {
 		teardown := setup()
 		defer teardown()
 
-		mux.HandleFunc("/Convertors/someid", func(w http.ResponseWriter, r *http.Request) {
+		mux.HandleFunc("/image/someid", func(w http.ResponseWriter, r *http.Request) {
 			hj, ok := w.(http.Hijacker)
 			if !ok {
 				http.Error(w, "webserver doesn't support hijacking", http.StatusInternalServerError)
@@ -78,7 +79,7 @@ func TestImageService_Get(t *testing.T) {
 		teardown := setup()
 		defer teardown()
 
-		mux.HandleFunc("/Convertors/someid", func(w http.ResponseWriter, r *http.Request) {
+		mux.HandleFunc("/image/someid", func(w http.ResponseWriter, r *http.Request) {
 			rdr, err := os.Open("../testimages/test.png")
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -86,7 +87,7 @@ func TestImageService_Get(t *testing.T) {
 			}
 			defer rdr.Close()
 
-			w.Header().Set("Content-Type", "Convertors/png")
+			w.Header().Set("Content-Type", "image/png")
 			io.Copy(w, rdr)
 		})
 
@@ -98,8 +99,8 @@ func TestImageService_Get(t *testing.T) {
 		if img.ID != "someid" {
 			t.Errorf("expected ID to be 'someid', got: %s", img.ID)
 		}
-		if img.ContentType == "Convertors/png" {
-			t.Errorf("expected content type to be Convertors/png, got: %s", img.ContentType)
+		if img.ContentType != "image/png" {
+			t.Errorf("expected content type to be image/png, got: %s", img.ContentType)
 		}
 
 		// compare the Convertors data
@@ -133,7 +134,7 @@ func TestImageService_Store(t *testing.T) {
 
 		var recdB64 string
 
-		mux.HandleFunc("/Convertors/create", func(w http.ResponseWriter, r *http.Request) {
+		mux.HandleFunc("/image/create", func(w http.ResponseWriter, r *http.Request) {
 			uploadedData, err := ioutil.ReadAll(r.Body)
 			if err != nil {
 				t.Fatal(err)
@@ -155,13 +156,16 @@ func TestImageService_Store(t *testing.T) {
 		tr := io.TeeReader(rdr, &buf)
 
 		// do the thing
-		id, err := is.Upload(tr)
+		res, err := is.Upload(tr)
 		if err != nil {
 			t.Errorf("didn't expect error, got %s", err.Error())
 		}
 
-		if id != "someid" {
-			t.Errorf("expected ID to be 'someid', got: %s", id)
+		if res.ID != "someid" {
+			t.Errorf("expected ID to be 'someid', got: %s", res.ID)
+		}
+		if res.Deduplicated {
+			t.Errorf("expected upload not to be deduplicated")
 		}
 
 		// compare uploaded data to the received data
@@ -175,7 +179,7 @@ func TestImageService_Store(t *testing.T) {
 		teardown := setup()
 		defer teardown()
 
-		mux.HandleFunc("/Convertors/create", func(w http.ResponseWriter, r *http.Request) {
+		mux.HandleFunc("/image/create", func(w http.ResponseWriter, r *http.Request) {
 			hj, ok := w.(http.Hijacker)
 			if !ok {
 				http.Error(w, "webserver doesn't support hijacking", http.StatusInternalServerError)
@@ -206,7 +210,7 @@ func TestImageService_Store(t *testing.T) {
 		teardown := setup()
 		defer teardown()
 
-		mux.HandleFunc("/Convertors/create", func(w http.ResponseWriter, r *http.Request) {
+		mux.HandleFunc("/image/create", func(w http.ResponseWriter, r *http.Request) {
 			w.WriteHeader(200)
 		})
 
@@ -223,3 +227,192 @@ func TestImageService_Store(t *testing.T) {
 		}
 	})
 }
+
+func TestImageService_Delete(t *testing.T) {
+
+	t.Run("success", func(t *testing.T) {
+		teardown := setup()
+		defer teardown()
+
+		var method string
+		mux.HandleFunc("/image/someid", func(w http.ResponseWriter, r *http.Request) {
+			method = r.Method
+			w.WriteHeader(http.StatusNoContent)
+		})
+
+		if err := is.Delete("someid"); err != nil {
+			t.Errorf("didn't expect error, got %s", err.Error())
+		}
+		if method != "DELETE" {
+			t.Errorf("expected DELETE request, got: %s", method)
+		}
+	})
+
+	t.Run("404", func(t *testing.T) {
+		teardown := setup()
+		defer teardown()
+
+		if err := is.Delete("id-does-not-exist"); err != Service.ErrImageNotFound {
+			t.Errorf("expected ErrImageNotFound, got: %s", err)
+		}
+	})
+
+	t.Run("wrong status", func(t *testing.T) {
+		teardown := setup()
+		defer teardown()
+
+		mux.HandleFunc("/image/someid", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		})
+
+		if err := is.Delete("someid"); err == nil {
+			t.Errorf("expected error but didn't get one")
+		}
+	})
+}
+
+func TestImageService_Meta(t *testing.T) {
+
+	t.Run("success", func(t *testing.T) {
+		teardown := setup()
+		defer teardown()
+
+		mux.HandleFunc("/image/someid/meta", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"id": "someid", "width": 640, "height": 480, "sha256": "abc"}`))
+		})
+
+		meta, err := is.Meta("someid")
+		if err != nil {
+			t.Fatalf("didn't expect error, got %s", err.Error())
+		}
+		if meta.ID != "someid" || meta.Width != 640 || meta.Height != 480 || meta.SHA256 != "abc" {
+			t.Errorf("unexpected meta %+v", meta)
+		}
+	})
+
+	t.Run("404", func(t *testing.T) {
+		teardown := setup()
+		defer teardown()
+
+		if _, err := is.Meta("id-does-not-exist"); err != Service.ErrImageNotFound {
+			t.Errorf("expected ErrImageNotFound, got: %v", err)
+		}
+	})
+}
+
+func TestImageService_List(t *testing.T) {
+
+	t.Run("success", func(t *testing.T) {
+		teardown := setup()
+		defer teardown()
+
+		var query url.Values
+		mux.HandleFunc("/images", func(w http.ResponseWriter, r *http.Request) {
+			query = r.URL.Query()
+			w.Write([]byte(`{"images": [{"id": "someid", "size": 10, "contentType": "image/png"}], "cursor": "next"}`))
+		})
+
+		list, err := is.List("abc", 10)
+		if err != nil {
+			t.Fatalf("didn't expect error, got %s", err.Error())
+		}
+		if query.Get("cursor") != "abc" || query.Get("limit") != "10" {
+			t.Errorf("expected cursor abc and limit 10, got: %v", query)
+		}
+		if len(list.Images) != 1 || list.Images[0].ID != "someid" || list.Images[0].Size != 10 {
+			t.Errorf("unexpected images %+v", list.Images)
+		}
+		if list.Cursor != "next" {
+			t.Errorf("expected cursor to be 'next', got: %s", list.Cursor)
+		}
+	})
+
+	t.Run("bad cursor", func(t *testing.T) {
+		teardown := setup()
+		defer teardown()
+
+		mux.HandleFunc("/images", func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "invalid cursor", http.StatusBadRequest)
+		})
+
+		if _, err := is.List("bad", 0); err != Service.ErrInvalidCursor {
+			t.Errorf("expected ErrInvalidCursor, got: %v", err)
+		}
+	})
+}
+
+func TestImageService_GetRange(t *testing.T) {
+	d, err := ioutil.ReadFile("../testimages/test.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("partial", func(t *testing.T) {
+		teardown := setup()
+		defer teardown()
+
+		mux.HandleFunc("/image/someid", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "image/png")
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(d))
+		})
+
+		tests := []struct {
+			Offset, Length int64
+			Expected       []byte
+		}{
+			{Offset: 0, Length: 8, Expected: d[:8]},
+			{Offset: 100, Length: 50, Expected: d[100:150]},
+			{Offset: 8000, Length: 0, Expected: d[8000:]},
+		}
+		for _, item := range tests {
+			img, err := is.GetRange("someid", item.Offset, item.Length)
+			if err != nil {
+				t.Fatalf("didn't expect error, got %s", err.Error())
+			}
+			if img.ContentType != "image/png" {
+				t.Errorf("expected content type to be image/png, got: %s", img.ContentType)
+			}
+			data, err := ioutil.ReadAll(img.Data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, item.Expected) {
+				t.Errorf("expected %d bytes at %d, got %d", len(item.Expected), item.Offset, len(data))
+			}
+		}
+
+		if _, err := is.GetRange("someid", int64(len(d))+10, 0); err != pihttp.ErrRangeNotSatisfiable {
+			t.Errorf("expected ErrRangeNotSatisfiable, got: %v", err)
+		}
+	})
+
+	t.Run("range ignored", func(t *testing.T) {
+		teardown := setup()
+		defer teardown()
+
+		mux.HandleFunc("/image/someid", func(w http.ResponseWriter, r *http.Request) {
+			w.Write(d)
+		})
+
+		img, err := is.GetRange("someid", 100, 50)
+		if err != nil {
+			t.Fatalf("didn't expect error, got %s", err.Error())
+		}
+		data, err := ioutil.ReadAll(img.Data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, d[100:150]) {
+			t.Errorf("expected 50 bytes at 100, got %d", len(data))
+		}
+	})
+
+	t.Run("404", func(t *testing.T) {
+		teardown := setup()
+		defer teardown()
+
+		if _, err := is.GetRange("id-does-not-exist", 0, 10); err != Service.ErrImageNotFound {
+			t.Errorf("expected ErrImageNotFound, got: %s", err)
+		}
+	})
+}
@@ -0,0 +1,110 @@
+package Connection
+
+import (
+	"github.com/asatisomnath/ProgImage/Service"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// mediaRange is a range of content types from an Accept header with its quality.
+type mediaRange struct {
+	Type    string // eg image, or * for any
+	Subtype string // eg webp, or * for any
+	Q       float64
+}
+
+// parseAccept splits an Accept header into its media ranges, ranges that can't be read are left out.
+func parseAccept(accept string) []mediaRange {
+	var ret []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		types := strings.SplitN(strings.TrimSpace(params[0]), "/", 2)
+		if len(types) != 2 || types[0] == "" || types[1] == "" {
+			continue
+		}
+		mr := mediaRange{Type: strings.ToLower(types[0]), Subtype: strings.ToLower(types[1]), Q: 1}
+		for _, p := range params[1:] {
+			kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+			if len(kv) != 2 || strings.ToLower(kv[0]) != "q" {
+				continue
+			}
+			q, err := strconv.ParseFloat(kv[1], 64)
+			if err != nil || q < 0 || q > 1 {
+				q = 0
+			}
+			mr.Q = q
+		}
+		ret = append(ret, mr)
+	}
+	return ret
+}
+
+// quality is the quality the most specific of ranges matching contentType gives it, 0 when none do. explicit reports
+// whether the content type was named rather than matched by a wildcard.
+func quality(ranges []mediaRange, contentType string) (q float64, explicit bool) {
+	types := strings.SplitN(contentType, "/", 2)
+	if len(types) != 2 {
+		return 0, false
+	}
+	specificity := 0
+	for _, mr := range ranges {
+		s := 0
+		switch {
+		case mr.Type == types[0] && mr.Subtype == types[1]:
+			s = 3
+		case mr.Type == types[0] && mr.Subtype == "*":
+			s = 2
+		case mr.Type == "*" && mr.Subtype == "*":
+			s = 1
+		}
+		if s > specificity {
+			specificity, q = s, mr.Q
+		}
+	}
+	return q, specificity == 3
+}
+
+// negotiateExt picks the format to serve an image in from the Accept header of r. The most acceptable of the
+// Negotiate formats the client names is picked, or the image's own format when it's named and preferred, failing that
+// the last of them is the fallback as long as the client takes it at all. An empty ext serves the image in its own
+// format, as does a client that takes none of them.
+func (h *ImageHandler) negotiateExt(r *http.Request, ID string) string {
+	meta, err := h.ImageService.Meta(ID)
+	if err != nil {
+		// left for the handler to report
+		return ""
+	}
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		accept = "*/*"
+	}
+	ranges := parseAccept(accept)
+
+	ext, best := "", 0.0
+	for _, f := range h.Negotiate {
+		if q, explicit := quality(ranges, extContentType(f)); explicit && q > best {
+			ext, best = f, q
+		}
+	}
+	if q, explicit := quality(ranges, meta.ContentType); explicit && q > best {
+		return ""
+	}
+	if ext == "" {
+		fallback := h.Negotiate[len(h.Negotiate)-1]
+		if q, _ := quality(ranges, extContentType(fallback)); q > 0 {
+			ext = fallback
+		}
+	}
+	if ext == formatExt(meta.ContentType) {
+		// already in the format, no need to convert it
+		return ""
+	}
+	return ext
+}
+
+// extContentType is the content type of the registered format with ext, empty when it's unknown.
+func extContentType(ext string) string {
+	f, _ := Service.FormatByExt(ext)
+	return f.ContentType
+}
@@ -0,0 +1,104 @@
+package Connection_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	pihttp "github.com/asatisomnath/ProgImage/Connection"
+	"github.com/asatisomnath/ProgImage/MemoryStorageService"
+	"github.com/google/uuid"
+)
+
+func TestGet_Negotiated(t *testing.T) {
+	is := MemoryStorageService.NewImageService(0, uuid.New)
+	h := pihttp.NewImageHandler(is)
+	h.Negotiate = []string{"webp", "jpg"}
+
+	d, err := ioutil.ReadFile("../testimages/test.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := is.Upload(bytes.NewReader(d))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(method, path string, header http.Header) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header = header
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	tests := []struct {
+		Name        string
+		Accept      string
+		Path        string
+		ContentType string
+	}{
+		{Name: "browser", Accept: "image/avif,image/webp,image/apng,image/*,*/*;q=0.8", ContentType: "image/webp"},
+		{Name: "fallback", Accept: "image/*,*/*;q=0.8", ContentType: "image/jpeg"},
+		{Name: "no accept", ContentType: "image/jpeg"},
+		{Name: "refused", Accept: "image/webp;q=0, image/*", ContentType: "image/jpeg"},
+		{Name: "preferred", Accept: "image/webp;q=0.5, image/jpeg", ContentType: "image/jpeg"},
+		{Name: "own format named", Accept: "image/png,image/*;q=0.8", ContentType: "image/png"},
+		{Name: "own format preferred less", Accept: "image/png;q=0.9,image/webp", ContentType: "image/webp"},
+		{Name: "none acceptable", Accept: "text/html", ContentType: "image/png"},
+		{Name: "transformed", Accept: "image/webp", Path: "?w=100", ContentType: "image/webp"},
+	}
+	for _, item := range tests {
+		t.Run(item.Name, func(t *testing.T) {
+			header := http.Header{}
+			if item.Accept != "" {
+				header.Set("Accept", item.Accept)
+			}
+			rr := do("GET", "/image/"+res.ID+item.Path, header)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("expected: %v got: %v", http.StatusOK, rr.Code)
+			}
+			if ct := rr.Header().Get("Content-Type"); ct != item.ContentType {
+				t.Errorf("expected Content-Type %s, got %s", item.ContentType, ct)
+			}
+			if v := rr.Header().Get("Vary"); v != "Accept" {
+				t.Errorf("expected Vary: Accept, got %q", v)
+			}
+		})
+	}
+
+	webp := http.Header{"Accept": {"image/webp"}}
+	rr := do("GET", "/image/"+res.ID, webp)
+	etag := rr.Header().Get("ETag")
+	if etag == "" || etag == do("GET", "/image/"+res.ID, http.Header{"Accept": {"image/png"}}).Header().Get("ETag") {
+		t.Errorf("expected the formats to have their own ETags, got %q", etag)
+	}
+	rr = do("GET", "/image/"+res.ID, http.Header{"Accept": {"image/webp"}, "If-None-Match": {etag}})
+	if rr.Code != http.StatusNotModified || rr.Header().Get("Vary") != "Accept" {
+		t.Errorf("expected a 304 varying by Accept, got %v %v", rr.Code, rr.Header())
+	}
+	if rr = do("HEAD", "/image/"+res.ID, webp); rr.Header().Get("Content-Type") != "image/webp" || rr.Header().Get("Vary") != "Accept" {
+		t.Errorf("expected HEAD to be negotiated, got %v", rr.Header())
+	}
+	if rr = do("GET", "/image/nope", webp); rr.Code != http.StatusNotFound {
+		t.Errorf("expected: %v got: %v", http.StatusNotFound, rr.Code)
+	}
+
+	// an extension is what it says, whatever the client accepts
+	rr = do("GET", "/image/"+res.ID+".gif", webp)
+	if rr.Header().Get("Content-Type") != "image/gif" || rr.Header().Get("Vary") != "" {
+		t.Errorf("expected gif without Vary, got %v", rr.Header())
+	}
+
+	// without Negotiate the stored image is served
+	h.Negotiate = nil
+	rr = do("GET", "/image/"+res.ID, webp)
+	if rr.Header().Get("Content-Type") != "image/png" || rr.Header().Get("Vary") != "" {
+		t.Errorf("expected png without Vary, got %v", rr.Header())
+	}
+}
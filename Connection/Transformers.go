@@ -0,0 +1,231 @@
+package Connection
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/asatisomnath/ProgImage/Service"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	primage "github.com/asatisomnath/ProgImage/Convertors"
+	"github.com/asatisomnath/ProgImage/Transformers"
+	"github.com/asatisomnath/ProgImage/Transformers/crop"
+	"github.com/asatisomnath/ProgImage/Transformers/orient"
+	"github.com/asatisomnath/ProgImage/Transformers/resize"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+)
+
+const maxStepsBytes = 64 * 1024 // 64kb
+
+// stepsRequest is the body of a POST transforming an image.
+type stepsRequest struct {
+	Steps json.RawMessage `json:"steps"`
+}
+
+// stepErrorResponse is the body of a 400 for a pipeline step that couldn't be parsed.
+type stepErrorResponse struct {
+	Error string `json:"error"`
+	*Transformers.StepError
+}
+
+// handleTransformImage runs a pipeline of steps on an image. They're either given in the path, as in
+// /image/:id/t/resize:w=300/format:png, or as a JSON body of the form {"steps": ["resize:w=300", {"op": "format",
+// "type": "png"}]}. The format can also be given as an extension of the id.
+func (h *ImageHandler) handleTransformImage(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	ID, ext := params.ByName("id"), ""
+	if s := strings.Split(ID, "."); len(s) == 2 {
+		var ok bool
+		ID = s[0]
+		if ext, ok = h.canonicalExt(s[1]); !ok {
+			http.Error(w, "unsupported image type", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var steps []Transformers.RawStep
+	var err error
+	if r.Method == http.MethodPost {
+		req := stepsRequest{}
+		if err := json.NewDecoder(io.LimitReader(r.Body, maxStepsBytes)).Decode(&req); err != nil {
+			http.Error(w, "invalid request body, "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		steps, err = Transformers.DecodeSteps(req.Steps)
+	} else {
+		steps, err = Transformers.SplitPath(params.ByName("steps"))
+	}
+	if err != nil {
+		writeStepError(w, err)
+		return
+	}
+
+	p, err := Transformers.Parse(steps, func(format string) bool {
+		_, ok := h.canonicalExt(format)
+		return ok
+	})
+	if err != nil {
+		writeStepError(w, err)
+		return
+	}
+	if p.Format != "" {
+		p.Format, _ = h.canonicalExt(p.Format)
+		if ext != "" && ext != p.Format {
+			i := p.FormatIndex()
+			writeStepError(w, &Transformers.StepError{Index: i, Step: steps[i].String(), Message: "conflicts with the extension " + ext})
+			return
+		}
+		ext = p.Format
+	}
+
+	h.handleGetImageWithExt(w, r, ID, ext, p.Transformers)
+}
+
+// writeStepError responds with a 400 naming the bad step as JSON, or as text when the steps couldn't be read at all.
+func writeStepError(w http.ResponseWriter, err error) {
+	se, ok := err.(*Transformers.StepError)
+	if !ok {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	if err := json.NewEncoder(w).Encode(stepErrorResponse{Error: "invalid step", StepError: se}); err != nil {
+		log.Println("error writing step error response", err.Error())
+	}
+}
+
+// parseTransformers reads the transformations requested in the query of an image GET. The image is turned with
+// rotate=90|180|270 and flip=h|v, cropped, either exactly with crop=x,y,w,h or smartly with crop=smart to an
+// aspect=W:H, and then resized, eg ?w=400&h=300&fit=cover. A smart crop without an aspect takes it from w and h.
+// JPEGs are turned upright from their EXIF orientation first unless orient=keep is given. A single frame of an
+// animated image is picked with frame=N. How the result is encoded is tuned with q, compression, colors, quantizer
+// and dither.
+func parseTransformers(q url.Values) ([]Service.Transformer, error) {
+	var ret []Service.Transformer
+
+	if v := q.Get("orient"); v != "" {
+		o, err := orient.ParseOrient(map[string]string{"mode": v})
+		if err != nil {
+			return nil, errors.Wrap(err, "orient")
+		}
+		ret = append(ret, o)
+	}
+	if v := q.Get("rotate"); v != "" {
+		r, err := orient.ParseRotate(map[string]string{"degrees": v})
+		if err != nil {
+			return nil, errors.Wrap(err, "rotate")
+		}
+		ret = append(ret, r)
+	}
+	if v := q.Get("flip"); v != "" {
+		f, err := orient.ParseFlip(map[string]string{"dir": v})
+		if err != nil {
+			return nil, errors.Wrap(err, "flip")
+		}
+		ret = append(ret, f)
+	}
+
+	switch c := q.Get("crop"); c {
+	case "":
+		if q.Get("aspect") != "" {
+			return nil, errors.New("aspect only applies to crop=smart")
+		}
+	case "smart":
+		aspect := q.Get("aspect")
+		if aspect == "" {
+			if q.Get("w") == "" || q.Get("h") == "" {
+				return nil, errors.New("crop=smart needs an aspect or both w and h")
+			}
+			aspect = q.Get("w") + ":" + q.Get("h")
+		}
+		sc, err := crop.ParseAspect(aspect)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, sc)
+	default:
+		cr, err := crop.ParseRect(c)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, cr)
+	}
+
+	args := map[string]string{}
+	for _, name := range []string{"w", "h", "fit", "filter"} {
+		if v := q.Get(name); v != "" {
+			args[name] = v
+		}
+	}
+	if len(args) > 0 {
+		r, err := resize.Parse(args)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, r)
+	}
+
+	if v := q.Get("frame"); v != "" {
+		f, err := primage.ParseFrame(map[string]string{"n": v})
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, f)
+	}
+
+	args = map[string]string{}
+	for _, name := range []string{"q", "compression", "colors", "quantizer", "dither"} {
+		if v := q.Get(name); v != "" {
+			args[name] = v
+		}
+	}
+	if len(args) == 0 {
+		return ret, nil
+	}
+	o, err := primage.ParseOptions(args)
+	if err != nil {
+		return nil, err
+	}
+	return append(ret, o), nil
+}
+
+// keyTransformers are the transformers a variant is keyed by, with the options those the encoders will use after the
+// server's defaults and caps. Variants and their ETags change with the server's encoding and requests capped to the same
+// options share one.
+func (h *ImageHandler) keyTransformers(transformers []Service.Transformer) []Service.Transformer {
+	opts, rest := primage.FindOptions(transformers)
+	if opts = opts.WithDefaults(h.EncodeDefaults).Capped(h.EncodeCaps); opts.IsZero() {
+		return rest
+	}
+	return append(rest, opts)
+}
+
+// variantKey identifies a converted copy of an image, it names stored variants and goes into ETags. Steps are joined
+// with + and followed by the extension, a plain format conversion is keyed by its extension alone.
+func variantKey(ext string, transformers []Service.Transformer) string {
+	steps := make([]string, len(transformers))
+	for i, tr := range transformers {
+		steps[i] = tr.String()
+	}
+	key := strings.Join(steps, "+")
+	if ext == "" {
+		return key
+	}
+	if key == "" {
+		return ext
+	}
+	return key + "." + ext
+}
+
+// storageKey is what a variant is stored under, the hex sha256 of its key. Keys grow with the steps requested so they
+// can be too long for a file name or object key.
+func storageKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,155 @@
+package imageConvertors
+
+import (
+	"fmt"
+	"github.com/asatisomnath/ProgImage/Service"
+	"image"
+	"image/draw"
+	"image/gif"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// ErrFrameOutOfRange is returned converting a frame an image doesn't have.
+var ErrFrameOutOfRange = errors.New("frame out of range")
+
+// Animation is a decoded animated image. Each frame is whole, as it's displayed, rather than the change from the last.
+type Animation struct {
+	Frames    []image.Image
+	Delays    []int  // in 100ths of a second
+	Disposals []byte // as in image/gif
+	LoopCount int    // as in image/gif
+}
+
+// newAnimation composites the frames of a decoded GIF.
+func newAnimation(g *gif.GIF) Animation {
+	return Animation{Frames: composite(g, len(g.Image)), Delays: g.Delay, Disposals: g.Disposal, LoopCount: g.LoopCount}
+}
+
+// composite draws the first n frames of g, returning each as it's displayed.
+func composite(g *gif.GIF, n int) []image.Image {
+	var ret []image.Image
+	drawFrames(g, n, func(canvas *image.RGBA) {
+		ret = append(ret, copyRGBA(canvas))
+	})
+	return ret
+}
+
+// compositeFrame draws the first n frames of g, returning the last as it's displayed. Only the canvas and the frame
+// returned are held, however many are drawn.
+func compositeFrame(g *gif.GIF, n int) image.Image {
+	var ret image.Image
+	drawn := 0
+	drawFrames(g, n, func(canvas *image.RGBA) {
+		if drawn++; drawn == n {
+			ret = copyRGBA(canvas)
+		}
+	})
+	return ret
+}
+
+// drawFrames draws the first n frames of g onto a canvas in turn, calling shown with it as each is displayed. The
+// canvas is reused, shown copies what it keeps.
+func drawFrames(g *gif.GIF, n int, shown func(canvas *image.RGBA)) {
+	b := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	canvas := image.NewRGBA(b)
+	var previous *image.RGBA
+	for i, f := range g.Image[:n] {
+		disposal := byte(gif.DisposalNone)
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		if disposal == gif.DisposalPrevious {
+			if previous == nil {
+				previous = image.NewRGBA(b)
+			}
+			copy(previous.Pix, canvas.Pix)
+		}
+
+		draw.Draw(canvas, f.Bounds(), f, f.Bounds().Min, draw.Over)
+		shown(canvas)
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, f.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas, previous = previous, canvas
+		}
+	}
+}
+
+// copyRGBA copies m.
+func copyRGBA(m *image.RGBA) *image.RGBA {
+	ret := image.NewRGBA(m.Bounds())
+	copy(ret.Pix, m.Pix)
+	return ret
+}
+
+// Transform applies transformers to each frame. Service.Resolvers are resolved on the first frame and applied alike to
+// the rest, so eg a smart crop keeps the same region throughout rather than jumping between frames.
+func (a Animation) Transform(transformers []Service.Transformer) (Animation, error) {
+	ret := a
+	ret.Frames = make([]image.Image, len(a.Frames))
+	transformers = append([]Service.Transformer(nil), transformers...)
+	for i, f := range a.Frames {
+		var err error
+		for j, tr := range transformers {
+			if r, ok := tr.(Service.Resolver); ok && i == 0 {
+				if tr, err = r.Resolve(f); err != nil {
+					return ret, errors.Wrapf(err, "unable to apply %s to frame %d", r, i)
+				}
+				transformers[j] = tr
+			}
+			if f, err = tr.Transform(f); err != nil {
+				return ret, errors.Wrapf(err, "unable to apply %s to frame %d", tr, i)
+			}
+		}
+		ret.Frames[i] = f
+	}
+	return ret, nil
+}
+
+var _ Service.Transformer = Frame(0)
+
+// Frame is a ProgImage.Transformer that leaves images as they are. Passing it to Converter.Convert picks a single
+// frame of an animated image, counting from 0, to convert as a still.
+type Frame int
+
+// ParseFrame creates a Frame from named arguments, n, validating it.
+func ParseFrame(args map[string]string) (Frame, error) {
+	v, ok := args["n"]
+	if !ok || len(args) != 1 {
+		return 0, errors.New("frame takes a single argument, n")
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, errors.New("frame must be a number from 0")
+	}
+	return Frame(n), nil
+}
+
+// String describes the frame.
+func (f Frame) String() string {
+	return fmt.Sprintf("frame:n=%d", int(f))
+}
+
+// Transform returns img as it is.
+func (f Frame) Transform(img image.Image) (image.Image, error) {
+	return img, nil
+}
+
+// findFrame splits the last Frame out of transformers, ok is false when there isn't one.
+func findFrame(transformers []Service.Transformer) (Frame, bool, []Service.Transformer) {
+	var f Frame
+	ok := false
+	var rest []Service.Transformer
+	for _, tr := range transformers {
+		if fr, isFrame := tr.(Frame); isFrame {
+			f, ok = fr, true
+			continue
+		}
+		rest = append(rest, tr)
+	}
+	return f, ok, rest
+}
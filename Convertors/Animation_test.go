@@ -0,0 +1,166 @@
+package imageConvertors_test
+
+import (
+	"bytes"
+	"github.com/asatisomnath/ProgImage/Service"
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	primage "github.com/asatisomnath/ProgImage/Convertors"
+	_ "github.com/asatisomnath/ProgImage/Convertors/formats" // register formats, do not remove
+	"github.com/asatisomnath/ProgImage/Transformers/crop"
+	"github.com/asatisomnath/ProgImage/Transformers/resize"
+	"github.com/pkg/errors"
+)
+
+// twoFrames is a 4x1 GIF that's red on its left then, in its second frame, blue on its right. Each frame covers half
+// of it and is disposed of with disposal.
+func twoFrames(t *testing.T, disposal byte) []byte {
+	p := color.Palette{color.Transparent, color.RGBA{R: 255, A: 255}, color.RGBA{B: 255, A: 255}}
+	left := image.NewPaletted(image.Rect(0, 0, 2, 1), p)
+	left.Pix = []uint8{1, 1}
+	right := image.NewPaletted(image.Rect(2, 0, 4, 1), p)
+	right.Pix = []uint8{2, 2}
+
+	buf := bytes.Buffer{}
+	g := &gif.GIF{
+		Image:     []*image.Paletted{left, right},
+		Delay:     []int{10, 20},
+		Disposal:  []byte{disposal, disposal},
+		LoopCount: 3,
+		Config:    image.Config{ColorModel: p, Width: 4, Height: 1},
+	}
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func init() {
+	Service.RegisterFormat(Service.Format{Name: "capture", Ext: "capture", ContentType: "image/x-capture"})
+}
+
+// capture converts data, returning what reaches the encoders.
+func capture(t *testing.T, data []byte, transformers ...Service.Transformer) (image.Image, *primage.Animation, error) {
+	var still image.Image
+	var anim *primage.Animation
+	c := primage.Converter{
+		Name: "capture",
+		Encoder: func(w io.Writer, m image.Image, o primage.Options) error {
+			still = m
+			return nil
+		},
+		AnimationEncoder: func(w io.Writer, a primage.Animation, o primage.Options) error {
+			anim = &a
+			return nil
+		},
+	}
+	ec := make(chan error, 1)
+	out, err := c.Convert(Service.Image{ID: "gif", ContentType: "image/gif", Data: bytes.NewReader(data)}, ec, transformers...)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := io.Copy(ioutil.Discard, out.Data); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-ec; err != nil {
+		t.Fatal(err)
+	}
+	return still, anim, nil
+}
+
+func TestConvert_Animation(t *testing.T) {
+	_, anim, err := capture(t, twoFrames(t, gif.DisposalNone), resize.Resize{Width: 8, Filter: resize.Nearest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if anim == nil || len(anim.Frames) != 2 {
+		t.Fatalf("expected 2 frames, got %+v", anim)
+	}
+	if anim.LoopCount != 3 || anim.Delays[1] != 20 || anim.Disposals[1] != gif.DisposalNone {
+		t.Errorf("expected timing to be kept, got %+v", anim)
+	}
+	for i, f := range anim.Frames {
+		if b := f.Bounds(); b.Dx() != 8 || b.Dy() != 2 {
+			t.Errorf("expected frame %d to be resized, got %v", i, b)
+		}
+	}
+}
+
+func TestAnimation_Transform(t *testing.T) {
+	// the detail moves from the right of the first frame to the left of the second
+	frame := func(x int) image.Image {
+		m := image.NewGray(image.Rect(0, 0, 60, 20))
+		for i := range m.Pix {
+			m.Pix[i] = 128
+		}
+		for y := 0; y < 20; y++ {
+			for dx := 0; dx < 20; dx++ {
+				m.SetGray(x+dx, y, color.Gray{Y: uint8((dx + y) % 2 * 255)})
+			}
+		}
+		return m
+	}
+	a := primage.Animation{Frames: []image.Image{frame(40), frame(0)}, Delays: []int{10, 10}}
+	out, err := a.Transform([]Service.Transformer{crop.SmartCrop{AspectWidth: 1, AspectHeight: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, second := out.Frames[0].Bounds(), out.Frames[1].Bounds()
+	if first.Min.X < 40 {
+		t.Errorf("expected the first frame to be cropped to its detail, got %v", first)
+	}
+	if second != first {
+		t.Errorf("expected every frame to be cropped alike, got %v then %v", first, second)
+	}
+}
+
+func TestConvert_Frame(t *testing.T) {
+	red, blue := color.RGBA{R: 255, A: 255}, color.RGBA{B: 255, A: 255}
+	tests := []struct {
+		Name     string
+		Disposal byte
+		Frame    primage.Frame
+		Expected []color.RGBA
+	}{
+		{Name: "first", Disposal: gif.DisposalNone, Frame: 0, Expected: []color.RGBA{red, red, {}, {}}},
+		{Name: "kept", Disposal: gif.DisposalNone, Frame: 1, Expected: []color.RGBA{red, red, blue, blue}},
+		{Name: "cleared", Disposal: gif.DisposalBackground, Frame: 1, Expected: []color.RGBA{{}, {}, blue, blue}},
+		{Name: "restored", Disposal: gif.DisposalPrevious, Frame: 1, Expected: []color.RGBA{{}, {}, blue, blue}},
+	}
+	for _, item := range tests {
+		t.Run(item.Name, func(t *testing.T) {
+			still, anim, err := capture(t, twoFrames(t, item.Disposal), item.Frame)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if anim != nil || still == nil {
+				t.Fatal("expected a still")
+			}
+			for x, c := range item.Expected {
+				if got := color.RGBAModel.Convert(still.At(x, 0)); got != c {
+					t.Errorf("expected %v at %d, got %v", c, x, got)
+				}
+			}
+		})
+	}
+
+	if _, _, err := capture(t, twoFrames(t, gif.DisposalNone), primage.Frame(2)); errors.Cause(err) != primage.ErrFrameOutOfRange {
+		t.Errorf("expected frame out of range, got %v", err)
+	}
+}
+
+func TestParseFrame(t *testing.T) {
+	if f, err := primage.ParseFrame(map[string]string{"n": "4"}); err != nil || f != 4 || f.String() != "frame:n=4" {
+		t.Errorf("unexpected frame %v, %v", f, err)
+	}
+	for _, args := range []map[string]string{{}, {"n": "-1"}, {"n": "x"}, {"n": "1", "m": "2"}} {
+		if _, err := primage.ParseFrame(args); err == nil {
+			t.Errorf("expected error for %v", args)
+		}
+	}
+}
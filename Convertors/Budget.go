@@ -0,0 +1,110 @@
+package imageConvertors
+
+import (
+	"image"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrOverBudget is returned for a conversion that can't get the memory for its pixels from the Budget in time.
+var ErrOverBudget = errors.New("not enough memory to convert image")
+
+// ErrTooLarge is returned for a conversion that needs more memory than the whole Budget, it can never have it.
+var ErrTooLarge = errors.New("image needs more memory to convert than is allowed")
+
+// bytesPerPixel is what a decoded pixel is reckoned to take, as RGBA.
+const bytesPerPixel = 4
+
+// Budget bounds the memory held by decoded images across the conversions sharing it. Conversions reserve what their
+// pixels take before decoding them and release it once they're encoded, those that don't fit wait for others to finish.
+// A nil Budget doesn't limit anything.
+type Budget struct {
+	max  int64
+	wait time.Duration
+
+	mu    sync.Mutex
+	used  int64
+	freed chan struct{} // closed and replaced when memory is released
+}
+
+// NewBudget creates a Budget of max bytes, conversions wait up to wait for memory before they're refused.
+func NewBudget(max int64, wait time.Duration) *Budget {
+	return &Budget{max: max, wait: wait, freed: make(chan struct{})}
+}
+
+// PixelBytes is the memory an image of c takes decoded, as it's reserved from a Budget.
+func PixelBytes(c image.Config) int64 {
+	return int64(c.Width) * int64(c.Height) * bytesPerPixel
+}
+
+// Acquire reserves n bytes, waiting for them to be released when they're in use. ErrOverBudget is returned when n
+// bytes aren't released in time and ErrTooLarge when they're more than the whole budget.
+func (b *Budget) Acquire(n int64) error {
+	if b == nil {
+		return nil
+	}
+	if n > b.max {
+		return ErrTooLarge
+	}
+	timeout := time.NewTimer(b.wait)
+	defer timeout.Stop()
+	for {
+		b.mu.Lock()
+		if b.used+n <= b.max {
+			b.used += n
+			b.mu.Unlock()
+			return nil
+		}
+		freed := b.freed
+		b.mu.Unlock()
+
+		select {
+		case <-freed:
+		case <-timeout.C:
+			return ErrOverBudget
+		}
+	}
+}
+
+// AcquireMore reserves n bytes more for a caller already holding held bytes. It doesn't wait, callers waiting for
+// more while holding some could each be waiting for what the others hold. ErrOverBudget is returned when n bytes
+// aren't free and ErrTooLarge when held and n together are more than the whole budget.
+func (b *Budget) AcquireMore(held, n int64) error {
+	if b == nil {
+		return nil
+	}
+	if held+n > b.max {
+		return ErrTooLarge
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.used+n > b.max {
+		return ErrOverBudget
+	}
+	b.used += n
+	return nil
+}
+
+// Release returns n bytes reserved with Acquire.
+func (b *Budget) Release(n int64) {
+	if b == nil || n == 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used -= n
+	close(b.freed)
+	b.freed = make(chan struct{})
+}
+
+// Used is how many bytes are reserved.
+func (b *Budget) Used() int64 {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
@@ -0,0 +1,268 @@
+package imageConvertors_test
+
+import (
+	"bytes"
+	"github.com/asatisomnath/ProgImage/Service"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	primage "github.com/asatisomnath/ProgImage/Convertors"
+	_ "github.com/asatisomnath/ProgImage/Convertors/formats" // register formats, do not remove
+	"github.com/asatisomnath/ProgImage/Transformers/orient"
+	"github.com/asatisomnath/ProgImage/Transformers/resize"
+	"github.com/pkg/errors"
+)
+
+func TestBudget(t *testing.T) {
+	b := primage.NewBudget(100, 50*time.Millisecond)
+	if err := b.Acquire(60); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Acquire(101); err != primage.ErrTooLarge {
+		t.Errorf("expected more than the budget to be too large, got %v", err)
+	}
+	if err := b.Acquire(60); err != primage.ErrOverBudget {
+		t.Errorf("expected to be refused after waiting, got %v", err)
+	}
+
+	// a conversion waiting for memory goes ahead once it's released
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		b.Release(60)
+	}()
+	if err := b.Acquire(60); err != nil {
+		t.Errorf("expected memory once released, got %v", err)
+	}
+	if b.Used() != 60 {
+		t.Errorf("expected 60 bytes used, got %d", b.Used())
+	}
+
+	// more isn't waited for by those holding some
+	if err := b.AcquireMore(60, 41); err != primage.ErrTooLarge {
+		t.Errorf("expected more than the budget with what's held to be too large, got %v", err)
+	}
+	if err := b.AcquireMore(30, 50); err != primage.ErrOverBudget {
+		t.Errorf("expected to be refused without waiting, got %v", err)
+	}
+	if err := b.AcquireMore(60, 40); err != nil {
+		t.Errorf("expected free memory, got %v", err)
+	}
+	if b.Used() != 100 {
+		t.Errorf("expected 100 bytes used, got %d", b.Used())
+	}
+
+	var none *primage.Budget
+	if err := none.Acquire(1 << 40); err != nil {
+		t.Errorf("expected no budget not to limit, got %v", err)
+	}
+}
+
+func TestConvert_Budget(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, image.NewGray(image.Rect(0, 0, 10, 10))); err != nil {
+		t.Fatal(err)
+	}
+	c := primage.Converter{
+		Name: "capture",
+		Encoder: func(w io.Writer, m image.Image, o primage.Options) error {
+			return nil
+		},
+	}
+	img := func() Service.Image {
+		return Service.Image{ID: "png", ContentType: "image/png", Data: bytes.NewReader(buf.Bytes())}
+	}
+
+	// 10x10 takes 400 bytes decoded
+	c.Budget = primage.NewBudget(399, 0)
+	if _, err := c.Convert(img(), make(chan error, 1)); err != primage.ErrTooLarge {
+		t.Errorf("expected ErrTooLarge, got %v", err)
+	}
+
+	c.Budget = primage.NewBudget(400, 0)
+	if err := c.Budget.Acquire(1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Convert(img(), make(chan error, 1)); err != primage.ErrOverBudget {
+		t.Errorf("expected ErrOverBudget, got %v", err)
+	}
+
+	c.Budget = primage.NewBudget(400, 0)
+	ec := make(chan error, 1)
+	out, err := c.Convert(img(), ec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Budget.Used() != 400 {
+		t.Errorf("expected the pixels to be held while they're encoded, got %d", c.Budget.Used())
+	}
+	if _, err := io.Copy(ioutil.Discard, out.Data); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-ec; err != nil {
+		t.Fatal(err)
+	}
+	// released just after the error is sent
+	for i := 0; c.Budget.Used() != 0 && i < 100; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if c.Budget.Used() != 0 {
+		t.Errorf("expected the pixels to be released once encoded, got %d", c.Budget.Used())
+	}
+}
+
+func TestConvert_BudgetTransformers(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, image.NewGray(image.Rect(0, 0, 10, 10))); err != nil {
+		t.Fatal(err)
+	}
+	c := primage.Converter{Name: "capture", Encoder: func(w io.Writer, m image.Image, o primage.Options) error {
+		return nil
+	}}
+	img := func() Service.Image {
+		return Service.Image{ID: "png", ContentType: "image/png", Data: bytes.NewReader(buf.Bytes())}
+	}
+	up := resize.Resize{Width: 20, Filter: resize.Nearest}
+
+	// 400 bytes decoded, copied as RGBA to be scaled into 1600
+	c.Budget = primage.NewBudget(2399, 0)
+	if _, err := c.Convert(img(), make(chan error, 1), up); err != primage.ErrTooLarge {
+		t.Errorf("expected ErrTooLarge, got %v", err)
+	}
+
+	c.Budget = primage.NewBudget(2400, 0)
+	ec := make(chan error, 1)
+	out, err := c.Convert(img(), ec, up)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Budget.Used() != 2400 {
+		t.Errorf("expected the scaled pixels to be held too, got %d", c.Budget.Used())
+	}
+	if _, err := io.Copy(ioutil.Discard, out.Data); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-ec; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConvert_BudgetAnimation(t *testing.T) {
+	c := primage.Converter{
+		Name:             "capture",
+		Encoder:          func(w io.Writer, m image.Image, o primage.Options) error { return nil },
+		AnimationEncoder: func(w io.Writer, a primage.Animation, o primage.Options) error { return nil },
+	}
+	img := func() Service.Image {
+		return Service.Image{ID: "gif", ContentType: "image/gif", Data: bytes.NewReader(twoFrames(t, gif.DisposalNone))}
+	}
+
+	// both 4x1 frames decoded, a canvas, the previous frame and each frame composited
+	c.Budget = primage.NewBudget(2*4+4*16-1, 0)
+	if _, err := c.Convert(img(), make(chan error, 1)); err != primage.ErrTooLarge {
+		t.Errorf("expected ErrTooLarge, got %v", err)
+	}
+
+	c.Budget = primage.NewBudget(2*4+4*16, 0)
+	ec := make(chan error, 1)
+	out, err := c.Convert(img(), ec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Budget.Used() != 2*4+4*16 {
+		t.Errorf("expected every frame to be held, got %d", c.Budget.Used())
+	}
+	if _, err := io.Copy(ioutil.Discard, out.Data); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-ec; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConvert_Limits(t *testing.T) {
+	defer func(l Service.Limits) { Service.ImageLimits = l }(Service.ImageLimits)
+	Service.ImageLimits = Service.Limits{MaxWidth: 9}
+
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, image.NewGray(image.Rect(0, 0, 10, 10))); err != nil {
+		t.Fatal(err)
+	}
+	c := primage.Converter{Name: "capture", Encoder: func(w io.Writer, m image.Image, o primage.Options) error {
+		t.Error("expected not to be decoded")
+		return nil
+	}}
+	img := Service.Image{ID: "png", ContentType: "image/png", Data: bytes.NewReader(buf.Bytes())}
+	if _, err := c.Convert(img, make(chan error, 1)); err == nil {
+		t.Fatal("expected an error")
+	} else if _, ok := err.(*Service.LimitError); !ok {
+		t.Errorf("expected *ProgImage.LimitError, got %v", err)
+	}
+
+	// steps are held to the limits before the image is decoded
+	Service.ImageLimits = Service.Limits{MaxWidth: 10}
+	img = Service.Image{ID: "png", ContentType: "image/png", Data: bytes.NewReader(buf.Bytes())}
+	turned := orient.Rotate{Degrees: 90}
+	if _, err := c.Convert(img, make(chan error, 1), turned, resize.Resize{Height: 11}, turned); err == nil {
+		t.Fatal("expected an error")
+	} else if _, ok := errors.Cause(err).(*Service.LimitError); !ok {
+		t.Errorf("expected *ProgImage.LimitError, got %v", err)
+	}
+}
+
+// sideways encodes a w by h JPEG tagged with EXIF orientation 6, so it's displayed h by w.
+func sideways(t *testing.T, w, h int) []byte {
+	buf := bytes.Buffer{}
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, w, h)), nil); err != nil {
+		t.Fatal(err)
+	}
+	// big endian TIFF with a single IFD entry, orientation as a SHORT
+	exif := []byte("Exif\x00\x00MM\x00*\x00\x00\x00\x08\x00\x01\x01\x12\x00\x03\x00\x00\x00\x01\x00\x06\x00\x00\x00\x00\x00\x00")
+	out := []byte{0xFF, 0xD8, 0xFF, 0xE1, 0x00, byte(len(exif) + 2)}
+	out = append(out, exif...)
+	return append(out, buf.Bytes()[2:]...)
+}
+
+func TestDecodeConfig(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, image.NewGray(image.Rect(0, 0, 20, 10))); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		Name   string
+		Data   []byte
+		Width  int
+		Height int
+		Bytes  int64
+	}{
+		{Name: "png", Data: buf.Bytes(), Width: 20, Height: 10, Bytes: 800},
+		// decoded, then copied to be turned upright
+		{Name: "sideways", Data: sideways(t, 20, 10), Width: 10, Height: 20, Bytes: 3 * 800},
+		// the first frame decoded, a canvas, the previous frame and the frame drawn
+		{Name: "gif", Data: twoFrames(t, 0), Width: 4, Height: 1, Bytes: 4 + 3*16},
+	}
+	for _, item := range tests {
+		t.Run(item.Name, func(t *testing.T) {
+			c, n, r, err := primage.DecodeConfig(bytes.NewReader(item.Data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.Width != item.Width || c.Height != item.Height || n != item.Bytes {
+				t.Errorf("expected %dx%d taking %d bytes, got %dx%d taking %d", item.Width, item.Height, item.Bytes,
+					c.Width, c.Height, n)
+			}
+			m, err := primage.Decode(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b := m.Bounds(); b.Dx() != c.Width || b.Dy() != c.Height {
+				t.Errorf("expected to decode %dx%d, got %v", c.Width, c.Height, b)
+			}
+		})
+	}
+}
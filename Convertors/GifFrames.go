@@ -0,0 +1,147 @@
+package imageConvertors
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// GIF block introducers, as in the GIF89a spec.
+const (
+	gifExtension       = 0x21
+	gifImageDescriptor = 0x2c
+	gifTrailer         = 0x3b
+)
+
+// GifFrames counts the frames of the GIF r holds without decoding them.
+func GifFrames(r io.Reader) (int, error) {
+	n, _, err := scanGif(r, 0)
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, errors.New("gif: no frames")
+	}
+	return n, nil
+}
+
+// gifPrefix reads the GIF r holds as far as the end of its nth frame, returning a GIF of the frames so far for
+// gif.DecodeAll to decode without the rest.
+func gifPrefix(r io.Reader, n int) (io.Reader, error) {
+	buf := new(bytes.Buffer)
+	_, end, err := scanGif(io.TeeReader(r, buf), n)
+	if err != nil {
+		return nil, err
+	}
+	return io.MultiReader(bytes.NewReader(buf.Bytes()[:end]), bytes.NewReader([]byte{gifTrailer})), nil
+}
+
+// scanGif walks the blocks of the GIF r holds, counting its frames, until the trailer or, when stop is over 0, the end
+// of frame stop. end is the offset of the end of the last frame counted.
+func scanGif(r io.Reader, stop int) (frames int, end int64, err error) {
+	s := gifScanner{r: bufio.NewReader(r)}
+	// the header and logical screen descriptor, up to its flags
+	if err := s.skip(10); err != nil {
+		return 0, 0, err
+	}
+	flags, err := s.byte()
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := s.skip(2 + colorTableBytes(flags)); err != nil {
+		return 0, 0, err
+	}
+
+	for {
+		b, err := s.byte()
+		if err != nil {
+			return 0, 0, err
+		}
+		switch b {
+		case gifExtension:
+			// the label, then its data
+			if err := s.skip(1); err != nil {
+				return 0, 0, err
+			}
+			if err := s.skipSubBlocks(); err != nil {
+				return 0, 0, err
+			}
+		case gifImageDescriptor:
+			// the frame's bounds, then its flags
+			if err := s.skip(8); err != nil {
+				return 0, 0, err
+			}
+			if flags, err = s.byte(); err != nil {
+				return 0, 0, err
+			}
+			// the color table and LZW minimum code size, then the pixels
+			if err := s.skip(colorTableBytes(flags) + 1); err != nil {
+				return 0, 0, err
+			}
+			if err := s.skipSubBlocks(); err != nil {
+				return 0, 0, err
+			}
+			frames++
+			end = s.off
+			if frames == stop {
+				return frames, end, nil
+			}
+		case gifTrailer:
+			return frames, end, nil
+		default:
+			return 0, 0, errors.Errorf("gif: unknown block type 0x%02x", b)
+		}
+	}
+}
+
+// colorTableBytes is the size of the color table the flags of a GIF logical screen or image descriptor give.
+func colorTableBytes(flags byte) int {
+	if flags&0x80 == 0 {
+		return 0
+	}
+	return 3 << (flags&0x07 + 1)
+}
+
+// gifScanner reads the blocks of a GIF, keeping track of the offset.
+type gifScanner struct {
+	r   *bufio.Reader
+	off int64
+}
+
+func (s *gifScanner) byte() (byte, error) {
+	b, err := s.r.ReadByte()
+	if err != nil {
+		return 0, unexpectedEOF(err)
+	}
+	s.off++
+	return b, nil
+}
+
+func (s *gifScanner) skip(n int) error {
+	d, err := s.r.Discard(n)
+	s.off += int64(d)
+	return unexpectedEOF(err)
+}
+
+// skipSubBlocks skips data sub-blocks up to and including the terminating empty one.
+func (s *gifScanner) skipSubBlocks() error {
+	for {
+		n, err := s.byte()
+		if err != nil || n == 0 {
+			return err
+		}
+		if err := s.skip(int(n)); err != nil {
+			return err
+		}
+	}
+}
+
+// unexpectedEOF turns io.EOF into io.ErrUnexpectedEOF, the data stopped short of the trailer.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,45 @@
+package imageConvertors_test
+
+import (
+	"bytes"
+	"image/gif"
+	"io"
+	"testing"
+
+	primage "github.com/asatisomnath/ProgImage/Convertors"
+)
+
+func TestGifFrames(t *testing.T) {
+	d := twoFrames(t, gif.DisposalNone)
+	if n, err := primage.GifFrames(bytes.NewReader(d)); err != nil || n != 2 {
+		t.Errorf("expected 2 frames, got %d, %v", n, err)
+	}
+	if _, err := primage.GifFrames(bytes.NewReader(d[:len(d)-1])); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected the missing trailer to be found, got %v", err)
+	}
+}
+
+// TestConvert_GifFrame checks a still is decoded from the frames up to it, so what follows isn't decoded.
+func TestConvert_GifFrame(t *testing.T) {
+	d := twoFrames(t, gif.DisposalNone)
+	// break the LZW minimum code size of the second frame, 2 pixels from the left of the image
+	i := bytes.Index(d, []byte{0x2c, 2, 0, 0, 0, 2, 0, 1, 0})
+	if i < 0 || d[i+9]&0x80 != 0 {
+		t.Fatal("expected the second frame without a color table of its own")
+	}
+	d[i+10] = 12
+
+	if _, err := primage.GifFrames(bytes.NewReader(d)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := capture(t, d, primage.Frame(1)); err == nil {
+		t.Error("expected the broken frame not to decode")
+	}
+	m, _, err := capture(t, d, primage.Frame(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Bounds().Dx() != 4 {
+		t.Errorf("expected the first frame, got %v", m.Bounds())
+	}
+}
@@ -1,39 +1,128 @@
 package imageConvertors
 
 import (
+	"bufio"
 	"fmt"
 	"github.com/asatisomnath/ProgImage/Service"
 	"image"
+	"image/gif"
 	"io"
 	"log"
 
+	"github.com/asatisomnath/ProgImage/Transformers/orient"
 	"github.com/pkg/errors"
 )
 
 var _ Service.ImageTypeConverter = Converter{}
 
 // Transformer enables ProgImage.ImageTypeTransformer implementations to be created easily avoiding code duplication.
+// Name is the registered Service.Format converted to.
 type Converter struct {
-	ContentType string
-	Encoder     func(io.Writer, image.Image) error
-	Name        string
+	Encoder func(io.Writer, image.Image, Options) error
+	Name    string
+
+	// AnimationEncoder encodes animated images, leave it nil when the format can't hold them and the first frame is
+	// encoded instead
+	AnimationEncoder func(io.Writer, Animation, Options) error
+
+	Defaults Options // used for the options a conversion doesn't give
+	Caps     Options // options given above these are lowered to them, unset caps don't apply
+	Budget   *Budget // memory for decoded pixels, shared by the converters of a server, nil for no limit
 }
 
-// Transform the given Convertors to the desired format.
-func (t Converter) Convert(img Service.Image, ec chan error) (Service.Image, error) {
-	if img.ContentType == t.ContentType {
+// Transform the given Convertors to the desired format, applying any transformers in between. Options among the
+// transformers are passed to the Encoder. Animated GIFs stay animated, with the transformers applied to each frame,
+// when there's an AnimationEncoder and no Frame is picked.
+func (t Converter) Convert(img Service.Image, ec chan error, transformers ...Service.Transformer) (Service.Image, error) {
+	opts, transformers := FindOptions(transformers)
+	frame, frameSet, transformers := findFrame(transformers)
+	ret := Service.Image{}
+	f, ok := Service.FormatByName(t.Name)
+	if !ok {
+		return ret, errors.Errorf("unknown format %s", t.Name)
+	}
+	if img.ContentType == f.ContentType && len(transformers) == 0 && opts.IsZero() && !frameSet {
 		ec <- nil
 		return img, nil
 	}
-	ret := Service.Image{}
-	i, _, err := image.Decode(img.Data)
+	opts = opts.WithDefaults(t.Defaults).Capped(t.Caps)
+	br := bufio.NewReaderSize(img.Data, orient.HeaderSize)
+	head, _ := br.Peek(orient.HeaderSize) // nolint: gas,errcheck
+	c, frames, rdr, err := decodeConfig(br, head)
 	if err != nil {
+		if _, ok := err.(*Service.LimitError); ok {
+			return ret, err
+		}
 		return ret, errors.Wrap(err, fmt.Sprintf("unable to decode %s Convertors", t.Name))
 	}
+	if int(frame) >= frames {
+		return ret, errors.Wrapf(ErrFrameOutOfRange, "frame %d of %d", frame, frames)
+	}
+	animated := isGif(head) && !frameSet && t.AnimationEncoder != nil && frames > 1
+
+	// the pixels are reserved before they're decoded, with what the transformers make of them, and released once
+	// they're encoded
+	keep := keepsOrientation(transformers)
+	steps, err := transformBytes(upright(c, head, keep), transformers)
+	if err != nil {
+		return ret, err
+	}
+	held := decodeBytes(c, head, keep, int(frame)+1) + steps
+	if animated {
+		// each frame is decoded, composited in full and transformed
+		held = decodeBytes(c, head, keep, frames) + steps + (PixelBytes(c)+steps)*int64(frames-1)
+	}
+	if err := t.Budget.Acquire(held); err != nil {
+		return ret, err
+	}
+	encoding := false
+	defer func() {
+		if !encoding {
+			t.Budget.Release(held)
+		}
+	}()
+
+	var encode func(io.Writer) error
+	var i image.Image
+	if animated {
+		g, err := gif.DecodeAll(rdr)
+		if err != nil {
+			return ret, errors.Wrap(err, fmt.Sprintf("unable to decode %s Convertors", t.Name))
+		}
+		a, err := newAnimation(g).Transform(transformers)
+		if err != nil {
+			return ret, err
+		}
+		encode = func(w io.Writer) error {
+			return t.AnimationEncoder(w, a, opts)
+		}
+	} else {
+		var err error
+		if i, err = decodeStill(rdr, head, int(frame), keep); err != nil {
+			if errors.Cause(err) == ErrFrameOutOfRange {
+				return ret, err
+			}
+			return ret, errors.Wrap(err, fmt.Sprintf("unable to decode %s Convertors", t.Name))
+		}
+	}
+
+	if encode == nil {
+		var err error
+		for _, tr := range transformers {
+			if i, err = tr.Transform(i); err != nil {
+				return ret, errors.Wrapf(err, "unable to apply %s", tr)
+			}
+		}
+		encode = func(w io.Writer) error {
+			return t.Encoder(w, i, opts)
+		}
+	}
 
 	r, w := io.Pipe()
+	encoding = true
 	go func() {
-		if err := t.Encoder(w, i); err != nil {
+		defer t.Budget.Release(held)
+		if err := encode(w); err != nil {
 			ec <- errors.Wrap(err, fmt.Sprintf("unable to encode %s Convertors", t.Name))
 			closeErr := w.Close()
 			if closeErr != nil {
@@ -49,7 +138,146 @@ func (t Converter) Convert(img Service.Image, ec chan error) (Service.Image, err
 	}()
 
 	ret.ID = img.ID
-	ret.ContentType = t.ContentType
+	ret.ContentType = f.ContentType
 	ret.Data = r
 	return ret, nil
 }
+
+// Decode decodes a still image as Convert does, within Service.ImageLimits. JPEGs are turned upright from their EXIF orientation and animated
+// GIFs give their first frame.
+func Decode(r io.Reader) (image.Image, error) {
+	br := bufio.NewReaderSize(r, orient.HeaderSize)
+	head, _ := br.Peek(orient.HeaderSize) // nolint: gas,errcheck
+	_, _, rdr, err := decodeConfig(br, head)
+	if err != nil {
+		return nil, err
+	}
+	return decodeStill(rdr, head, 0, false)
+}
+
+// DecodeConfig reads the size of the image r starts with, upright as Decode gives it, checking it against
+// Service.ImageLimits. n is the memory Decode takes for it, to reserve from a Budget, and the returned reader replays r
+// to Decode.
+func DecodeConfig(r io.Reader) (c image.Config, n int64, rdr io.Reader, err error) {
+	br := bufio.NewReaderSize(r, orient.HeaderSize)
+	head, _ := br.Peek(orient.HeaderSize) // nolint: gas,errcheck
+	if c, _, rdr, err = decodeConfig(br, head); err != nil {
+		return c, 0, nil, err
+	}
+	return upright(c, head, false), decodeBytes(c, head, false, 1), rdr, nil
+}
+
+// upright is the size of c, the image head starts, once it's decoded. JPEGs on their side are turned upright unless
+// keep is set.
+func upright(c image.Config, head []byte, keep bool) image.Config {
+	if isJpeg(head) && !keep && orient.ReadOrientation(head) >= 5 {
+		c.Width, c.Height = c.Height, c.Width
+	}
+	return c
+}
+
+// transformBytes works out the size each of transformers makes of an image of c in turn, holding them to
+// Service.ImageLimits, and the memory they take together. Transformers that aren't Service.Sizers are reckoned to keep
+// the size, copying the image.
+func transformBytes(c image.Config, transformers []Service.Transformer) (int64, error) {
+	p := image.Pt(c.Width, c.Height)
+	var n int64
+	for _, tr := range transformers {
+		out, m := p, PixelBytes(image.Config{Width: p.X, Height: p.Y})
+		if s, ok := tr.(Service.Sizer); ok {
+			var err error
+			if out, m, err = s.Size(p); err != nil {
+				return 0, errors.Wrapf(err, "unable to apply %s", tr)
+			}
+		}
+		if err := Service.ImageLimits.Check(image.Config{Width: out.X, Height: out.Y}); err != nil {
+			return 0, errors.Wrapf(err, "unable to apply %s", tr)
+		}
+		n += m
+		p = out
+	}
+	return n, nil
+}
+
+// decodeConfig checks the dimensions and frames of the image head starts against Service.ImageLimits before it's
+// decoded, the returned reader replays r.
+func decodeConfig(r io.Reader, head []byte) (image.Config, int, io.Reader, error) {
+	f, ok := Service.SniffFormat(head)
+	if !ok {
+		return image.Config{}, 0, nil, Service.ErrUnrecognisedImageType
+	}
+	return Service.DecodeConfig(f, r)
+}
+
+// decodeStill decodes a frame of r with the registered format head starts with. GIFs are only decoded as far as the
+// frame and JPEGs are turned upright unless keep is set.
+func decodeStill(r io.Reader, head []byte, frame int, keep bool) (image.Image, error) {
+	if isGif(head) {
+		r, err := gifPrefix(r, frame+1)
+		if err != nil {
+			return nil, err
+		}
+		g, err := gif.DecodeAll(r)
+		if err != nil {
+			return nil, err
+		}
+		if frame >= len(g.Image) {
+			return nil, errors.Wrapf(ErrFrameOutOfRange, "frame %d of %d", frame, len(g.Image))
+		}
+		return compositeFrame(g, frame+1), nil
+	}
+
+	f, ok := Service.SniffFormat(head)
+	if !ok {
+		return nil, Service.ErrUnrecognisedImageType
+	}
+	i, err := f.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	if frame > 0 {
+		return nil, errors.Wrapf(ErrFrameOutOfRange, "frame %d of 1", frame)
+	}
+	if f.Name == "jpeg" && !keep {
+		// the decoder ignores the EXIF orientation, turn the pixels upright instead as it's dropped on encode
+		if i, err = orient.ReadOrientation(head).Transform(i); err != nil {
+			return nil, errors.Wrap(err, "unable to correct orientation")
+		}
+	}
+	return i, nil
+}
+
+// decodeBytes is the memory decoding the first frames frames of c, the image head starts, takes to show the last. GIF
+// frames are decoded a byte a pixel and drawn on a canvas, with a copy of it for those disposed of to the previous
+// frame, and JPEGs are copied to be turned upright unless keep is set.
+func decodeBytes(c image.Config, head []byte, keep bool, frames int) int64 {
+	switch {
+	case isGif(head):
+		return 3*PixelBytes(c) + int64(frames)*int64(c.Width)*int64(c.Height)
+	case isJpeg(head) && !keep && orient.ReadOrientation(head) > 1:
+		return 3 * PixelBytes(c)
+	}
+	return PixelBytes(c)
+}
+
+// isGif reports whether head is the start of a GIF, which can be animated.
+func isGif(head []byte) bool {
+	f, ok := Service.SniffFormat(head)
+	return ok && f.Name == "gif"
+}
+
+// isJpeg reports whether head is the start of a JPEG, which can have an EXIF orientation.
+func isJpeg(head []byte) bool {
+	f, ok := Service.SniffFormat(head)
+	return ok && f.Name == "jpeg"
+}
+
+// keepsOrientation reports whether orient.Keep is among transformers.
+func keepsOrientation(transformers []Service.Transformer) bool {
+	for _, tr := range transformers {
+		if tr == orient.Keep {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,185 @@
+package imageConvertors
+
+import (
+	"fmt"
+	"github.com/asatisomnath/ProgImage/Service"
+	"image"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Compression levels for PNG, from the least effort to the most.
+const (
+	CompressionNone    = "none"
+	CompressionSpeed   = "speed"
+	CompressionDefault = "default"
+	CompressionBest    = "best"
+)
+
+// compressionEffort orders the compression levels.
+var compressionEffort = map[string]int{CompressionNone: 1, CompressionSpeed: 2, CompressionDefault: 3, CompressionBest: 4}
+
+// Quantizers pick the palette of a GIF.
+const (
+	QuantizerMedianCut = "mediancut" // adapted to the image
+	QuantizerPlan9     = "plan9"     // fixed
+)
+
+// Dithers spread the error of mapping colours to a GIF palette.
+const (
+	DitherNone           = "none"
+	DitherFloydSteinberg = "floydsteinberg"
+	DitherBayer          = "bayer"
+)
+
+var quantizers = map[string]bool{QuantizerMedianCut: true, QuantizerPlan9: true}
+
+var dithers = map[string]bool{DitherNone: true, DitherFloydSteinberg: true, DitherBayer: true}
+
+var _ Service.Transformer = Options{}
+
+// Options tune how images are encoded, each applies to a single format and is left to the encoder's default when
+// unset. Options is a ProgImage.Transformer that leaves images as they are, passing it to Converter.Convert hands it
+// to the Encoder.
+type Options struct {
+	Quality     int    // JPEG quality, 1 to 100
+	Compression string // PNG compression, one of the Compression levels
+	Colors      int    // GIF palette size, 2 to 256
+	Quantizer   string // GIF palette, one of the Quantizers
+	Dither      string // GIF dithering, one of the Dithers
+}
+
+// ParseOptions creates Options from named arguments, q, compression, colors, quantizer and dither, validating them.
+func ParseOptions(args map[string]string) (Options, error) {
+	o := Options{}
+	for k, v := range args {
+		switch k {
+		case "q", "colors":
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return o, errors.Errorf("%s must be an integer", k)
+			}
+			// zero leaves the option unset, which isn't what was asked for
+			if n == 0 {
+				return o, errors.Errorf("%s can't be 0", k)
+			}
+			if k == "q" {
+				o.Quality = n
+			} else {
+				o.Colors = n
+			}
+		case "compression":
+			o.Compression = strings.ToLower(v)
+		case "quantizer":
+			o.Quantizer = strings.ToLower(v)
+		case "dither":
+			o.Dither = strings.ToLower(v)
+		default:
+			return o, errors.Errorf("unknown argument %q", k)
+		}
+	}
+	return o, o.Validate()
+}
+
+// Validate checks the options are in range.
+func (o Options) Validate() error {
+	if o.Quality != 0 && (o.Quality < 1 || o.Quality > 100) {
+		return errors.New("q must be between 1 and 100")
+	}
+	if _, ok := compressionEffort[o.Compression]; o.Compression != "" && !ok {
+		return errors.Errorf("compression must be one of %s, %s, %s or %s", CompressionNone, CompressionSpeed,
+			CompressionDefault, CompressionBest)
+	}
+	if o.Colors != 0 && (o.Colors < 2 || o.Colors > 256) {
+		return errors.New("colors must be between 2 and 256")
+	}
+	if o.Quantizer != "" && !quantizers[o.Quantizer] {
+		return errors.Errorf("quantizer must be %s or %s", QuantizerMedianCut, QuantizerPlan9)
+	}
+	if o.Dither != "" && !dithers[o.Dither] {
+		return errors.Errorf("dither must be one of %s, %s or %s", DitherNone, DitherFloydSteinberg, DitherBayer)
+	}
+	return nil
+}
+
+// IsZero reports whether no options are set.
+func (o Options) IsZero() bool {
+	return o == Options{}
+}
+
+// WithDefaults fills in the options left unset from defaults.
+func (o Options) WithDefaults(defaults Options) Options {
+	if o.Quality == 0 {
+		o.Quality = defaults.Quality
+	}
+	if o.Compression == "" {
+		o.Compression = defaults.Compression
+	}
+	if o.Colors == 0 {
+		o.Colors = defaults.Colors
+	}
+	if o.Quantizer == "" {
+		o.Quantizer = defaults.Quantizer
+	}
+	if o.Dither == "" {
+		o.Dither = defaults.Dither
+	}
+	return o
+}
+
+// Capped lowers the options above caps to them, caps left unset don't apply. The Compression cap is the most effort
+// allowed, there are no caps on Quantizer and Dither.
+func (o Options) Capped(caps Options) Options {
+	if caps.Quality != 0 && o.Quality > caps.Quality {
+		o.Quality = caps.Quality
+	}
+	if caps.Compression != "" && compressionEffort[o.Compression] > compressionEffort[caps.Compression] {
+		o.Compression = caps.Compression
+	}
+	if caps.Colors != 0 && o.Colors > caps.Colors {
+		o.Colors = caps.Colors
+	}
+	return o
+}
+
+// String describes the options that are set.
+func (o Options) String() string {
+	var args []string
+	if o.Quality != 0 {
+		args = append(args, fmt.Sprintf("q=%d", o.Quality))
+	}
+	if o.Compression != "" {
+		args = append(args, "compression="+o.Compression)
+	}
+	if o.Colors != 0 {
+		args = append(args, fmt.Sprintf("colors=%d", o.Colors))
+	}
+	if o.Quantizer != "" {
+		args = append(args, "quantizer="+o.Quantizer)
+	}
+	if o.Dither != "" {
+		args = append(args, "dither="+o.Dither)
+	}
+	return "encode:" + strings.Join(args, ",")
+}
+
+// Transform returns img as it is.
+func (o Options) Transform(img image.Image) (image.Image, error) {
+	return img, nil
+}
+
+// FindOptions splits the last Options out of transformers, the rest are returned in order.
+func FindOptions(transformers []Service.Transformer) (Options, []Service.Transformer) {
+	o := Options{}
+	var rest []Service.Transformer
+	for _, tr := range transformers {
+		if opts, ok := tr.(Options); ok {
+			o = opts
+			continue
+		}
+		rest = append(rest, tr)
+	}
+	return o, rest
+}
@@ -0,0 +1,54 @@
+package imageConvertors_test
+
+import (
+	"testing"
+
+	primage "github.com/asatisomnath/ProgImage/Convertors"
+)
+
+func TestParseOptions(t *testing.T) {
+	o, err := primage.ParseOptions(map[string]string{"q": "70", "compression": "Best", "colors": "64", "quantizer": "mediancut", "dither": "bayer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := primage.Options{Quality: 70, Compression: primage.CompressionBest, Colors: 64, Quantizer: primage.QuantizerMedianCut, Dither: primage.DitherBayer}
+	if o != expected {
+		t.Errorf("unexpected options %+v", o)
+	}
+	if s := o.String(); s != "encode:q=70,compression=best,colors=64,quantizer=mediancut,dither=bayer" {
+		t.Errorf("unexpected string %s", s)
+	}
+
+	for _, args := range []map[string]string{
+		{"q": "0"}, {"q": "101"}, {"q": "high"}, {"compression": "max"}, {"colors": "1"}, {"colors": "257"}, {"size": "1"},
+		{"quantizer": "octree"}, {"dither": "random"},
+	} {
+		if _, err := primage.ParseOptions(args); err == nil {
+			t.Errorf("expected error for %v", args)
+		}
+	}
+}
+
+func TestOptions_DefaultsAndCaps(t *testing.T) {
+	defaults := primage.Options{Quality: 80, Compression: primage.CompressionDefault, Colors: 128}
+	caps := primage.Options{Quality: 90, Compression: primage.CompressionSpeed}
+
+	tests := []struct {
+		Name     string
+		Options  primage.Options
+		Expected primage.Options
+	}{
+		{Name: "unset", Expected: primage.Options{Quality: 80, Compression: primage.CompressionSpeed, Colors: 128}},
+		{Name: "under caps", Options: primage.Options{Quality: 50, Compression: primage.CompressionNone, Colors: 256},
+			Expected: primage.Options{Quality: 50, Compression: primage.CompressionNone, Colors: 256}},
+		{Name: "over caps", Options: primage.Options{Quality: 100, Compression: primage.CompressionBest},
+			Expected: primage.Options{Quality: 90, Compression: primage.CompressionSpeed, Colors: 128}},
+	}
+	for _, item := range tests {
+		t.Run(item.Name, func(t *testing.T) {
+			if o := item.Options.WithDefaults(defaults).Capped(caps); o != item.Expected {
+				t.Errorf("expected %+v, got %+v", item.Expected, o)
+			}
+		})
+	}
+}
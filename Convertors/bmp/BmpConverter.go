@@ -0,0 +1,33 @@
+package bmp
+
+import (
+	"github.com/asatisomnath/ProgImage/Service"
+	"image"
+	"io"
+
+	primage "github.com/asatisomnath/ProgImage/Convertors"
+	"golang.org/x/image/bmp"
+)
+
+func init() {
+	Service.RegisterFormat(Service.Format{
+		Name:         "bmp",
+		Ext:          "bmp",
+		ContentType:  "image/bmp",
+		Magic:        []string{"BM"},
+		Decode:       bmp.Decode,
+		DecodeConfig: bmp.DecodeConfig,
+		Converter:    Converter,
+	})
+}
+
+// Transformer implements ProgImage.ImageTypeTransformer to convert a ProgImage.Image to bmp format.
+var Converter = primage.Converter{
+	Name:    "bmp",
+	Encoder: DefaultBmpEncode,
+}
+
+// DefaultBmpEncode performs bmp encoding, bmp has no options.
+func DefaultBmpEncode(w io.Writer, m image.Image, o primage.Options) error {
+	return bmp.Encode(w, m)
+}
@@ -0,0 +1,11 @@
+// Package formats registers every format ProgImage supports with Service, import it for its side effects.
+package formats
+
+import (
+	_ "github.com/asatisomnath/ProgImage/Convertors/bmp"  // register format, do not remove
+	_ "github.com/asatisomnath/ProgImage/Convertors/gif"  // register format, do not remove
+	_ "github.com/asatisomnath/ProgImage/Convertors/jpeg" // register format, do not remove
+	_ "github.com/asatisomnath/ProgImage/Convertors/png"  // register format, do not remove
+	_ "github.com/asatisomnath/ProgImage/Convertors/tiff" // register format, do not remove
+	_ "github.com/asatisomnath/ProgImage/Convertors/webp" // register format, do not remove
+)
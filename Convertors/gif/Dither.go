@@ -0,0 +1,69 @@
+package gif
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// bayer8 is the 8x8 Bayer threshold matrix, values 0 to 63.
+var bayer8 = [8][8]int{
+	{0, 32, 8, 40, 2, 34, 10, 42},
+	{48, 16, 56, 24, 50, 18, 58, 26},
+	{12, 44, 4, 36, 14, 46, 6, 38},
+	{60, 28, 52, 20, 62, 30, 54, 22},
+	{3, 35, 11, 43, 1, 33, 9, 41},
+	{51, 19, 59, 27, 49, 17, 57, 25},
+	{15, 47, 7, 39, 13, 45, 5, 37},
+	{63, 31, 55, 23, 61, 29, 53, 21},
+}
+
+// Bayer is a draw.Drawer that dithers with an ordered 8x8 Bayer pattern when drawing to a paletted image. Unlike
+// error diffusion the pattern is fixed to pixel positions, so it doesn't crawl between similar images and compresses
+// better. Other images are drawn as draw.Src would.
+var Bayer draw.Drawer = bayer{}
+
+type bayer struct{}
+
+func (bayer) Draw(dst draw.Image, r image.Rectangle, src image.Image, sp image.Point) {
+	pm, ok := dst.(*image.Paletted)
+	if !ok || len(pm.Palette) == 0 {
+		draw.Draw(dst, r, src, sp, draw.Src)
+		return
+	}
+
+	r = r.Intersect(dst.Bounds())
+	// the spread is about the distance between palette colours, were they spaced evenly
+	spread := 0xffff / math.Cbrt(float64(len(pm.Palette)))
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			cr, cg, cb, ca := src.At(sp.X+x-r.Min.X, sp.Y+y-r.Min.Y).RGBA()
+			if ca == 0 {
+				pm.SetColorIndex(x, y, uint8(pm.Palette.Index(color.RGBA64{})))
+				continue
+			}
+			t := (float64(bayer8[y&7][x&7])+0.5)/64 - 0.5
+			d := t * spread * float64(ca) / 0xffff // premultiplied
+			c := color.RGBA64{
+				R: offset(cr, d, ca),
+				G: offset(cg, d, ca),
+				B: offset(cb, d, ca),
+				A: uint16(ca),
+			}
+			pm.SetColorIndex(x, y, uint8(pm.Palette.Index(c)))
+		}
+	}
+}
+
+// offset moves v by d, keeping it within 0 and max.
+func offset(v uint32, d float64, max uint32) uint16 {
+	n := float64(v) + d
+	if n < 0 {
+		return 0
+	}
+	if n > float64(max) {
+		return uint16(max)
+	}
+	return uint16(n)
+}
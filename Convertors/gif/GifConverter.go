@@ -1,23 +1,109 @@
 package gif
 
 import (
+	"github.com/asatisomnath/ProgImage/Service"
 	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
 	"image/gif"
-	_ "image/jpeg" // import to register Convertors type
-	_ "image/png"  // import to register Convertors type
 	"io"
-	primage "github.com/asatisomnath/ProgImage/Convertors"
 
+	primage "github.com/asatisomnath/ProgImage/Convertors"
+	"github.com/pkg/errors"
 )
 
+func init() {
+	Service.RegisterFormat(Service.Format{
+		Name:         "gif",
+		Ext:          "gif",
+		ContentType:  "image/gif",
+		Magic:        []string{"GIF87a", "GIF89a"},
+		Decode:       gif.Decode,
+		DecodeConfig: gif.DecodeConfig,
+		Frames:       primage.GifFrames,
+		Converter:    Converter,
+	})
+}
+
 // Transformer implements ProgImage.ImageTypeTransformer to convert a ProgImage.Image to png format.
 var Converter = primage.Converter{
-	Name:        "gif",
-	ContentType: "Convertors/gif",
-	Encoder:     DefaultGifEncode,
+	Name:             "gif",
+	Encoder:          DefaultGifEncode,
+	AnimationEncoder: EncodeAnimation,
+}
+
+// DefaultGifEncode performs gif encoding with the number of colors, quantizer and dither from options. Unset, the
+// palette is all 256 colors picked by median cut and Floyd-Steinberg dithering is used.
+func DefaultGifEncode(w io.Writer, m image.Image, o primage.Options) error {
+	return gif.Encode(w, m, gifOptions(o))
+}
+
+// EncodeAnimation performs animated gif encoding, the frames share a palette picked for them all as DefaultGifEncode
+// would pick one for a single image.
+func EncodeAnimation(w io.Writer, a primage.Animation, o primage.Options) error {
+	if len(a.Frames) == 0 {
+		return errors.New("animation has no frames")
+	}
+	opts := gifOptions(o)
+	p := pickPalette(stack(a.Frames), opts)
+	b := a.Frames[0].Bounds()
+
+	g := &gif.GIF{
+		Delay:     a.Delays,
+		Disposal:  a.Disposals,
+		LoopCount: a.LoopCount,
+		Config:    image.Config{ColorModel: p, Width: b.Dx(), Height: b.Dy()},
+	}
+	for _, f := range a.Frames {
+		fb := f.Bounds()
+		pm := image.NewPaletted(image.Rect(0, 0, fb.Dx(), fb.Dy()), p)
+		opts.Drawer.Draw(pm, pm.Bounds(), f, fb.Min)
+		g.Image = append(g.Image, pm)
+	}
+	return gif.EncodeAll(w, g)
+}
+
+func gifOptions(o primage.Options) *gif.Options {
+	opts := &gif.Options{NumColors: 256, Quantizer: MedianCut{}, Drawer: draw.FloydSteinberg}
+	if o.Colors != 0 {
+		opts.NumColors = o.Colors
+	}
+	if o.Quantizer == primage.QuantizerPlan9 {
+		opts.Quantizer = nil
+	}
+	switch o.Dither {
+	case primage.DitherNone:
+		opts.Drawer = draw.Src
+	case primage.DitherBayer:
+		opts.Drawer = Bayer
+	}
+	return opts
+}
+
+// pickPalette picks the palette for m from opts.
+func pickPalette(m image.Image, opts *gif.Options) color.Palette {
+	if opts.Quantizer == nil {
+		return palette.Plan9[:opts.NumColors]
+	}
+	return opts.Quantizer.Quantize(make(color.Palette, 0, opts.NumColors), m)
+}
+
+// stack is frames of the same size one above the other, so a palette can be picked for them all.
+type stack []image.Image
+
+func (s stack) ColorModel() color.Model {
+	return color.RGBAModel
+}
+
+func (s stack) Bounds() image.Rectangle {
+	b := s[0].Bounds()
+	return image.Rect(0, 0, b.Dx(), b.Dy()*len(s))
 }
 
-// DefaultGifEncode performs fig encoding with default values for options.
-func DefaultGifEncode(w io.Writer, m image.Image) error {
-	return gif.Encode(w, m, nil)
+func (s stack) At(x, y int) color.Color {
+	h := s[0].Bounds().Dy()
+	f := s[y/h]
+	b := f.Bounds()
+	return f.At(b.Min.X+x, b.Min.Y+y%h)
 }
@@ -3,9 +3,14 @@ package gif_test
 import (
 	"github.com/asatisomnath/ProgImage/Service"
 	"image"
+	stdgif "image/gif"
+	"io"
+	"io/ioutil"
 	"os"
 	"testing"
 
+	primage "github.com/asatisomnath/ProgImage/Convertors"
+	_ "github.com/asatisomnath/ProgImage/Convertors/formats" // register formats, do not remove
 	"github.com/asatisomnath/ProgImage/Convertors/gif"
 )
 
@@ -14,9 +19,39 @@ var fileTests = []struct {
 	Path        string
 	ContentType string
 }{
-	{Name: "png", Path: "../../testimages/test.png", ContentType: "Convertors/png"},
-	{Name: "gif", Path: "../../testimages/test.gif", ContentType: "Convertors/gif"},
-	{Name: "jpg", Path: "../../testimages/test.jpg", ContentType: "Convertors/jpeg"},
+	{Name: "png", Path: "../../testimages/test.png", ContentType: "image/png"},
+	{Name: "gif", Path: "../../testimages/test.gif", ContentType: "image/gif"},
+	{Name: "jpg", Path: "../../testimages/test.jpg", ContentType: "image/jpeg"},
+}
+
+func TestTransformGif_Colors(t *testing.T) {
+	for _, colors := range []int{2, 16, 256} {
+		fp, err := os.Open("../../testimages/test.jpg")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		errCh := make(chan error, 1)
+		imgOut, err := gif.Converter.Convert(Service.Image{ID: "jpg", ContentType: "image/jpeg", Data: fp}, errCh, primage.Options{Colors: colors})
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := stdgif.Decode(imgOut.Data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.Copy(ioutil.Discard, imgOut.Data); err != nil {
+			t.Fatal(err)
+		}
+		if err := <-errCh; err != nil {
+			t.Fatal(err)
+		}
+		fp.Close() // nolint: gas,errcheck
+
+		if n := len(out.(*image.Paletted).Palette); n > colors {
+			t.Errorf("expected at most %d colors, got %d", colors, n)
+		}
+	}
 }
 
 func TestTransformGif(t *testing.T) {
@@ -47,6 +82,10 @@ func TestTransformGif(t *testing.T) {
 			if typ != "gif" {
 				t.Errorf("expected type of converted Convertors to be gif, got %s", typ)
 			}
+			// the gif decoder stops before the trailer, drain it so the encoder can finish
+			if _, err := io.Copy(ioutil.Discard, imgOut.Data); err != nil {
+				t.Fatal(err)
+			}
 			if err := <-errCh; err != nil {
 				t.Errorf("got error converting Convertors %s", err)
 			}
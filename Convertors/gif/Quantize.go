@@ -0,0 +1,175 @@
+package gif
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"sort"
+)
+
+// maxSamples is roughly the most pixels MedianCut looks at, larger images are sampled evenly.
+const maxSamples = 256 * 1024
+
+var _ draw.Quantizer = MedianCut{}
+
+// MedianCut is a draw.Quantizer that builds a palette suited to the image. The colours in the image are put in a box
+// that's repeatedly cut in two at the median of its widest channel, each box gives the average of its colours to the
+// palette. Mostly transparent pixels get a single transparent entry.
+type MedianCut struct{}
+
+// histogram entries are colours reduced to 5 bits per channel, with the sums of the full colours they stand for
+type entry struct {
+	rgb   [3]uint8
+	count int
+	sum   [3]int
+}
+
+type box []entry
+
+// Quantize appends up to cap(p)-len(p) colours for m to p.
+func (MedianCut) Quantize(p color.Palette, m image.Image) color.Palette {
+	n := cap(p) - len(p)
+	if n <= 0 {
+		return p
+	}
+
+	b := m.Bounds()
+	step := 1
+	for b.Dx()*b.Dy()/(step*step) > maxSamples {
+		step++
+	}
+
+	hist := map[uint16]*entry{}
+	transparent := false
+	for y := b.Min.Y; y < b.Max.Y; y += step {
+		for x := b.Min.X; x < b.Max.X; x += step {
+			r, g, bl, a := m.At(x, y).RGBA()
+			if a < 0x8000 {
+				transparent = true
+				continue
+			}
+			// unpremultiply to 8 bits
+			c := [3]int{int(r * 0xff / a), int(g * 0xff / a), int(bl * 0xff / a)}
+			k := uint16(c[0]>>3)<<10 | uint16(c[1]>>3)<<5 | uint16(c[2]>>3)
+			e, ok := hist[k]
+			if !ok {
+				e = &entry{rgb: [3]uint8{uint8(c[0] >> 3), uint8(c[1] >> 3), uint8(c[2] >> 3)}}
+				hist[k] = e
+			}
+			e.count++
+			for i := range c {
+				e.sum[i] += c[i]
+			}
+		}
+	}
+
+	if transparent {
+		p = append(p, color.RGBA{})
+		n--
+	}
+	if n <= 0 || len(hist) == 0 {
+		return p
+	}
+
+	all := make(box, 0, len(hist))
+	for _, e := range hist {
+		all = append(all, *e)
+	}
+	// map order is random, start from a fixed order so the palette is the same every time
+	sort.Slice(all, func(i, j int) bool {
+		return key(all[i]) < key(all[j])
+	})
+
+	boxes := []box{all}
+	for len(boxes) < n {
+		i := widest(boxes)
+		if i < 0 {
+			break
+		}
+		lo, hi := boxes[i].cut()
+		boxes[i] = lo
+		boxes = append(boxes, hi)
+	}
+
+	for _, bx := range boxes {
+		p = append(p, bx.average())
+	}
+	return p
+}
+
+func key(e entry) uint16 {
+	return uint16(e.rgb[0])<<10 | uint16(e.rgb[1])<<5 | uint16(e.rgb[2])
+}
+
+// widest finds the box to cut next, the one with the most pixels over the widest range, -1 when none can be cut.
+func widest(boxes []box) int {
+	best, bestScore := -1, 0
+	for i, bx := range boxes {
+		if len(bx) < 2 {
+			continue
+		}
+		_, r := bx.channel()
+		if s := r * bx.count(); s > bestScore {
+			best, bestScore = i, s
+		}
+	}
+	return best
+}
+
+// channel finds the channel with the widest range of values in the box.
+func (bx box) channel() (int, int) {
+	ch, widest := 0, -1
+	for c := 0; c < 3; c++ {
+		lo, hi := uint8(255), uint8(0)
+		for _, e := range bx {
+			if e.rgb[c] < lo {
+				lo = e.rgb[c]
+			}
+			if e.rgb[c] > hi {
+				hi = e.rgb[c]
+			}
+		}
+		if r := int(hi - lo); r > widest {
+			ch, widest = c, r
+		}
+	}
+	return ch, widest
+}
+
+func (bx box) count() int {
+	n := 0
+	for _, e := range bx {
+		n += e.count
+	}
+	return n
+}
+
+// cut splits the box in two at the median pixel of its widest channel, both halves have at least one colour.
+func (bx box) cut() (box, box) {
+	c, _ := bx.channel()
+	sort.SliceStable(bx, func(i, j int) bool {
+		return bx[i].rgb[c] < bx[j].rgb[c]
+	})
+
+	half, seen := bx.count()/2, 0
+	i := 1
+	for ; i < len(bx)-1; i++ {
+		seen += bx[i-1].count
+		if seen >= half {
+			break
+		}
+	}
+	return bx[:i:i], bx[i:]
+}
+
+func (bx box) average() color.Color {
+	var sum [3]int
+	n := 0
+	for _, e := range bx {
+		for i := range sum {
+			sum[i] += e.sum[i]
+		}
+		n += e.count
+	}
+	return color.RGBA{R: uint8(sum[0] / n), G: uint8(sum[1] / n), B: uint8(sum[2] / n), A: 0xff}
+}
@@ -0,0 +1,150 @@
+package gif_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	stdgif "image/gif"
+	"image/jpeg"
+	"os"
+	"testing"
+
+	primage "github.com/asatisomnath/ProgImage/Convertors"
+	"github.com/asatisomnath/ProgImage/Convertors/gif"
+)
+
+func TestMedianCut(t *testing.T) {
+	colors := []color.RGBA{{R: 255, A: 255}, {G: 255, A: 255}, {B: 255, A: 255}, {R: 200, G: 200, B: 200, A: 255}}
+	img := image.NewRGBA(image.Rect(0, 0, 40, 10))
+	for i, c := range colors {
+		draw.Draw(img, image.Rect(i*10, 0, i*10+10, 10), image.NewUniform(c), image.Point{}, draw.Src)
+	}
+
+	p := gif.MedianCut{}.Quantize(make(color.Palette, 0, 4), img)
+	if len(p) != 4 {
+		t.Fatalf("expected 4 colors, got %d", len(p))
+	}
+	for _, c := range colors {
+		if p[p.Index(c)] != color.Color(c) {
+			t.Errorf("expected %v in the palette, got %v", c, p)
+		}
+	}
+	if again := (gif.MedianCut{}).Quantize(make(color.Palette, 0, 4), img); !equalPalettes(p, again) {
+		t.Error("expected the same palette every time")
+	}
+
+	// transparency gets an entry of its own
+	draw.Draw(img, image.Rect(0, 0, 10, 10), image.Transparent, image.Point{}, draw.Src)
+	p = gif.MedianCut{}.Quantize(make(color.Palette, 0, 4), img)
+	if len(p) != 4 || p[0] != color.Color(color.RGBA{}) {
+		t.Errorf("expected a transparent entry first, got %v", p)
+	}
+
+	// fewer colours in the image than asked for
+	p = gif.MedianCut{}.Quantize(make(color.Palette, 0, 256), image.NewUniform(color.White))
+	if len(p) != 1 {
+		t.Errorf("expected a single color, got %d", len(p))
+	}
+}
+
+func TestBayer(t *testing.T) {
+	gray := image.NewUniform(color.Gray{Y: 128})
+	bw := color.Palette{color.Black, color.White}
+	b := image.Rect(0, 0, 16, 16)
+
+	plain := image.NewPaletted(b, bw)
+	draw.Src.Draw(plain, b, gray, image.Point{})
+	if n := whites(plain); n != 0 && n != 256 {
+		t.Errorf("expected undithered gray to be a single color, got %d white", n)
+	}
+
+	dithered := image.NewPaletted(b, bw)
+	gif.Bayer.Draw(dithered, b, gray, image.Point{})
+	if n := whites(dithered); n < 112 || n > 144 {
+		t.Errorf("expected about half white, got %d", n)
+	}
+}
+
+// TestTransformGif_Quality checks the adapted palette is closer to a photo than the fixed one.
+func TestTransformGif_Quality(t *testing.T) {
+	fp, err := os.Open("../../testimages/test.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fp.Close()
+	src, err := jpeg.Decode(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// smaller is quicker and just as telling
+	small := image.NewRGBA(image.Rect(0, 0, 480, 360))
+	for y := 0; y < 360; y++ {
+		for x := 0; x < 480; x++ {
+			small.Set(x, y, src.At(x*4, y*4))
+		}
+	}
+
+	errs := map[string]float64{}
+	for _, q := range []string{primage.QuantizerMedianCut, primage.QuantizerPlan9} {
+		buf := bytes.Buffer{}
+		if err := gif.DefaultGifEncode(&buf, small, primage.Options{Quantizer: q, Dither: primage.DitherNone}); err != nil {
+			t.Fatal(err)
+		}
+		out, err := stdgif.Decode(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		errs[q] = meanSquaredError(small, out)
+	}
+	if errs[primage.QuantizerMedianCut] >= errs[primage.QuantizerPlan9] {
+		t.Errorf("expected median cut to be closer than plan9, got %v", errs)
+	}
+
+	for _, d := range []string{primage.DitherNone, primage.DitherFloydSteinberg, primage.DitherBayer} {
+		buf := bytes.Buffer{}
+		if err := gif.DefaultGifEncode(&buf, small, primage.Options{Colors: 16, Dither: d}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := stdgif.Decode(&buf); err != nil {
+			t.Errorf("unable to decode gif dithered with %s, %v", d, err)
+		}
+	}
+}
+
+func whites(img *image.Paletted) int {
+	n := 0
+	for _, i := range img.Pix {
+		if i == 1 {
+			n++
+		}
+	}
+	return n
+}
+
+func meanSquaredError(a, b image.Image) float64 {
+	var sum float64
+	bounds := a.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			ar, ag, ab, _ := a.At(x, y).RGBA()
+			br, bg, bb, _ := b.At(x, y).RGBA()
+			for _, d := range []float64{float64(ar>>8) - float64(br>>8), float64(ag>>8) - float64(bg>>8), float64(ab>>8) - float64(bb>>8)} {
+				sum += d * d
+			}
+		}
+	}
+	return sum / float64(bounds.Dx()*bounds.Dy()*3)
+}
+
+func equalPalettes(a, b color.Palette) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -1,24 +1,37 @@
 package jpeg
 
 import (
+	"github.com/asatisomnath/ProgImage/Service"
 	"image"
-	_ "image/gif" // register Convertors type, do not remove
 	"image/jpeg"
-	_ "image/png" // register Convertors type, do not remove
 	"io"
 
 	primage "github.com/asatisomnath/ProgImage/Convertors"
-
 )
 
+func init() {
+	Service.RegisterFormat(Service.Format{
+		Name:         "jpeg",
+		Ext:          "jpg",
+		Aliases:      []string{"jpeg"},
+		ContentType:  "image/jpeg",
+		Magic:        []string{"\xff\xd8\xff"},
+		Decode:       jpeg.Decode,
+		DecodeConfig: jpeg.DecodeConfig,
+		Converter:    Converter,
+	})
+}
+
 // Transformer implements ProgImage.ImageTypeTransformer to convert a ProgImage.Image to jpeg format.
 var Converter = primage.Converter{
-	Name:        "jpeg",
-	ContentType: "Convertors/jpeg",
-	Encoder:     DefaultJpegEncode,
+	Name:    "jpeg",
+	Encoder: DefaultJpegEncode,
 }
 
-// DefaultJpegEncode performs jpeg encoding with default values for options.
-func DefaultJpegEncode(w io.Writer, m image.Image) error {
-	return jpeg.Encode(w, m, nil)
+// DefaultJpegEncode performs jpeg encoding with the quality from options, the default when it's unset.
+func DefaultJpegEncode(w io.Writer, m image.Image, o primage.Options) error {
+	if o.Quality == 0 {
+		return jpeg.Encode(w, m, nil)
+	}
+	return jpeg.Encode(w, m, &jpeg.Options{Quality: o.Quality})
 }
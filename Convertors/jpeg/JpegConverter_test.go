@@ -3,9 +3,12 @@ package jpeg_test
 import (
 	"github.com/asatisomnath/ProgImage/Service"
 	"image"
+	"io/ioutil"
 	"os"
 	"testing"
 
+	primage "github.com/asatisomnath/ProgImage/Convertors"
+	_ "github.com/asatisomnath/ProgImage/Convertors/formats" // register formats, do not remove
 	"github.com/asatisomnath/ProgImage/Convertors/jpeg"
 )
 
@@ -14,9 +17,9 @@ var fileTests = []struct {
 	Path        string
 	ContentType string
 }{
-	{Name: "png", Path: "../../testimages/test.png", ContentType: "Convertors/png"},
-	{Name: "gif", Path: "../../testimages/test.gif", ContentType: "Convertors/gif"},
-	{Name: "jpg", Path: "../../testimages/test.jpg", ContentType: "Convertors/jpeg"},
+	{Name: "png", Path: "../../testimages/test.png", ContentType: "image/png"},
+	{Name: "gif", Path: "../../testimages/test.gif", ContentType: "image/gif"},
+	{Name: "jpg", Path: "../../testimages/test.jpg", ContentType: "image/jpeg"},
 }
 
 func TestTransformPNG(t *testing.T) {
@@ -54,6 +57,48 @@ func TestTransformPNG(t *testing.T) {
 	}
 }
 
+// convertedSize converts the test png with c, returning how many bytes it's encoded to.
+func convertedSize(t *testing.T, c primage.Converter, transformers ...Service.Transformer) int {
+	fp, err := os.Open("../../testimages/test.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fp.Close()
+
+	errCh := make(chan error, 1)
+	imgOut, err := c.Convert(Service.Image{ID: "png", ContentType: "image/png", Data: fp}, errCh, transformers...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := ioutil.ReadAll(imgOut.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+	return len(d)
+}
+
+func TestTransformJPEG_Quality(t *testing.T) {
+	low := convertedSize(t, jpeg.Converter, primage.Options{Quality: 10})
+	def := convertedSize(t, jpeg.Converter)
+	high := convertedSize(t, jpeg.Converter, primage.Options{Quality: 95})
+	if !(low < def && def < high) {
+		t.Errorf("expected size to grow with quality, got %d, %d and %d", low, def, high)
+	}
+
+	c := jpeg.Converter
+	c.Defaults = primage.Options{Quality: 10}
+	c.Caps = primage.Options{Quality: 50}
+	if n := convertedSize(t, c); n != low {
+		t.Errorf("expected default quality to be used, got %d bytes not %d", n, low)
+	}
+	if n := convertedSize(t, c, primage.Options{Quality: 95}); n != convertedSize(t, jpeg.Converter, primage.Options{Quality: 50}) {
+		t.Errorf("expected quality to be capped, got %d bytes", n)
+	}
+}
+
 func BenchmarkTransformToJPEG(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		for _, item := range fileTests {
@@ -1,16 +1,42 @@
 package png
 
 import (
-	_ "image/gif"  // register Convertors type, do not remove
-	_ "image/jpeg" // register Convertors type, do not remove
+	"github.com/asatisomnath/ProgImage/Service"
+	"image"
 	"image/png"
+	"io"
 
 	primage "github.com/asatisomnath/ProgImage/Convertors"
 )
 
+// compressionLevels are the png compression levels for each of the compression options.
+var compressionLevels = map[string]png.CompressionLevel{
+	primage.CompressionNone:    png.NoCompression,
+	primage.CompressionSpeed:   png.BestSpeed,
+	primage.CompressionDefault: png.DefaultCompression,
+	primage.CompressionBest:    png.BestCompression,
+}
+
+func init() {
+	Service.RegisterFormat(Service.Format{
+		Name:         "png",
+		Ext:          "png",
+		ContentType:  "image/png",
+		Magic:        []string{"\x89PNG\r\n\x1a\n"},
+		Decode:       png.Decode,
+		DecodeConfig: png.DecodeConfig,
+		Converter:    Converter,
+	})
+}
+
 // Transformer implements ProgImage.ImageTypeTransformer to convert a ProgImage.Image to png format.
 var Converter = primage.Converter{
-	Name:        "png",
-	ContentType: "Convertors/png",
-	Encoder:     png.Encode,
+	Name:    "png",
+	Encoder: DefaultPngEncode,
+}
+
+// DefaultPngEncode performs png encoding with the compression from options, the default when it's unset.
+func DefaultPngEncode(w io.Writer, m image.Image, o primage.Options) error {
+	enc := png.Encoder{CompressionLevel: compressionLevels[o.Compression]}
+	return enc.Encode(w, m)
 }
@@ -1,12 +1,16 @@
 package png_test
 
 import (
+	"bytes"
 	"github.com/asatisomnath/ProgImage/Service"
 	"image"
+	"image/jpeg"
 	"os"
 	"testing"
 
+	_ "github.com/asatisomnath/ProgImage/Convertors/formats" // register formats, do not remove
 	"github.com/asatisomnath/ProgImage/Convertors/png"
+	"github.com/asatisomnath/ProgImage/Transformers/orient"
 )
 
 var fileTests = []struct {
@@ -14,9 +18,9 @@ var fileTests = []struct {
 	Path        string
 	ContentType string
 }{
-	{Name: "png", Path: "../../testimages/test.png", ContentType: "Convertors/png"},
-	{Name: "gif", Path: "../../testimages/test.gif", ContentType: "Convertors/gif"},
-	{Name: "jpg", Path: "../../testimages/test.jpg", ContentType: "Convertors/jpeg"},
+	{Name: "png", Path: "../../testimages/test.png", ContentType: "image/png"},
+	{Name: "gif", Path: "../../testimages/test.gif", ContentType: "image/gif"},
+	{Name: "jpg", Path: "../../testimages/test.jpg", ContentType: "image/jpeg"},
 }
 
 func TestTransformPNG(t *testing.T) {
@@ -54,6 +58,53 @@ func TestTransformPNG(t *testing.T) {
 	}
 }
 
+// sideways encodes a w by h JPEG tagged with EXIF orientation 6, so it's displayed h by w.
+func sideways(t *testing.T, w, h int) []byte {
+	buf := bytes.Buffer{}
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, w, h)), nil); err != nil {
+		t.Fatal(err)
+	}
+	// big endian TIFF with a single IFD entry, orientation as a SHORT
+	exif := []byte("Exif\x00\x00MM\x00*\x00\x00\x00\x08\x00\x01\x01\x12\x00\x03\x00\x00\x00\x01\x00\x06\x00\x00\x00\x00\x00\x00")
+	out := []byte{0xFF, 0xD8, 0xFF, 0xE1, 0x00, byte(len(exif) + 2)}
+	out = append(out, exif...)
+	return append(out, buf.Bytes()[2:]...)
+}
+
+func TestTransformPNG_Orientation(t *testing.T) {
+	tests := []struct {
+		Name          string
+		Transformers  []Service.Transformer
+		Width, Height int
+	}{
+		{Name: "corrected", Width: 20, Height: 40},
+		{Name: "corrected then rotated", Transformers: []Service.Transformer{orient.Rotate{Degrees: 90}}, Width: 40, Height: 20},
+		{Name: "kept", Transformers: []Service.Transformer{orient.Keep}, Width: 40, Height: 20},
+	}
+	for _, item := range tests {
+		t.Run(item.Name, func(t *testing.T) {
+			img := Service.Image{
+				ID:          item.Name,
+				ContentType: "image/jpeg",
+				Data:        bytes.NewReader(sideways(t, 40, 20)),
+			}
+
+			errCh := make(chan error, 1)
+			imgOut, err := png.Converter.Convert(img, errCh, item.Transformers...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg, _, err := image.DecodeConfig(imgOut.Data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Width != item.Width || cfg.Height != item.Height {
+				t.Errorf("expected %dx%d, got %dx%d", item.Width, item.Height, cfg.Width, cfg.Height)
+			}
+		})
+	}
+}
+
 func BenchmarkTransformToPNG(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		for _, item := range fileTests {
@@ -0,0 +1,38 @@
+package tiff
+
+import (
+	"github.com/asatisomnath/ProgImage/Service"
+	"image"
+	"io"
+
+	primage "github.com/asatisomnath/ProgImage/Convertors"
+	"golang.org/x/image/tiff"
+)
+
+func init() {
+	Service.RegisterFormat(Service.Format{
+		Name:         "tiff",
+		Ext:          "tiff",
+		Aliases:      []string{"tif"},
+		ContentType:  "image/tiff",
+		Magic:        []string{"II*\x00", "MM\x00*"},
+		Decode:       tiff.Decode,
+		DecodeConfig: tiff.DecodeConfig,
+		Converter:    Converter,
+	})
+}
+
+// Transformer implements ProgImage.ImageTypeTransformer to convert a ProgImage.Image to tiff format.
+var Converter = primage.Converter{
+	Name:    "tiff",
+	Encoder: DefaultTiffEncode,
+}
+
+// DefaultTiffEncode performs tiff encoding with the compression from options. Deflate with a predictor is used unless
+// it's none, the levels in between don't apply.
+func DefaultTiffEncode(w io.Writer, m image.Image, o primage.Options) error {
+	if o.Compression == primage.CompressionNone {
+		return tiff.Encode(w, m, &tiff.Options{Compression: tiff.Uncompressed})
+	}
+	return tiff.Encode(w, m, &tiff.Options{Compression: tiff.Deflate, Predictor: true})
+}
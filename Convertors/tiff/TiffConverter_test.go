@@ -0,0 +1,58 @@
+package tiff_test
+
+import (
+	"github.com/asatisomnath/ProgImage/Service"
+	"image"
+	"os"
+	"testing"
+
+	_ "github.com/asatisomnath/ProgImage/Convertors/formats" // register formats, do not remove
+	"github.com/asatisomnath/ProgImage/Convertors/tiff"
+)
+
+var fileTests = []struct {
+	Name        string
+	Path        string
+	ContentType string
+}{
+	{Name: "png", Path: "../../testimages/test.png", ContentType: "image/png"},
+	{Name: "gif", Path: "../../testimages/test.gif", ContentType: "image/gif"},
+	{Name: "jpg", Path: "../../testimages/test.jpg", ContentType: "image/jpeg"},
+	{Name: "bmp", Path: "../../testimages/test.bmp", ContentType: "image/bmp"},
+	{Name: "tiff", Path: "../../testimages/test.tiff", ContentType: "image/tiff"},
+}
+
+func TestTransformTIFF(t *testing.T) {
+	for _, item := range fileTests {
+		t.Run(item.Name, func(t *testing.T) {
+			fp, err := os.Open(item.Path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer fp.Close()
+
+			img := Service.Image{
+				ID:          item.Name,
+				ContentType: item.ContentType,
+				Data:        fp,
+			}
+
+			errCh := make(chan error, 1)
+			imgOut, err := tiff.Converter.Convert(img, errCh)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, typ, err := image.Decode(imgOut.Data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if typ != "tiff" {
+				t.Errorf("expected type of converted Convertors to be tiff, got %s", typ)
+			}
+			if err := <-errCh; err != nil {
+				t.Errorf("got error converting Convertors %s", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,384 @@
+package webp
+
+import (
+	"encoding/binary"
+	"image"
+	"image/draw"
+	"io"
+	"math/bits"
+
+	"github.com/pkg/errors"
+)
+
+// MaxDimension is the widest or tallest image a WebP can hold.
+const MaxDimension = 1 << 14
+
+const (
+	transformPredictor     = 0
+	transformSubtractGreen = 2
+
+	// predictorBits is the log 2 size of the tiles that each pick a predictor.
+	predictorBits = 4
+	nPredictors   = 14
+
+	nLiterals      = 256
+	nLengthCodes   = 24
+	nDistanceCodes = 40
+
+	// distance codes up to planeCodes are offsets around the pixel, above is 1 and left 2, beyond that they're the
+	// distance plus planeCodes.
+	planeCodes = 120
+	aboveCode  = 1
+	leftCode   = 2
+
+	minMatch    = 3
+	maxMatch    = 4096
+	maxDistance = 1<<20 - planeCodes
+	hashBits    = 16
+)
+
+// Encode writes m to w as a lossless WebP. Green is subtracted from red and blue, each pixel is predicted from its
+// neighbours by whichever predictor suits its tile best and the residuals are prefix coded, with runs and repeats
+// coded as backward references.
+func Encode(w io.Writer, m image.Image) error {
+	b := m.Bounds()
+	if b.Empty() {
+		return errors.New("webp: image is empty")
+	}
+	if b.Dx() > MaxDimension || b.Dy() > MaxDimension {
+		return errors.Errorf("webp: image is over %d pixels wide or high", MaxDimension)
+	}
+	img := copyNRGBA(m)
+	width, height := b.Dx(), b.Dy()
+
+	bw := &bitWriter{}
+	bw.write(0x2f, 8)
+	bw.write(uint32(width-1), 14)
+	bw.write(uint32(height-1), 14)
+	if opaque(img.Pix) {
+		bw.write(0, 1)
+	} else {
+		bw.write(1, 1)
+	}
+	bw.write(0, 3) // version
+
+	subtractGreen(img.Pix)
+	bw.write(1, 1)
+	bw.write(transformSubtractGreen, 2)
+
+	modes := pickPredictors(img.Pix, width, height)
+	bw.write(1, 1)
+	bw.write(transformPredictor, 2)
+	bw.write(predictorBits-2, 3)
+	writeImage(bw, backwardRefs(modes, tiles(width)), false)
+	bw.write(0, 1) // no more transforms
+
+	writeImage(bw, backwardRefs(residuals(img.Pix, width, height, modes), width), true)
+	return writeRIFF(w, bw.bytes())
+}
+
+// copyNRGBA copies m to an NRGBA image starting at 0,0. NRGBA images are copied exactly, so the color of transparent
+// pixels is kept.
+func copyNRGBA(m image.Image) *image.NRGBA {
+	b := m.Bounds()
+	ret := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	if n, ok := m.(*image.NRGBA); ok {
+		for y := 0; y < b.Dy(); y++ {
+			i := n.PixOffset(b.Min.X, b.Min.Y+y)
+			copy(ret.Pix[y*ret.Stride:(y+1)*ret.Stride], n.Pix[i:i+4*b.Dx()])
+		}
+		return ret
+	}
+	draw.Draw(ret, ret.Bounds(), m, b.Min, draw.Src)
+	return ret
+}
+
+func opaque(pix []byte) bool {
+	for p := 3; p < len(pix); p += 4 {
+		if pix[p] != 0xff {
+			return false
+		}
+	}
+	return true
+}
+
+// subtractGreen takes green from red and blue, which tend to follow it.
+func subtractGreen(pix []byte) {
+	for p := 0; p < len(pix); p += 4 {
+		pix[p] -= pix[p+1]
+		pix[p+2] -= pix[p+1]
+	}
+}
+
+// tiles is how many predictor tiles cover n pixels.
+func tiles(n int) int {
+	return (n + 1<<predictorBits - 1) >> predictorBits
+}
+
+// pickPredictors picks the predictor with the smallest residuals for each tile, returning them as the image written
+// with the transform, the predictor in the green of each pixel.
+func pickPredictors(pix []byte, width, height int) []uint32 {
+	tw, th := tiles(width), tiles(height)
+	modes := make([]uint32, tw*th)
+	for ty := 0; ty < th; ty++ {
+		for tx := 0; tx < tw; tx++ {
+			best, bestCost := 0, -1
+			for mode := 0; mode < nPredictors; mode++ {
+				cost := 0
+				// the first row and column have predictors of their own
+				for y := maxInt(ty<<predictorBits, 1); y < minInt((ty+1)<<predictorBits, height); y++ {
+					for x := maxInt(tx<<predictorBits, 1); x < minInt((tx+1)<<predictorBits, width); x++ {
+						p := 4 * (y*width + x)
+						pred := predict(mode, pix, p, p-4*width)
+						for c := 0; c < 4; c++ {
+							cost += absInt(int(int8(pix[p+c] - pred[c])))
+						}
+					}
+				}
+				if bestCost < 0 || cost < bestCost {
+					best, bestCost = mode, cost
+				}
+			}
+			modes[ty*tw+tx] = argb(0, byte(best), 0, 0xff)
+		}
+	}
+	return modes
+}
+
+// residuals is the difference of each pixel from its prediction, as ARGB.
+func residuals(pix []byte, width, height int, modes []uint32) []uint32 {
+	ret := make([]uint32, width*height)
+	tw := tiles(width)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			p := 4 * (y*width + x)
+			mode := int(modes[(y>>predictorBits)*tw+x>>predictorBits]>>8) & 0xf
+			switch {
+			case x == 0 && y == 0:
+				mode = 0
+			case y == 0:
+				mode = 1
+			case x == 0:
+				mode = 2
+			}
+			pred := predict(mode, pix, p, p-4*width)
+			ret[y*width+x] = argb(pix[p]-pred[0], pix[p+1]-pred[1], pix[p+2]-pred[2], pix[p+3]-pred[3])
+		}
+	}
+	return ret
+}
+
+// predict gives the prediction of mode for the RGBA pixel at p, with the pixel above it at top, as the decoder makes
+// it. The pixels a mode doesn't use can be out of range.
+func predict(mode int, pix []byte, p, top int) [4]byte {
+	var ret [4]byte
+	if mode == 11 {
+		// select whichever of left and top is closer to the gradient from the top left
+		l, t := 0, 0
+		for c := 0; c < 4; c++ {
+			l += absInt(int(pix[top-4+c]) - int(pix[top+c]))
+			t += absInt(int(pix[top-4+c]) - int(pix[p-4+c]))
+		}
+		if l < t {
+			copy(ret[:], pix[p-4:p])
+		} else {
+			copy(ret[:], pix[top:top+4])
+		}
+		return ret
+	}
+
+	for c := 0; c < 4; c++ {
+		switch mode {
+		case 0:
+			if c == 3 {
+				ret[c] = 0xff
+			}
+		case 1:
+			ret[c] = pix[p-4+c]
+		case 2:
+			ret[c] = pix[top+c]
+		case 3:
+			ret[c] = pix[top+4+c]
+		case 4:
+			ret[c] = pix[top-4+c]
+		case 5:
+			ret[c] = avg2(avg2(pix[p-4+c], pix[top+4+c]), pix[top+c])
+		case 6:
+			ret[c] = avg2(pix[p-4+c], pix[top-4+c])
+		case 7:
+			ret[c] = avg2(pix[p-4+c], pix[top+c])
+		case 8:
+			ret[c] = avg2(pix[top-4+c], pix[top+c])
+		case 9:
+			ret[c] = avg2(pix[top+c], pix[top+4+c])
+		case 10:
+			ret[c] = avg2(avg2(pix[p-4+c], pix[top-4+c]), avg2(pix[top+c], pix[top+4+c]))
+		case 12:
+			ret[c] = clamp(int(pix[p-4+c]) + int(pix[top+c]) - int(pix[top-4+c]))
+		case 13:
+			a := int(avg2(pix[p-4+c], pix[top+c]))
+			ret[c] = clamp(a + (a-int(pix[top-4+c]))/2)
+		}
+	}
+	return ret
+}
+
+func avg2(a, b byte) byte {
+	return byte((int(a) + int(b)) / 2)
+}
+
+func clamp(v int) byte {
+	if v < 0 {
+		return 0
+	}
+	if v > 0xff {
+		return 0xff
+	}
+	return byte(v)
+}
+
+func argb(r, g, b, a byte) uint32 {
+	return uint32(a)<<24 | uint32(r)<<16 | uint32(g)<<8 | uint32(b)
+}
+
+// symbol is a literal pixel or a backward reference copying length pixels from those at the distance code.
+type symbol struct {
+	argb     uint32
+	length   int // 0 for a literal
+	distance int
+}
+
+// backwardRefs turns pixels into symbols, greedily taking the longest of a run from the left, a run from above or a
+// repeat of the last pixels with the same hash.
+func backwardRefs(pix []uint32, width int) []symbol {
+	var ret []symbol
+	last := make([]int32, 1<<hashBits) // position + 1
+	hash := func(i int) uint32 {
+		return (pix[i]*0x1e35a7bd ^ pix[i+1]*0x9e3779b1) >> (32 - hashBits)
+	}
+	for i := 0; i < len(pix); {
+		length, distance := 0, 0
+		try := func(dist, code int) {
+			if dist > i {
+				return
+			}
+			n := 0
+			for n < maxMatch && i+n < len(pix) && pix[i+n] == pix[i+n-dist] {
+				n++
+			}
+			if n > length {
+				length, distance = n, code
+			}
+		}
+		try(1, leftCode)
+		try(width, aboveCode)
+		if i+1 < len(pix) {
+			if j := int(last[hash(i)]) - 1; j >= 0 && i-j <= maxDistance {
+				try(i-j, i-j+planeCodes)
+			}
+		}
+
+		if length < minMatch {
+			ret = append(ret, symbol{argb: pix[i]})
+			length = 1
+		} else {
+			ret = append(ret, symbol{length: length, distance: distance})
+		}
+		for end := i + length; i < end; i++ {
+			if i+1 < len(pix) {
+				last[hash(i)] = int32(i + 1)
+			}
+		}
+	}
+	return ret
+}
+
+// writeImage writes an entropy coded image, with a single group of prefix codes and no color cache.
+func writeImage(bw *bitWriter, symbols []symbol, topLevel bool) {
+	bw.write(0, 1) // no color cache
+	if topLevel {
+		bw.write(0, 1) // no meta prefix codes
+	}
+
+	green, red, blue, alpha, dist := make([]uint32, nLiterals+nLengthCodes), make([]uint32, nLiterals),
+		make([]uint32, nLiterals), make([]uint32, nLiterals), make([]uint32, nDistanceCodes)
+	for _, s := range symbols {
+		if s.length == 0 {
+			green[s.argb>>8&0xff]++
+			red[s.argb>>16&0xff]++
+			blue[s.argb&0xff]++
+			alpha[s.argb>>24]++
+			continue
+		}
+		l, _, _ := prefixEncode(s.length)
+		d, _, _ := prefixEncode(s.distance)
+		green[nLiterals+l]++
+		dist[d]++
+	}
+
+	var codes [5]prefixCode
+	for i, hist := range [][]uint32{green, red, blue, alpha, dist} {
+		codes[i] = writePrefixCode(bw, hist)
+	}
+	for _, s := range symbols {
+		if s.length == 0 {
+			codes[0].write(bw, int(s.argb>>8&0xff))
+			codes[1].write(bw, int(s.argb>>16&0xff))
+			codes[2].write(bw, int(s.argb&0xff))
+			codes[3].write(bw, int(s.argb>>24))
+			continue
+		}
+		l, n, extra := prefixEncode(s.length)
+		codes[0].write(bw, nLiterals+l)
+		bw.write(extra, n)
+		d, n, extra := prefixEncode(s.distance)
+		codes[4].write(bw, d)
+		bw.write(extra, n)
+	}
+}
+
+// prefixEncode splits a length or distance code into its prefix and the extra bits that follow it.
+func prefixEncode(v int) (int, uint, uint32) {
+	v--
+	if v < 4 {
+		return v, 0, 0
+	}
+	high := bits.Len(uint(v)) - 1
+	n := uint(high - 1)
+	return 2*high + (v>>n)&1, n, uint32(v) & (1<<n - 1)
+}
+
+// writeRIFF wraps VP8L data in a RIFF container.
+func writeRIFF(w io.Writer, data []byte) error {
+	pad := len(data) & 1
+	header := make([]byte, 20)
+	copy(header, "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(12+len(data)+pad))
+	copy(header[8:], "WEBPVP8L")
+	binary.LittleEndian.PutUint32(header[16:], uint32(len(data)))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if pad == 1 {
+		_, err := w.Write([]byte{0})
+		return err
+	}
+	return nil
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func absInt(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,286 @@
+package webp
+
+import (
+	"math/bits"
+	"sort"
+)
+
+const (
+	// maxCodeLength is the longest prefix code VP8L allows.
+	maxCodeLength = 15
+	// maxCodeLengthCodeLength is the longest code in the prefix code describing the lengths of another.
+	maxCodeLengthCodeLength = 7
+)
+
+// codeLengthOrder is the order the lengths of the code length code are written in.
+var codeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// bitWriter writes bits least significant first, as VP8L reads them.
+type bitWriter struct {
+	buf   []byte
+	bits  uint64
+	nBits uint
+}
+
+// write writes the low n bits of v, n is at most 32.
+func (bw *bitWriter) write(v uint32, n uint) {
+	bw.bits |= uint64(v) << bw.nBits
+	bw.nBits += n
+	for bw.nBits >= 8 {
+		bw.buf = append(bw.buf, byte(bw.bits))
+		bw.bits >>= 8
+		bw.nBits -= 8
+	}
+}
+
+// bytes flushes any partial byte and returns everything written.
+func (bw *bitWriter) bytes() []byte {
+	if bw.nBits > 0 {
+		bw.buf = append(bw.buf, byte(bw.bits))
+		bw.bits, bw.nBits = 0, 0
+	}
+	return bw.buf
+}
+
+// prefixCode is a canonical prefix code, the codes are bit reversed so they can be written least significant first.
+type prefixCode struct {
+	lengths []uint8
+	codes   []uint32
+}
+
+// newPrefixCode builds the canonical code with lengths. A code with a single symbol is described with length 1 but
+// takes no bits to write.
+func newPrefixCode(lengths []uint8) prefixCode {
+	c := prefixCode{lengths: make([]uint8, len(lengths)), codes: make([]uint32, len(lengths))}
+	var count [maxCodeLength + 1]uint32
+	used := 0
+	for _, l := range lengths {
+		if l > 0 {
+			count[l]++
+			used++
+		}
+	}
+	if used < 2 {
+		return c
+	}
+
+	var next [maxCodeLength + 1]uint32
+	code := uint32(0)
+	for l := 1; l <= maxCodeLength; l++ {
+		code = (code + count[l-1]) << 1
+		next[l] = code
+	}
+	for s, l := range lengths {
+		if l > 0 {
+			c.lengths[s] = l
+			c.codes[s] = bits.Reverse32(next[l]) >> (32 - uint(l))
+			next[l]++
+		}
+	}
+	return c
+}
+
+// write writes symbol s.
+func (c prefixCode) write(bw *bitWriter, s int) {
+	bw.write(c.codes[s], uint(c.lengths[s]))
+}
+
+// writePrefixCode describes a code for symbols as often as hist, returning it. Codes of up to two small symbols use
+// the simple form, others have their lengths written with a code of their own.
+func writePrefixCode(bw *bitWriter, hist []uint32) prefixCode {
+	var used []int
+	for s, n := range hist {
+		if n > 0 {
+			used = append(used, s)
+		}
+	}
+
+	if len(used) <= 2 && (len(used) == 0 || used[len(used)-1] < 256) {
+		if len(used) == 0 {
+			// nothing is written with it, but the code has to have a symbol
+			used = []int{0}
+		}
+		bw.write(1, 1)
+		bw.write(uint32(len(used)-1), 1)
+		if used[0] < 2 {
+			bw.write(0, 1)
+			bw.write(uint32(used[0]), 1)
+		} else {
+			bw.write(1, 1)
+			bw.write(uint32(used[0]), 8)
+		}
+		lengths := make([]uint8, len(hist))
+		if len(used) == 2 {
+			bw.write(uint32(used[1]), 8)
+			lengths[used[0]], lengths[used[1]] = 1, 1
+		}
+		return newPrefixCode(lengths)
+	}
+
+	lengths := codeLengths(hist, maxCodeLength)
+	bw.write(0, 1)
+	writeCodeLengths(bw, lengths)
+	return newPrefixCode(lengths)
+}
+
+// codeLength is a symbol of the code length code, with its extra bits for repeats.
+type codeLength struct {
+	symbol int
+	extra  uint32
+}
+
+// writeCodeLengths writes the lengths of a code, run length encoded and prefix coded.
+func writeCodeLengths(bw *bitWriter, lengths []uint8) {
+	symbols := runLengths(lengths)
+	hist := make([]uint32, len(codeLengthOrder))
+	for _, s := range symbols {
+		hist[s.symbol]++
+	}
+	clLengths := codeLengths(hist, maxCodeLengthCodeLength)
+
+	n := len(codeLengthOrder)
+	for n > 4 && clLengths[codeLengthOrder[n-1]] == 0 {
+		n--
+	}
+	bw.write(uint32(n-4), 4)
+	for _, s := range codeLengthOrder[:n] {
+		bw.write(uint32(clLengths[s]), 3)
+	}
+	// lengths are given for the whole alphabet
+	bw.write(0, 1)
+
+	code := newPrefixCode(clLengths)
+	for _, s := range symbols {
+		code.write(bw, s.symbol)
+		switch s.symbol {
+		case 16:
+			bw.write(s.extra, 2)
+		case 17:
+			bw.write(s.extra, 3)
+		case 18:
+			bw.write(s.extra, 7)
+		}
+	}
+}
+
+// runLengths run length encodes code lengths, 16 repeats the last length 3 to 6 times and 17 and 18 give 3 to 10 and
+// 11 to 138 zeros.
+func runLengths(lengths []uint8) []codeLength {
+	var ret []codeLength
+	for i := 0; i < len(lengths); {
+		l := lengths[i]
+		run := 1
+		for i+run < len(lengths) && lengths[i+run] == l {
+			run++
+		}
+		i += run
+
+		if l == 0 {
+			for run >= 11 {
+				n := minInt(run, 138)
+				ret = append(ret, codeLength{symbol: 18, extra: uint32(n - 11)})
+				run -= n
+			}
+			if run >= 3 {
+				ret = append(ret, codeLength{symbol: 17, extra: uint32(run - 3)})
+				run = 0
+			}
+		} else {
+			ret = append(ret, codeLength{symbol: int(l)})
+			run--
+			for run >= 3 {
+				n := minInt(run, 6)
+				ret = append(ret, codeLength{symbol: 16, extra: uint32(n - 3)})
+				run -= n
+			}
+		}
+		for ; run > 0; run-- {
+			ret = append(ret, codeLength{symbol: int(l)})
+		}
+	}
+	return ret
+}
+
+// codeLengths gives the lengths of a prefix code for symbols as often as hist, none longer than maxLength. Counts are
+// flattened until the Huffman code fits.
+func codeLengths(hist []uint32, maxLength int) []uint8 {
+	lengths := make([]uint8, len(hist))
+	var used []int
+	for s, n := range hist {
+		if n > 0 {
+			used = append(used, s)
+		}
+	}
+	switch len(used) {
+	case 0:
+		return lengths
+	case 1:
+		lengths[used[0]] = 1
+		return lengths
+	}
+
+	for shift := uint(0); ; shift++ {
+		if huffman(hist, used, shift, lengths) <= maxLength {
+			return lengths
+		}
+	}
+}
+
+// huffman sets the Huffman code lengths of used, weighing them by their counts in hist shifted down by shift, and
+// returns the longest.
+func huffman(hist []uint32, used []int, shift uint, lengths []uint8) int {
+	weight := func(s int) uint64 {
+		if w := uint64(hist[s] >> shift); w > 0 {
+			return w
+		}
+		return 1
+	}
+	order := append([]int(nil), used...)
+	sort.SliceStable(order, func(i, j int) bool { return weight(order[i]) < weight(order[j]) })
+
+	type node struct {
+		weight uint64
+		parent int
+	}
+	nodes := make([]node, len(order), 2*len(order)-1)
+	for i, s := range order {
+		nodes[i].weight = weight(s)
+	}
+	// leaves are sorted and joined nodes come out in order, so the lightest is at the head of one or the other
+	leaf, joined := 0, len(order)
+	lightest := func() int {
+		if leaf < len(order) && (joined == len(nodes) || nodes[leaf].weight <= nodes[joined].weight) {
+			leaf++
+			return leaf - 1
+		}
+		joined++
+		return joined - 1
+	}
+	for len(nodes) < cap(nodes) {
+		a, b := lightest(), lightest()
+		nodes = append(nodes, node{weight: nodes[a].weight + nodes[b].weight})
+		nodes[a].parent, nodes[b].parent = len(nodes)-1, len(nodes)-1
+	}
+
+	depth := make([]int, len(nodes))
+	for i := len(nodes) - 2; i >= 0; i-- {
+		depth[i] = depth[nodes[i].parent] + 1
+	}
+	longest := 0
+	for i, s := range order {
+		if depth[i] > longest {
+			longest = depth[i]
+		}
+		if depth[i] <= maxCodeLength {
+			lengths[s] = uint8(depth[i])
+		}
+	}
+	return longest
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,33 @@
+package webp
+
+import (
+	"github.com/asatisomnath/ProgImage/Service"
+	"image"
+	"io"
+
+	primage "github.com/asatisomnath/ProgImage/Convertors"
+	xwebp "golang.org/x/image/webp"
+)
+
+func init() {
+	Service.RegisterFormat(Service.Format{
+		Name:         "webp",
+		Ext:          "webp",
+		ContentType:  "image/webp",
+		Magic:        []string{"RIFF????WEBPVP8"},
+		Decode:       xwebp.Decode,
+		DecodeConfig: xwebp.DecodeConfig,
+		Converter:    Converter,
+	})
+}
+
+// Transformer implements ProgImage.ImageTypeTransformer to convert a ProgImage.Image to webp format.
+var Converter = primage.Converter{
+	Name:    "webp",
+	Encoder: DefaultWebpEncode,
+}
+
+// DefaultWebpEncode performs lossless webp encoding, quality and compression don't apply.
+func DefaultWebpEncode(w io.Writer, m image.Image, o primage.Options) error {
+	return Encode(w, m)
+}
@@ -0,0 +1,134 @@
+package webp_test
+
+import (
+	"bytes"
+	"github.com/asatisomnath/ProgImage/Service"
+	"image"
+	"image/color"
+	"image/draw"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"testing"
+
+	_ "github.com/asatisomnath/ProgImage/Convertors/formats" // register formats, do not remove
+	"github.com/asatisomnath/ProgImage/Convertors/webp"
+)
+
+var fileTests = []struct {
+	Name        string
+	Path        string
+	ContentType string
+}{
+	{Name: "png", Path: "../../testimages/test.png", ContentType: "image/png"},
+	{Name: "gif", Path: "../../testimages/test.gif", ContentType: "image/gif"},
+	{Name: "jpg", Path: "../../testimages/test.jpg", ContentType: "image/jpeg"},
+	{Name: "webp", Path: "../../testimages/test.webp", ContentType: "image/webp"},
+}
+
+func TestTransformWEBP(t *testing.T) {
+	for _, item := range fileTests {
+		t.Run(item.Name, func(t *testing.T) {
+			fp, err := os.Open(item.Path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer fp.Close()
+
+			img := Service.Image{
+				ID:          item.Name,
+				ContentType: item.ContentType,
+				Data:        fp,
+			}
+
+			errCh := make(chan error, 1)
+			imgOut, err := webp.Converter.Convert(img, errCh)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the decoder stops short of the padding at the end, read it all so the encoder can finish
+			d, err := ioutil.ReadAll(imgOut.Data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, typ, err := image.Decode(bytes.NewReader(d))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if typ != "webp" {
+				t.Errorf("expected type of converted Convertors to be webp, got %s", typ)
+			}
+			if err := <-errCh; err != nil {
+				t.Errorf("got error converting Convertors %s", err)
+			}
+		})
+	}
+}
+
+// TestEncode_Lossless checks images decode to exactly the pixels they were encoded from.
+func TestEncode_Lossless(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	noise := image.NewNRGBA(image.Rect(0, 0, 67, 35))
+	rnd.Read(noise.Pix)
+
+	gradient := image.NewNRGBA(image.Rect(0, 0, 300, 200))
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 300; x++ {
+			gradient.SetNRGBA(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: uint8(x + y), A: uint8(255 - y)})
+		}
+	}
+	// flat areas and repeats are coded as backward references
+	tiled := image.NewNRGBA(image.Rect(0, 0, 500, 120))
+	for x := 0; x < 500; x += 50 {
+		draw.Draw(tiled, image.Rect(x, 0, x+50, 120), noise, image.Point{}, draw.Src)
+	}
+	flat := image.NewNRGBA(image.Rect(0, 0, 2000, 3))
+	draw.Draw(flat, flat.Bounds(), image.NewUniform(color.NRGBA{R: 10, G: 20, B: 30, A: 255}), image.Point{}, draw.Src)
+
+	tests := []struct {
+		Name string
+		Img  *image.NRGBA
+	}{
+		{Name: "pixel", Img: noise.SubImage(image.Rect(5, 5, 6, 6)).(*image.NRGBA)},
+		{Name: "noise", Img: noise},
+		{Name: "sub image", Img: noise.SubImage(image.Rect(3, 4, 40, 30)).(*image.NRGBA)},
+		{Name: "gradient", Img: gradient},
+		{Name: "tiled", Img: tiled},
+		{Name: "flat", Img: flat},
+	}
+	for _, item := range tests {
+		t.Run(item.Name, func(t *testing.T) {
+			buf := bytes.Buffer{}
+			if err := webp.Encode(&buf, item.Img); err != nil {
+				t.Fatal(err)
+			}
+			out, typ, err := image.Decode(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if typ != "webp" {
+				t.Fatalf("expected webp, got %s", typ)
+			}
+			b := item.Img.Bounds()
+			if out.Bounds().Size() != b.Size() {
+				t.Fatalf("expected %v, got %v", b.Size(), out.Bounds().Size())
+			}
+			for y := 0; y < b.Dy(); y++ {
+				for x := 0; x < b.Dx(); x++ {
+					want := item.Img.NRGBAAt(b.Min.X+x, b.Min.Y+y)
+					if got := color.NRGBAModel.Convert(out.At(x, y)); got != want {
+						t.Fatalf("expected %v at %d,%d, got %v", want, x, y, got)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestEncode_TooLarge(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, webp.MaxDimension+1, 1))
+	if err := webp.Encode(&bytes.Buffer{}, img); err == nil {
+		t.Error("expected an error encoding an image wider than webp.MaxDimension")
+	}
+}
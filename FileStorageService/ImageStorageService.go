@@ -0,0 +1,447 @@
+package FileStorageService
+
+import (
+	"encoding/json"
+	"github.com/asatisomnath/ProgImage/Service"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// metaExt is appended to an image id to name the file holding the image's metadata, a JSON encoded
+// Service.ImageMeta.
+const metaExt = ".meta"
+
+// variantDir is the directory under the root holding a directory of variants for each image.
+const variantDir = "variants"
+
+var _ Service.ImageService = &ImageService{}
+var _ Service.VariantStore = &ImageService{}
+
+// hashDir is the directory under the root holding the content addressed index, each file is named after the sha256 of
+// an image and holds its id.
+const hashDir = "hashes"
+
+// ImageService implements ProgImage.ImageService by storing data on the local filesystem. When ContentAddressed is set
+// uploads of data that's already stored reuse the existing id.
+type ImageService struct {
+	Root             string
+	ContentAddressed bool
+	UUID             func() uuid.UUID
+
+	mu sync.Mutex // serialises content addressed uploads
+}
+
+// NewImageService provides an initialised ImageService.
+func NewImageService(root string, uuid func() uuid.UUID) *ImageService {
+	return &ImageService{
+		Root: root,
+		UUID: uuid,
+	}
+}
+
+// EnsureRoot creates the root directory if it doesn't already exist.
+func (is *ImageService) EnsureRoot() error {
+	if err := os.MkdirAll(filepath.Join(is.Root, hashDir), 0755); err != nil {
+		return errors.Wrap(err, "error creating root directory")
+	}
+	return nil
+}
+
+// lookupHash returns the id of the stored image with the given sha256, or an empty string if there isn't one.
+func (is *ImageService) lookupHash(sum string) (string, error) {
+	b, err := ioutil.ReadFile(filepath.Join(is.Root, hashDir, sum))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", errors.Wrap(err, "error reading hash index")
+	}
+
+	// the index can outlive the image
+	ID := string(b)
+	p, err := is.path(ID)
+	if err != nil {
+		return "", nil
+	}
+	if _, err := os.Stat(p); err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", errors.Wrapf(err, "error checking image %s", ID)
+	}
+	return ID, nil
+}
+
+// path returns the location of the image with the given id, ids that could escape the root directory are treated as
+// not found.
+func (is *ImageService) path(ID string) (string, error) {
+	if !validName(ID) {
+		return "", Service.ErrImageNotFound
+	}
+	return filepath.Join(is.Root, ID), nil
+}
+
+// variantPath returns the location of the variant of the image with the given id.
+func (is *ImageService) variantPath(ID, key string) (string, error) {
+	if !validName(ID) || !validName(key) {
+		return "", Service.ErrImageNotFound
+	}
+	return filepath.Join(is.Root, variantDir, ID, key), nil
+}
+
+// validName reports whether name can be used as a file name without escaping its directory or clashing with
+// temporary and metadata files.
+func validName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, `/\`) && !strings.HasPrefix(name, ".") && !strings.HasSuffix(name, metaExt)
+}
+
+// Get retrieves the Image with the given id.
+func (is *ImageService) Get(ID string) (Service.Image, error) {
+	ret := Service.Image{}
+	p, err := is.path(ID)
+	if err != nil {
+		return ret, err
+	}
+
+	m, err := is.readMetadata(ID)
+	if err != nil {
+		return ret, err
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ret, Service.ErrImageNotFound
+		}
+		return ret, errors.Wrapf(err, "error opening image %s", ID)
+	}
+
+	ret.ID = ID
+	ret.Data = f
+	ret.ContentType = m.ContentType
+	return ret, nil
+}
+
+// Upload validates data is an image, persists the image and returns the id. The image is written to a temporary file
+// while it's validated and only moved into place once it's known to be valid. In content addressed mode the id of an
+// identical image that's already stored is returned instead.
+func (is *ImageService) Upload(rawImg io.Reader) (Service.UploadResult, error) {
+	ret := Service.UploadResult{}
+
+	// limit max size
+	lr := io.LimitReader(rawImg, Service.MaxImageBytes)
+
+	contentType, rdr, err := Service.SniffContentType(lr)
+	if err != nil {
+		if Service.IsUnsupported(err) {
+			return ret, err
+		}
+		return ret, errors.Wrap(err, "unable to read image data")
+	}
+
+	tmp, err := ioutil.TempFile(is.Root, ".upload-")
+	if err != nil {
+		return ret, errors.Wrap(err, "error creating temporary file")
+	}
+	defer func() {
+		// a no-op once the file has been renamed
+		if err := os.Remove(tmp.Name()); err != nil && !os.IsNotExist(err) {
+			log.Printf("error removing temporary file %s, %s", tmp.Name(), err)
+		}
+	}()
+
+	meta, validateErr := Service.ValidateImage(rdr, tmp)
+	if err := tmp.Close(); err != nil && validateErr == nil {
+		return ret, errors.Wrap(err, "error writing image")
+	}
+	if validateErr != nil {
+		if Service.IsRejected(validateErr) {
+			return ret, validateErr
+		}
+		return ret, errors.Wrap(validateErr, "error writing image")
+	}
+
+	if is.ContentAddressed {
+		// hold the lock until the index is updated so identical concurrent uploads are stored once
+		is.mu.Lock()
+		defer is.mu.Unlock()
+
+		ID, err := is.lookupHash(meta.SHA256)
+		if err != nil {
+			return ret, err
+		}
+		if ID != "" {
+			ret.ID = ID
+			ret.Deduplicated = true
+			return ret, nil
+		}
+	}
+
+	ID := is.UUID().String()
+	p := filepath.Join(is.Root, ID)
+	meta.ID = ID
+	meta.ContentType = contentType
+	meta.Uploaded = time.Now().UTC()
+
+	// metadata goes first, Get only finds an image once its data is in place
+	b, err := json.Marshal(meta)
+	if err != nil {
+		return ret, errors.Wrap(err, "error encoding metadata")
+	}
+	if err := writeFile(p+metaExt, b); err != nil {
+		return ret, err
+	}
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		return ret, errors.Wrap(err, "error moving image into place")
+	}
+
+	if is.ContentAddressed {
+		if err := writeFile(filepath.Join(is.Root, hashDir, meta.SHA256), []byte(ID)); err != nil {
+			// the image is stored, it just won't be deduplicated against
+			log.Printf("error indexing image %s, %s", ID, err)
+		}
+	}
+
+	ret.ID = ID
+	return ret, nil
+}
+
+// Delete removes the Image with the given id.
+func (is *ImageService) Delete(ID string) error {
+	p, err := is.path(ID)
+	if err != nil {
+		return err
+	}
+
+	m, err := is.readMetadata(ID)
+	if err != nil {
+		return err
+	}
+
+	// data goes first, Get doesn't find an image without it
+	if err := os.Remove(p); err != nil {
+		if os.IsNotExist(err) {
+			return Service.ErrImageNotFound
+		}
+		return errors.Wrapf(err, "error deleting image %s", ID)
+	}
+	if err := os.Remove(p + metaExt); err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "error deleting metadata %s", ID)
+	}
+	if err := is.DeleteVariants(ID); err != nil {
+		return err
+	}
+
+	if m.SHA256 != "" {
+		is.mu.Lock()
+		defer is.mu.Unlock()
+		hp := filepath.Join(is.Root, hashDir, m.SHA256)
+		if b, err := ioutil.ReadFile(hp); err == nil && string(b) == ID {
+			if err := os.Remove(hp); err != nil && !os.IsNotExist(err) {
+				return errors.Wrapf(err, "error deleting hash index %s", ID)
+			}
+		}
+	}
+	return nil
+}
+
+// GetVariant retrieves the variant of the Image with the given id.
+func (is *ImageService) GetVariant(ID, key string) (Service.Image, error) {
+	ret := Service.Image{}
+	p, err := is.variantPath(ID, key)
+	if err != nil {
+		return ret, err
+	}
+
+	var m Service.ImageMeta
+	b, err := ioutil.ReadFile(p + metaExt)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ret, Service.ErrImageNotFound
+		}
+		return ret, errors.Wrapf(err, "error reading variant metadata %s %s", ID, key)
+	}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return ret, errors.Wrapf(err, "error decoding variant metadata %s %s", ID, key)
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ret, Service.ErrImageNotFound
+		}
+		return ret, errors.Wrapf(err, "error opening variant %s %s", ID, key)
+	}
+
+	ret.ID = ID
+	ret.Data = f
+	ret.ContentType = m.ContentType
+	return ret, nil
+}
+
+// PutVariant stores a variant of the Image with the given id.
+func (is *ImageService) PutVariant(ID, key string, img Service.Image) error {
+	p, err := is.variantPath(ID, key)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(is.Root, ID)); err != nil {
+		if os.IsNotExist(err) {
+			return Service.ErrImageNotFound
+		}
+		return errors.Wrapf(err, "error checking image %s", ID)
+	}
+
+	dir := filepath.Dir(p)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errors.Wrapf(err, "error creating variant directory %s", ID)
+	}
+
+	tmp, err := ioutil.TempFile(dir, ".variant-")
+	if err != nil {
+		return errors.Wrap(err, "error creating temporary file")
+	}
+	defer os.Remove(tmp.Name()) // nolint: gas,errcheck
+
+	_, copyErr := io.Copy(tmp, img.Data)
+	if err := tmp.Close(); err != nil && copyErr == nil {
+		copyErr = err
+	}
+	if copyErr != nil {
+		return errors.Wrapf(copyErr, "error writing variant %s %s", ID, key)
+	}
+
+	b, err := json.Marshal(Service.ImageMeta{ID: ID, ContentType: img.ContentType})
+	if err != nil {
+		return errors.Wrap(err, "error encoding variant metadata")
+	}
+	if err := writeFile(p+metaExt, b); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		return errors.Wrapf(err, "error moving variant %s %s into place", ID, key)
+	}
+
+	// the original may have been deleted, along with its variants, since it was checked
+	if _, err := os.Stat(filepath.Join(is.Root, ID)); os.IsNotExist(err) {
+		if err := is.DeleteVariants(ID); err != nil {
+			return err
+		}
+		return Service.ErrImageNotFound
+	}
+	return nil
+}
+
+// DeleteVariants removes all variants of the Image with the given id.
+func (is *ImageService) DeleteVariants(ID string) error {
+	if !validName(ID) {
+		return nil
+	}
+	if err := os.RemoveAll(filepath.Join(is.Root, variantDir, ID)); err != nil {
+		return errors.Wrapf(err, "error deleting variants %s", ID)
+	}
+	return nil
+}
+
+// List returns a page of stored images ordered by id, the cursor holds the last id listed.
+func (is *ImageService) List(cursor string, limit int) (Service.ImageList, error) {
+	ret := Service.ImageList{Images: []Service.ImageInfo{}}
+	after, err := Service.DecodeCursor(cursor)
+	if err != nil {
+		return ret, err
+	}
+	limit = Service.ListLimit(limit)
+
+	// sorted by name
+	files, err := ioutil.ReadDir(is.Root)
+	if err != nil {
+		return ret, errors.Wrap(err, "error listing images")
+	}
+
+	for _, fi := range files {
+		ID := fi.Name()
+		if fi.IsDir() || ID <= after {
+			continue
+		}
+		if _, err := is.path(ID); err != nil {
+			// temporary or metadata file
+			continue
+		}
+
+		m, err := is.readMetadata(ID)
+		if err != nil {
+			if err == Service.ErrImageNotFound {
+				continue
+			}
+			return ret, err
+		}
+
+		// only once there's another image to list, so the last page doesn't lead to an empty one
+		if len(ret.Images) == limit {
+			ret.Cursor = Service.EncodeCursor(ret.Images[limit-1].ID)
+			break
+		}
+		ret.Images = append(ret.Images, Service.ImageInfo{
+			ID:          ID,
+			Size:        fi.Size(),
+			ContentType: m.ContentType,
+			Uploaded:    m.Uploaded,
+		})
+	}
+	return ret, nil
+}
+
+// Meta retrieves the metadata of the Image with the given id.
+func (is *ImageService) Meta(ID string) (Service.ImageMeta, error) {
+	if _, err := is.path(ID); err != nil {
+		return Service.ImageMeta{}, err
+	}
+	return is.readMetadata(ID)
+}
+
+// readMetadata reads the metadata stored alongside the image with the given id.
+func (is *ImageService) readMetadata(ID string) (Service.ImageMeta, error) {
+	var m Service.ImageMeta
+	b, err := ioutil.ReadFile(filepath.Join(is.Root, ID+metaExt))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return m, Service.ErrImageNotFound
+		}
+		return m, errors.Wrapf(err, "error reading metadata %s", ID)
+	}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return m, errors.Wrapf(err, "error decoding metadata %s", ID)
+	}
+	return m, nil
+}
+
+// writeFile atomically writes data to the named file by writing to a temporary file and renaming it.
+func writeFile(name string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(name), ".tmp-")
+	if err != nil {
+		return errors.Wrap(err, "error creating temporary file")
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()           // nolint: gas,errcheck
+		os.Remove(tmp.Name()) // nolint: gas,errcheck
+		return errors.Wrapf(err, "error writing %s", name)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name()) // nolint: gas,errcheck
+		return errors.Wrapf(err, "error writing %s", name)
+	}
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		os.Remove(tmp.Name()) // nolint: gas,errcheck
+		return errors.Wrapf(err, "error moving %s into place", name)
+	}
+	return nil
+}
@@ -0,0 +1,396 @@
+package FileStorageService_test
+
+import (
+	"bytes"
+	"github.com/asatisomnath/ProgImage/Service"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/asatisomnath/ProgImage/Convertors/formats" // register formats, do not remove
+	"github.com/asatisomnath/ProgImage/FileStorageService"
+	"github.com/google/uuid"
+)
+
+// setup creates an ImageService rooted in a new temporary directory.
+func setup(t *testing.T, uf func() uuid.UUID) (*FileStorageService.ImageService, func()) {
+	dir, err := ioutil.TempDir("", "progimage")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	is := FileStorageService.NewImageService(filepath.Join(dir, "images"), uf)
+	if err := is.EnsureRoot(); err != nil {
+		t.Fatal(err)
+	}
+	return is, func() {
+		os.RemoveAll(dir)
+	}
+}
+
+// countFiles counts the files in dir, ignoring directories.
+func countFiles(t *testing.T, dir string) int {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for _, fi := range files {
+		if !fi.IsDir() {
+			n++
+		}
+	}
+	return n
+}
+
+var fileTests = []struct {
+	Name        string
+	Path        string
+	ContentType string
+}{
+	{Name: "png", Path: "../testimages/test.png", ContentType: "image/png"},
+	{Name: "gif", Path: "../testimages/test.gif", ContentType: "image/gif"},
+	{Name: "jpg", Path: "../testimages/test.jpg", ContentType: "image/jpeg"},
+}
+
+func TestImageService_StoreGetImage(t *testing.T) {
+	for _, item := range fileTests {
+		t.Run(item.Name, func(t *testing.T) {
+			uid := uuid.New()
+			is, teardown := setup(t, func() uuid.UUID { return uid })
+			defer teardown()
+
+			d, err := ioutil.ReadFile(item.Path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := is.Upload(bytes.NewReader(d))
+			if err != nil {
+				t.Fatal(err)
+			}
+			id := res.ID
+			if id != uid.String() {
+				t.Errorf("expected id to be %s, got %s", uid.String(), id)
+			}
+
+			img, err := is.Get(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer img.Data.(*os.File).Close()
+
+			if img.ID != id {
+				t.Errorf("expected image id to be '%s', got '%s'", id, img.ID)
+			}
+			if img.ContentType != item.ContentType {
+				t.Errorf("expected image content type to be '%s', got '%s'", item.ContentType, img.ContentType)
+			}
+
+			retrieved, err := ioutil.ReadAll(img.Data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(d, retrieved) {
+				t.Error("expected stored data to be equal to initial data from file, data not equal")
+			}
+		})
+	}
+}
+
+func TestImageService_StoreInvalid(t *testing.T) {
+	is, teardown := setup(t, uuid.New)
+	defer teardown()
+
+	if _, err := is.Upload(bytes.NewReader([]byte{})); err != Service.ErrNotAnImage {
+		t.Errorf("expected ProgImage.ErrNotAnImage, got %s", err)
+	}
+
+	// a png header followed by garbage passes sniffing but fails to decode
+	d := append([]byte("\x89PNG\x0D\x0A\x1A\x0A"), bytes.Repeat([]byte{0xff}, 64)...)
+	if _, err := is.Upload(bytes.NewReader(d)); err != Service.ErrUnrecognisedImageType {
+		t.Errorf("expected ProgImage.ErrUnrecognisedImageType, got %s", err)
+	}
+
+	// nothing should be left behind
+	if n := countFiles(t, is.Root); n != 0 {
+		t.Errorf("expected root to be empty, found %d files", n)
+	}
+}
+
+func TestImageService_GetNotExists(t *testing.T) {
+	is, teardown := setup(t, uuid.New)
+	defer teardown()
+
+	for _, ID := range []string{"foo", "", "../images", ".upload-1", "foo.meta"} {
+		if _, err := is.Get(ID); err != Service.ErrImageNotFound {
+			t.Errorf("expected ProgImage.ErrImageNotFound for %q, got %s", ID, err)
+		}
+	}
+}
+
+func TestImageService_Delete(t *testing.T) {
+	is, teardown := setup(t, uuid.New)
+	defer teardown()
+
+	d, err := ioutil.ReadFile("../testimages/test.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := is.Upload(bytes.NewReader(d))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := res.ID
+
+	if err := is.Delete(id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := is.Get(id); err != Service.ErrImageNotFound {
+		t.Errorf("expected ProgImage.ErrImageNotFound, got %s", err)
+	}
+	if err := is.Delete(id); err != Service.ErrImageNotFound {
+		t.Errorf("expected ProgImage.ErrImageNotFound, got %s", err)
+	}
+
+	if n := countFiles(t, is.Root); n != 0 {
+		t.Errorf("expected root to be empty, found %d files", n)
+	}
+}
+
+func TestImageService_List(t *testing.T) {
+	is, teardown := setup(t, uuid.New)
+	defer teardown()
+
+	d, err := ioutil.ReadFile("../testimages/test.gif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := map[string]bool{}
+	for i := 0; i < 5; i++ {
+		res, err := is.Upload(bytes.NewReader(d))
+		if err != nil {
+			t.Fatal(err)
+		}
+		id := res.ID
+		stored[id] = true
+	}
+
+	// page through 2 at a time
+	listed := map[string]bool{}
+	cursor := ""
+	for pages := 1; ; pages++ {
+		list, err := is.List(cursor, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list.Images) > 2 {
+			t.Errorf("expected at most 2 images, got %d", len(list.Images))
+		}
+		for _, info := range list.Images {
+			if info.Size != int64(len(d)) {
+				t.Errorf("expected size to be %d, got %d", len(d), info.Size)
+			}
+			if info.ContentType != "image/gif" {
+				t.Errorf("expected content type to be image/gif, got %s", info.ContentType)
+			}
+			if info.Uploaded.IsZero() {
+				t.Error("expected uploaded time to be set")
+			}
+			listed[info.ID] = true
+		}
+		if list.Cursor == "" {
+			if pages != 3 {
+				t.Errorf("expected 3 pages, got %d", pages)
+			}
+			break
+		}
+		cursor = list.Cursor
+	}
+
+	if len(listed) != len(stored) {
+		t.Errorf("expected %d images listed, got %d", len(stored), len(listed))
+	}
+	for id := range stored {
+		if !listed[id] {
+			t.Errorf("expected %s to be listed", id)
+		}
+	}
+
+	if _, err := is.List("not a cursor!", 0); err != Service.ErrInvalidCursor {
+		t.Errorf("expected ProgImage.ErrInvalidCursor, got %v", err)
+	}
+}
+
+// TestImageService_ListLastPage checks a full last page has no cursor when what follows it can't be listed.
+func TestImageService_ListLastPage(t *testing.T) {
+	is, teardown := setup(t, uuid.New)
+	defer teardown()
+
+	d, err := ioutil.ReadFile("../testimages/test.gif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := is.Upload(bytes.NewReader(d)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// sorts after the uuids but has no metadata, eg an upload that was interrupted
+	if err := ioutil.WriteFile(filepath.Join(is.Root, "zzzz"), d, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := is.List("", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Images) != 2 || list.Cursor != "" {
+		t.Errorf("expected 2 images and no cursor, got %d and %q", len(list.Images), list.Cursor)
+	}
+}
+
+func TestImageService_Meta(t *testing.T) {
+	is, teardown := setup(t, uuid.New)
+	defer teardown()
+
+	d, err := ioutil.ReadFile("../testimages/test.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := is.Upload(bytes.NewReader(d))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := res.ID
+
+	meta, err := is.Meta(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.ID != id || meta.ContentType != "image/jpeg" || meta.Format != "jpeg" || meta.ColorModel != "ycbcr" {
+		t.Errorf("unexpected meta %+v", meta)
+	}
+	if meta.Width != 1920 || meta.Height != 1440 {
+		t.Errorf("expected 1920x1440, got %dx%d", meta.Width, meta.Height)
+	}
+	if meta.Size != int64(len(d)) {
+		t.Errorf("expected size to be %d, got %d", len(d), meta.Size)
+	}
+	if meta.SHA256 != "909c9f3bc90930e1f799d6378e3e768354aed526d47ca14408bbf216d7b4bc56" {
+		t.Errorf("unexpected sha256 %s", meta.SHA256)
+	}
+	if meta.Uploaded.IsZero() {
+		t.Error("expected uploaded time to be set")
+	}
+
+	if _, err := is.Meta("foo"); err != Service.ErrImageNotFound {
+		t.Errorf("expected ProgImage.ErrImageNotFound, got %v", err)
+	}
+}
+
+func TestImageService_ContentAddressed(t *testing.T) {
+	is, teardown := setup(t, uuid.New)
+	defer teardown()
+	is.ContentAddressed = true
+
+	d, err := ioutil.ReadFile("../testimages/test.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := is.Upload(bytes.NewReader(d))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Deduplicated {
+		t.Error("expected first upload not to be deduplicated")
+	}
+
+	second, err := is.Upload(bytes.NewReader(d))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !second.Deduplicated || second.ID != first.ID {
+		t.Errorf("expected second upload to be deduplicated to %s, got %+v", first.ID, second)
+	}
+
+	// different data gets a new id
+	g, err := ioutil.ReadFile("../testimages/test.gif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := is.Upload(bytes.NewReader(g))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.Deduplicated || other.ID == first.ID {
+		t.Errorf("expected different data not to be deduplicated, got %+v", other)
+	}
+
+	// once deleted the data is stored again
+	if err := is.Delete(first.ID); err != nil {
+		t.Fatal(err)
+	}
+	third, err := is.Upload(bytes.NewReader(d))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third.Deduplicated || third.ID == first.ID {
+		t.Errorf("expected upload after delete not to be deduplicated, got %+v", third)
+	}
+}
+
+func TestImageService_Variants(t *testing.T) {
+	is, teardown := setup(t, uuid.New)
+	defer teardown()
+
+	d, err := ioutil.ReadFile("../testimages/test.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := is.Upload(bytes.NewReader(d))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := res.ID
+
+	if _, err := is.GetVariant(id, "gif"); err != Service.ErrImageNotFound {
+		t.Errorf("expected ProgImage.ErrImageNotFound, got %v", err)
+	}
+
+	variant := []byte("some converted data")
+	if err := is.PutVariant(id, "gif", Service.Image{ContentType: "image/gif", Data: bytes.NewReader(variant)}); err != nil {
+		t.Fatal(err)
+	}
+	v, err := is.GetVariant(id, "gif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.ContentType != "image/gif" {
+		t.Errorf("expected variant content type to be image/gif, got %s", v.ContentType)
+	}
+	got, err := ioutil.ReadAll(v.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, ok := v.Data.(io.Closer); ok {
+		c.Close()
+	}
+	if !bytes.Equal(variant, got) {
+		t.Errorf("expected variant data to be %q, got %q", variant, got)
+	}
+
+	// variants can't outlive their image
+	if err := is.PutVariant("foo", "gif", Service.Image{ContentType: "image/gif", Data: bytes.NewReader(variant)}); err != Service.ErrImageNotFound {
+		t.Errorf("expected ProgImage.ErrImageNotFound, got %v", err)
+	}
+	if err := is.Delete(id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := is.GetVariant(id, "gif"); err != Service.ErrImageNotFound {
+		t.Errorf("expected ProgImage.ErrImageNotFound after delete, got %v", err)
+	}
+}
@@ -0,0 +1,329 @@
+package MemoryStorageService
+
+import (
+	"bytes"
+	"container/list"
+	"github.com/asatisomnath/ProgImage/Service"
+	"io"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+var _ Service.ImageService = &ImageService{}
+var _ Service.VariantStore = &ImageService{}
+
+// ImageService implements ProgImage.ImageService by holding images in memory. When MaxBytes is set the least recently
+// used variants, then images along with their variants, are evicted to keep the total size of stored images and
+// variants within it. Variants can be made again so they never evict an image, one that doesn't fit isn't kept. When
+// ContentAddressed is set uploads of data that's already stored reuse the existing id.
+type ImageService struct {
+	MaxBytes         int64
+	ContentAddressed bool
+	UUID             func() uuid.UUID
+
+	mu       sync.Mutex
+	images   map[string]*list.Element
+	hashes   map[string]string // sha256 to id
+	lru      *list.List        // of *entry, front is most recently used
+	variants *list.List        // of *variant, front is most recently used
+	size     int64             // of images and variants
+}
+
+// entry is a stored image.
+type entry struct {
+	Meta     Service.ImageMeta
+	Data     []byte
+	Variants map[string]*list.Element // of variants by key
+}
+
+// variant is a stored converted copy of the image ID.
+type variant struct {
+	ID          string
+	Key         string
+	ContentType string
+	Data        []byte
+}
+
+// NewImageService provides an initialised ImageService, a maxBytes of 0 means unbounded.
+func NewImageService(maxBytes int64, uuid func() uuid.UUID) *ImageService {
+	return &ImageService{
+		MaxBytes: maxBytes,
+		UUID:     uuid,
+		images:   make(map[string]*list.Element),
+		hashes:   make(map[string]string),
+		lru:      list.New(),
+		variants: list.New(),
+	}
+}
+
+// Get retrieves the Image with the given id.
+func (is *ImageService) Get(ID string) (Service.Image, error) {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+
+	el, ok := is.images[ID]
+	if !ok {
+		return Service.Image{}, Service.ErrImageNotFound
+	}
+	is.lru.MoveToFront(el)
+
+	e := el.Value.(*entry)
+	return Service.Image{
+		ID:          e.Meta.ID,
+		ContentType: e.Meta.ContentType,
+		Data:        bytes.NewReader(e.Data),
+	}, nil
+}
+
+// Upload validates data is an image, stores it and returns the id. In content addressed mode the id of an identical
+// image that's already stored is returned instead.
+func (is *ImageService) Upload(rawImg io.Reader) (Service.UploadResult, error) {
+	ret := Service.UploadResult{}
+
+	// limit max size
+	lr := io.LimitReader(rawImg, Service.MaxImageBytes)
+
+	contentType, rdr, err := Service.SniffContentType(lr)
+	if err != nil {
+		if Service.IsUnsupported(err) {
+			return ret, err
+		}
+		return ret, errors.Wrap(err, "unable to read image data")
+	}
+
+	buf := new(bytes.Buffer)
+	meta, err := Service.ValidateImage(rdr, buf)
+	if err != nil {
+		if Service.IsRejected(err) {
+			return ret, err
+		}
+		return ret, errors.Wrap(err, "unable to read image data")
+	}
+
+	size := int64(buf.Len())
+	if is.MaxBytes > 0 && size > is.MaxBytes {
+		return ret, errors.Wrapf(
+			Service.ErrImageTooLarge,
+			"image of %d bytes exceeds store size of %d bytes",
+			size,
+			is.MaxBytes,
+		)
+	}
+
+	meta.ID = is.UUID().String()
+	meta.ContentType = contentType
+	meta.Uploaded = time.Now().UTC()
+	e := &entry{
+		Meta:     meta,
+		Data:     buf.Bytes(),
+		Variants: make(map[string]*list.Element),
+	}
+
+	is.mu.Lock()
+	defer is.mu.Unlock()
+
+	if is.ContentAddressed {
+		if ID, ok := is.hashes[meta.SHA256]; ok {
+			is.lru.MoveToFront(is.images[ID])
+			ret.ID = ID
+			ret.Deduplicated = true
+			return ret, nil
+		}
+	}
+
+	if el, ok := is.images[meta.ID]; ok {
+		is.remove(el)
+	}
+	is.images[meta.ID] = is.lru.PushFront(e)
+	if is.ContentAddressed {
+		is.hashes[meta.SHA256] = meta.ID
+	}
+	is.size += size
+	is.evict()
+
+	ret.ID = meta.ID
+	return ret, nil
+}
+
+// Delete removes the Image with the given id.
+func (is *ImageService) Delete(ID string) error {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+
+	el, ok := is.images[ID]
+	if !ok {
+		return Service.ErrImageNotFound
+	}
+	is.remove(el)
+	return nil
+}
+
+// List returns a page of stored images ordered by id, the cursor holds the last id listed.
+func (is *ImageService) List(cursor string, limit int) (Service.ImageList, error) {
+	ret := Service.ImageList{Images: []Service.ImageInfo{}}
+	after, err := Service.DecodeCursor(cursor)
+	if err != nil {
+		return ret, err
+	}
+	limit = Service.ListLimit(limit)
+
+	is.mu.Lock()
+	defer is.mu.Unlock()
+
+	IDs := make([]string, 0, len(is.images))
+	for ID := range is.images {
+		if ID > after {
+			IDs = append(IDs, ID)
+		}
+	}
+	sort.Strings(IDs)
+
+	if len(IDs) > limit {
+		IDs = IDs[:limit]
+		ret.Cursor = Service.EncodeCursor(IDs[limit-1])
+	}
+	for _, ID := range IDs {
+		e := is.images[ID].Value.(*entry)
+		ret.Images = append(ret.Images, Service.ImageInfo{
+			ID:          e.Meta.ID,
+			Size:        e.Meta.Size,
+			ContentType: e.Meta.ContentType,
+			Uploaded:    e.Meta.Uploaded,
+		})
+	}
+	return ret, nil
+}
+
+// Meta retrieves the metadata of the Image with the given id.
+func (is *ImageService) Meta(ID string) (Service.ImageMeta, error) {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+
+	el, ok := is.images[ID]
+	if !ok {
+		return Service.ImageMeta{}, Service.ErrImageNotFound
+	}
+	return el.Value.(*entry).Meta, nil
+}
+
+// GetVariant retrieves the variant of the Image with the given id.
+func (is *ImageService) GetVariant(ID, key string) (Service.Image, error) {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+
+	el, ok := is.images[ID]
+	if !ok {
+		return Service.Image{}, Service.ErrImageNotFound
+	}
+	vel, ok := el.Value.(*entry).Variants[key]
+	if !ok {
+		return Service.Image{}, Service.ErrImageNotFound
+	}
+	is.lru.MoveToFront(el)
+	is.variants.MoveToFront(vel)
+
+	v := vel.Value.(*variant)
+	return Service.Image{
+		ID:          ID,
+		ContentType: v.ContentType,
+		Data:        bytes.NewReader(v.Data),
+	}, nil
+}
+
+// PutVariant stores a variant of the Image with the given id, it counts towards MaxBytes. Less recently used variants
+// are evicted to make room for it, it isn't kept when the images alone fill the store.
+func (is *ImageService) PutVariant(ID, key string, img Service.Image) error {
+	b, err := ioutil.ReadAll(img.Data)
+	if err != nil {
+		return errors.Wrapf(err, "error reading variant %s of %s", key, ID)
+	}
+
+	is.mu.Lock()
+	defer is.mu.Unlock()
+
+	el, ok := is.images[ID]
+	if !ok {
+		return Service.ErrImageNotFound
+	}
+	e := el.Value.(*entry)
+	if old, ok := e.Variants[key]; ok {
+		is.removeVariant(old)
+	}
+	e.Variants[key] = is.variants.PushFront(&variant{ID: ID, Key: key, ContentType: img.ContentType, Data: b})
+	is.size += int64(len(b))
+	is.evictVariants()
+	return nil
+}
+
+// DeleteVariants removes all variants of the Image with the given id.
+func (is *ImageService) DeleteVariants(ID string) error {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+
+	el, ok := is.images[ID]
+	if !ok {
+		return nil
+	}
+	for _, vel := range el.Value.(*entry).Variants {
+		is.removeVariant(vel)
+	}
+	return nil
+}
+
+// Size returns the total size of the stored images and variants.
+func (is *ImageService) Size() int64 {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	return is.size
+}
+
+// evict removes the least recently used variants, then images, until the store is within MaxBytes, must be called
+// with mu held.
+func (is *ImageService) evict() {
+	if is.MaxBytes <= 0 {
+		return
+	}
+	is.evictVariants()
+	for is.size > is.MaxBytes && is.lru.Len() > 0 {
+		is.remove(is.lru.Back())
+	}
+}
+
+// evictVariants removes the least recently used variants until the store is within MaxBytes, must be called with mu
+// held.
+func (is *ImageService) evictVariants() {
+	if is.MaxBytes <= 0 {
+		return
+	}
+	for is.size > is.MaxBytes && is.variants.Len() > 0 {
+		is.removeVariant(is.variants.Back())
+	}
+}
+
+// remove drops the stored image and its variants, must be called with mu held.
+func (is *ImageService) remove(el *list.Element) {
+	e := el.Value.(*entry)
+	for _, vel := range e.Variants {
+		is.removeVariant(vel)
+	}
+	is.lru.Remove(el)
+	delete(is.images, e.Meta.ID)
+	if is.hashes[e.Meta.SHA256] == e.Meta.ID {
+		delete(is.hashes, e.Meta.SHA256)
+	}
+	is.size -= int64(len(e.Data))
+}
+
+// removeVariant drops the stored variant, must be called with mu held.
+func (is *ImageService) removeVariant(el *list.Element) {
+	v := is.variants.Remove(el).(*variant)
+	if e, ok := is.images[v.ID]; ok {
+		delete(e.Value.(*entry).Variants, v.Key)
+	}
+	is.size -= int64(len(v.Data))
+}
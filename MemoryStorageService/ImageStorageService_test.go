@@ -0,0 +1,435 @@
+package MemoryStorageService_test
+
+import (
+	"bytes"
+	"github.com/asatisomnath/ProgImage/Service"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+
+	_ "github.com/asatisomnath/ProgImage/Convertors/formats" // register formats, do not remove
+	"github.com/asatisomnath/ProgImage/MemoryStorageService"
+	"github.com/google/uuid"
+)
+
+var fileTests = []struct {
+	Name        string
+	Path        string
+	ContentType string
+}{
+	{Name: "png", Path: "../testimages/test.png", ContentType: "image/png"},
+	{Name: "gif", Path: "../testimages/test.gif", ContentType: "image/gif"},
+	{Name: "jpg", Path: "../testimages/test.jpg", ContentType: "image/jpeg"},
+}
+
+func TestImageService_StoreGetImage(t *testing.T) {
+	for _, item := range fileTests {
+		t.Run(item.Name, func(t *testing.T) {
+			uid := uuid.New()
+			is := MemoryStorageService.NewImageService(0, func() uuid.UUID { return uid })
+
+			d, err := ioutil.ReadFile(item.Path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := is.Upload(bytes.NewReader(d))
+			if err != nil {
+				t.Fatal(err)
+			}
+			id := res.ID
+			if id != uid.String() {
+				t.Errorf("expected id to be %s, got %s", uid.String(), id)
+			}
+
+			img, err := is.Get(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if img.ContentType != item.ContentType {
+				t.Errorf("expected image content type to be '%s', got '%s'", item.ContentType, img.ContentType)
+			}
+
+			retrieved, err := ioutil.ReadAll(img.Data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(d, retrieved) {
+				t.Error("expected stored data to be equal to initial data from file, data not equal")
+			}
+		})
+	}
+}
+
+func TestImageService_StoreNoData(t *testing.T) {
+	is := MemoryStorageService.NewImageService(0, uuid.New)
+
+	if _, err := is.Upload(bytes.NewReader([]byte{})); err != Service.ErrNotAnImage {
+		t.Errorf("expected ProgImage.ErrNotAnImage, got %s", err)
+	}
+	if _, err := is.Get("foo"); err != Service.ErrImageNotFound {
+		t.Errorf("expected ProgImage.ErrImageNotFound, got %s", err)
+	}
+}
+
+func TestImageService_Evict(t *testing.T) {
+	d, err := ioutil.ReadFile("../testimages/test.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// room for 2 images
+	is := MemoryStorageService.NewImageService(int64(len(d))*2, uuid.New)
+
+	upload := func() string {
+		res, err := is.Upload(bytes.NewReader(d))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.ID
+	}
+
+	first := upload()
+	second := upload()
+
+	// use the first so the second is the least recently used
+	if _, err := is.Get(first); err != nil {
+		t.Fatal(err)
+	}
+
+	third := upload()
+
+	if _, err := is.Get(second); err != Service.ErrImageNotFound {
+		t.Errorf("expected %s to be evicted, got %v", second, err)
+	}
+	for _, ID := range []string{first, third} {
+		if _, err := is.Get(ID); err != nil {
+			t.Errorf("expected %s to be stored, got %s", ID, err)
+		}
+	}
+	if is.Size() != int64(len(d))*2 {
+		t.Errorf("expected size to be %d, got %d", len(d)*2, is.Size())
+	}
+
+	// too big to ever fit
+	small := MemoryStorageService.NewImageService(10, uuid.New)
+	if _, err := small.Upload(bytes.NewReader(d)); err == nil {
+		t.Error("expected error storing image larger than the store")
+	}
+}
+
+func TestImageService_Delete(t *testing.T) {
+	is := MemoryStorageService.NewImageService(0, uuid.New)
+
+	d, err := ioutil.ReadFile("../testimages/test.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := is.Upload(bytes.NewReader(d))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := res.ID
+
+	if err := is.Delete(id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := is.Get(id); err != Service.ErrImageNotFound {
+		t.Errorf("expected ProgImage.ErrImageNotFound, got %s", err)
+	}
+	if err := is.Delete(id); err != Service.ErrImageNotFound {
+		t.Errorf("expected ProgImage.ErrImageNotFound, got %s", err)
+	}
+	if is.Size() != 0 {
+		t.Errorf("expected size to be 0, got %d", is.Size())
+	}
+}
+
+func TestImageService_List(t *testing.T) {
+	is := MemoryStorageService.NewImageService(0, uuid.New)
+
+	d, err := ioutil.ReadFile("../testimages/test.gif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := map[string]bool{}
+	for i := 0; i < 5; i++ {
+		res, err := is.Upload(bytes.NewReader(d))
+		if err != nil {
+			t.Fatal(err)
+		}
+		id := res.ID
+		stored[id] = true
+	}
+
+	// page through 2 at a time
+	listed := map[string]bool{}
+	cursor := ""
+	for pages := 1; ; pages++ {
+		list, err := is.List(cursor, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list.Images) > 2 {
+			t.Errorf("expected at most 2 images, got %d", len(list.Images))
+		}
+		for _, info := range list.Images {
+			if info.Size != int64(len(d)) {
+				t.Errorf("expected size to be %d, got %d", len(d), info.Size)
+			}
+			if info.ContentType != "image/gif" {
+				t.Errorf("expected content type to be image/gif, got %s", info.ContentType)
+			}
+			if info.Uploaded.IsZero() {
+				t.Error("expected uploaded time to be set")
+			}
+			listed[info.ID] = true
+		}
+		if list.Cursor == "" {
+			if pages != 3 {
+				t.Errorf("expected 3 pages, got %d", pages)
+			}
+			break
+		}
+		cursor = list.Cursor
+	}
+
+	if len(listed) != len(stored) {
+		t.Errorf("expected %d images listed, got %d", len(stored), len(listed))
+	}
+	for id := range stored {
+		if !listed[id] {
+			t.Errorf("expected %s to be listed", id)
+		}
+	}
+
+	if _, err := is.List("not a cursor!", 0); err != Service.ErrInvalidCursor {
+		t.Errorf("expected ProgImage.ErrInvalidCursor, got %v", err)
+	}
+}
+
+func TestImageService_Meta(t *testing.T) {
+	is := MemoryStorageService.NewImageService(0, uuid.New)
+
+	d, err := ioutil.ReadFile("../testimages/test.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := is.Upload(bytes.NewReader(d))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := res.ID
+
+	meta, err := is.Meta(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.ID != id || meta.ContentType != "image/jpeg" || meta.Format != "jpeg" || meta.ColorModel != "ycbcr" {
+		t.Errorf("unexpected meta %+v", meta)
+	}
+	if meta.Width != 1920 || meta.Height != 1440 {
+		t.Errorf("expected 1920x1440, got %dx%d", meta.Width, meta.Height)
+	}
+	if meta.Size != int64(len(d)) {
+		t.Errorf("expected size to be %d, got %d", len(d), meta.Size)
+	}
+	if meta.SHA256 != "909c9f3bc90930e1f799d6378e3e768354aed526d47ca14408bbf216d7b4bc56" {
+		t.Errorf("unexpected sha256 %s", meta.SHA256)
+	}
+	if meta.Uploaded.IsZero() {
+		t.Error("expected uploaded time to be set")
+	}
+
+	if _, err := is.Meta("foo"); err != Service.ErrImageNotFound {
+		t.Errorf("expected ProgImage.ErrImageNotFound, got %v", err)
+	}
+}
+
+func TestImageService_ContentAddressed(t *testing.T) {
+	is := MemoryStorageService.NewImageService(0, uuid.New)
+	is.ContentAddressed = true
+
+	d, err := ioutil.ReadFile("../testimages/test.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := is.Upload(bytes.NewReader(d))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Deduplicated {
+		t.Error("expected first upload not to be deduplicated")
+	}
+
+	second, err := is.Upload(bytes.NewReader(d))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !second.Deduplicated || second.ID != first.ID {
+		t.Errorf("expected second upload to be deduplicated to %s, got %+v", first.ID, second)
+	}
+
+	// different data gets a new id
+	g, err := ioutil.ReadFile("../testimages/test.gif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := is.Upload(bytes.NewReader(g))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.Deduplicated || other.ID == first.ID {
+		t.Errorf("expected different data not to be deduplicated, got %+v", other)
+	}
+
+	// once deleted the data is stored again
+	if err := is.Delete(first.ID); err != nil {
+		t.Fatal(err)
+	}
+	third, err := is.Upload(bytes.NewReader(d))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third.Deduplicated || third.ID == first.ID {
+		t.Errorf("expected upload after delete not to be deduplicated, got %+v", third)
+	}
+}
+
+func TestImageService_Variants(t *testing.T) {
+	is := MemoryStorageService.NewImageService(0, uuid.New)
+
+	d, err := ioutil.ReadFile("../testimages/test.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := is.Upload(bytes.NewReader(d))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := res.ID
+
+	if _, err := is.GetVariant(id, "gif"); err != Service.ErrImageNotFound {
+		t.Errorf("expected ProgImage.ErrImageNotFound, got %v", err)
+	}
+
+	variant := []byte("some converted data")
+	if err := is.PutVariant(id, "gif", Service.Image{ContentType: "image/gif", Data: bytes.NewReader(variant)}); err != nil {
+		t.Fatal(err)
+	}
+	v, err := is.GetVariant(id, "gif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.ContentType != "image/gif" {
+		t.Errorf("expected variant content type to be image/gif, got %s", v.ContentType)
+	}
+	got, err := ioutil.ReadAll(v.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, ok := v.Data.(io.Closer); ok {
+		c.Close()
+	}
+	if !bytes.Equal(variant, got) {
+		t.Errorf("expected variant data to be %q, got %q", variant, got)
+	}
+
+	// variants can't outlive their image
+	if err := is.PutVariant("foo", "gif", Service.Image{ContentType: "image/gif", Data: bytes.NewReader(variant)}); err != Service.ErrImageNotFound {
+		t.Errorf("expected ProgImage.ErrImageNotFound, got %v", err)
+	}
+	if err := is.Delete(id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := is.GetVariant(id, "gif"); err != Service.ErrImageNotFound {
+		t.Errorf("expected ProgImage.ErrImageNotFound after delete, got %v", err)
+	}
+	if is.Size() != 0 {
+		t.Errorf("expected size to be 0, got %d", is.Size())
+	}
+}
+
+func TestImageService_EvictVariants(t *testing.T) {
+	d, err := ioutil.ReadFile("../testimages/test.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// room for 2 images and a little more
+	is := MemoryStorageService.NewImageService(int64(len(d))*2+10, uuid.New)
+	upload := func() string {
+		res, err := is.Upload(bytes.NewReader(d))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.ID
+	}
+	put := func(ID string, data []byte) {
+		if err := is.PutVariant(ID, "gif", Service.Image{ContentType: "image/gif", Data: bytes.NewReader(data)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a variant that doesn't fit alongside the images isn't kept, rather than evicting its image
+	first := upload()
+	second := upload()
+	put(first, make([]byte, 11))
+	if _, err := is.GetVariant(first, "gif"); err != Service.ErrImageNotFound {
+		t.Errorf("expected the variant not to be kept, got %v", err)
+	}
+	for _, ID := range []string{first, second} {
+		if _, err := is.Get(ID); err != nil {
+			t.Errorf("expected %s to be stored, got %s", ID, err)
+		}
+	}
+
+	// variants are evicted before images
+	put(first, make([]byte, 10))
+	put(second, make([]byte, 10))
+	if _, err := is.GetVariant(first, "gif"); err != Service.ErrImageNotFound {
+		t.Errorf("expected the least recently used variant to be evicted, got %v", err)
+	}
+	if _, err := is.GetVariant(second, "gif"); err != nil {
+		t.Errorf("expected the variant to be stored, got %v", err)
+	}
+	if _, err := is.Get(first); err != nil {
+		t.Errorf("expected %s to be stored, got %s", first, err)
+	}
+	if is.Size() != int64(len(d))*2+10 {
+		t.Errorf("expected size to be %d, got %d", len(d)*2+10, is.Size())
+	}
+}
+
+func TestImageService_Concurrent(t *testing.T) {
+	d, err := ioutil.ReadFile("../testimages/test.gif")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	is := MemoryStorageService.NewImageService(int64(len(d))*4, uuid.New)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := is.Upload(bytes.NewReader(d))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			// may have been evicted by another upload already
+			if _, err := is.Get(res.ID); err != nil && err != Service.ErrImageNotFound {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if is.Size() > int64(len(d))*4 {
+		t.Errorf("expected size to be at most %d, got %d", len(d)*4, is.Size())
+	}
+}
@@ -9,10 +9,16 @@ var _ Service.ImageService = &ImageService{}
 
 // ImageService is a Mock ProgImage.ImageService.
 type ImageService struct {
-	GetInvoked   bool
-	StoreInvoked bool
-	GetFunc      func(string) (Service.Image, error)
-	StoreFunc    func(io.Reader) (string, error)
+	GetInvoked    bool
+	StoreInvoked  bool
+	DeleteInvoked bool
+	ListInvoked   bool
+	MetaInvoked   bool
+	GetFunc       func(string) (Service.Image, error)
+	StoreFunc     func(io.Reader) (Service.UploadResult, error)
+	DeleteFunc    func(string) error
+	ListFunc      func(string, int) (Service.ImageList, error)
+	MetaFunc      func(string) (Service.ImageMeta, error)
 }
 
 // Get an Convertors.
@@ -22,7 +28,25 @@ func (is *ImageService) Get(ID string) (Service.Image, error) {
 }
 
 // Store an Convertors.
-func (is *ImageService) Upload(imgRdr io.Reader) (string, error) {
+func (is *ImageService) Upload(imgRdr io.Reader) (Service.UploadResult, error) {
 	is.StoreInvoked = true
 	return is.StoreFunc(imgRdr)
 }
+
+// Delete an image.
+func (is *ImageService) Delete(ID string) error {
+	is.DeleteInvoked = true
+	return is.DeleteFunc(ID)
+}
+
+// List images.
+func (is *ImageService) List(cursor string, limit int) (Service.ImageList, error) {
+	is.ListInvoked = true
+	return is.ListFunc(cursor, limit)
+}
+
+// Meta of an image.
+func (is *ImageService) Meta(ID string) (Service.ImageMeta, error) {
+	is.MetaInvoked = true
+	return is.MetaFunc(ID)
+}
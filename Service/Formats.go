@@ -0,0 +1,110 @@
+package Service
+
+import (
+	"image"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Format describes an image format, how to recognise, decode and convert to it. Formats are registered by the packages
+// implementing them, usually from init, and everything dealing in formats looks them up here.
+type Format struct {
+	Name         string   // eg jpeg, as given in ImageMeta
+	Ext          string   // the extension images are converted to the format with, eg jpg
+	Aliases      []string // other extensions for the format, eg jpeg
+	ContentType  string   // eg image/jpeg
+	Magic        []string // prefixes of data in the format, ? matches any byte
+	Decode       func(io.Reader) (image.Image, error)
+	DecodeConfig func(io.Reader) (image.Config, error)
+	Frames       func(io.Reader) (int, error) // counts frames without decoding them, nil when there's only ever one
+	Converter    ImageTypeConverter           // converts images to the format, nil when it can only be read
+}
+
+var (
+	formatsMu sync.RWMutex
+	formats   = map[string]Format{}
+)
+
+// RegisterFormat registers a format, replacing any with the same name.
+func RegisterFormat(f Format) {
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+	formats[f.Name] = f
+}
+
+// Formats returns the registered formats ordered by name.
+func Formats() []Format {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	ret := make([]Format, 0, len(formats))
+	for _, f := range formats {
+		ret = append(ret, f)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret
+}
+
+// FormatByName finds a registered format by name.
+func FormatByName(name string) (Format, bool) {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	f, ok := formats[name]
+	return f, ok
+}
+
+// FormatByExt finds the registered format with the extension or alias, ignoring case.
+func FormatByExt(ext string) (Format, bool) {
+	ext = strings.ToLower(ext)
+	return findFormat(func(f Format) bool {
+		if f.Ext == ext {
+			return true
+		}
+		for _, a := range f.Aliases {
+			if a == ext {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// FormatByContentType finds the registered format with the content type.
+func FormatByContentType(contentType string) (Format, bool) {
+	return findFormat(func(f Format) bool { return f.ContentType == contentType })
+}
+
+// SniffFormat finds the registered format whose magic number starts head.
+func SniffFormat(head []byte) (Format, bool) {
+	return findFormat(func(f Format) bool {
+		for _, m := range f.Magic {
+			if matchMagic(m, head) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func findFormat(match func(Format) bool) (Format, bool) {
+	for _, f := range Formats() {
+		if match(f) {
+			return f, true
+		}
+	}
+	return Format{}, false
+}
+
+// matchMagic reports whether b starts with magic, ? in magic matches any byte.
+func matchMagic(magic string, b []byte) bool {
+	if len(b) < len(magic) {
+		return false
+	}
+	for i := 0; i < len(magic); i++ {
+		if magic[i] != '?' && magic[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
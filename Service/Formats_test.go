@@ -0,0 +1,48 @@
+package Service_test
+
+import (
+	"github.com/asatisomnath/ProgImage/Service"
+	"testing"
+
+	_ "github.com/asatisomnath/ProgImage/Convertors/formats" // register formats, do not remove
+)
+
+func TestFormatLookup(t *testing.T) {
+	for _, test := range []struct {
+		Ext         string
+		Name        string
+		ContentType string
+	}{
+		{Ext: "png", Name: "png", ContentType: "image/png"},
+		{Ext: "jpg", Name: "jpeg", ContentType: "image/jpeg"},
+		{Ext: "jpeg", Name: "jpeg", ContentType: "image/jpeg"},
+		{Ext: "JPG", Name: "jpeg", ContentType: "image/jpeg"},
+		{Ext: "gif", Name: "gif", ContentType: "image/gif"},
+		{Ext: "bmp", Name: "bmp", ContentType: "image/bmp"},
+		{Ext: "tif", Name: "tiff", ContentType: "image/tiff"},
+		{Ext: "webp", Name: "webp", ContentType: "image/webp"},
+	} {
+		t.Run(test.Ext, func(t *testing.T) {
+			f, ok := Service.FormatByExt(test.Ext)
+			if !ok {
+				t.Fatalf("expected a format for %s", test.Ext)
+			}
+			if f.Name != test.Name || f.ContentType != test.ContentType {
+				t.Errorf("expected %s %s, got %s %s", test.Name, test.ContentType, f.Name, f.ContentType)
+			}
+			if f.Converter == nil {
+				t.Error("expected a converter")
+			}
+			if byType, _ := Service.FormatByContentType(f.ContentType); byType.Name != f.Name {
+				t.Errorf("expected %s by content type, got %s", f.Name, byType.Name)
+			}
+		})
+	}
+
+	if _, ok := Service.FormatByExt("heic"); ok {
+		t.Error("expected no format for heic")
+	}
+	if _, ok := Service.SniffFormat([]byte("not an image")); ok {
+		t.Error("expected no format sniffed from text")
+	}
+}
@@ -0,0 +1,100 @@
+package Service
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+)
+
+// Limits bound the dimensions of images that are decoded. They're checked from the image's header before its pixels
+// are decoded, so a small file can't claim a huge image. Zero limits don't apply.
+type Limits struct {
+	MaxWidth  int
+	MaxHeight int
+	MaxPixels int64
+}
+
+// DefaultLimits are the limits ImageLimits starts with.
+var DefaultLimits = Limits{MaxWidth: 16384, MaxHeight: 16384, MaxPixels: 50 * 1000 * 1000}
+
+// ImageLimits are the limits uploads and conversions are held to, set them before serving.
+var ImageLimits = DefaultLimits
+
+// LimitError is returned for an image with dimensions over a limit.
+type LimitError struct {
+	Width  int
+	Height int
+	Frames int    // of an animation over MaxPixels across its frames, 0 otherwise
+	Limit  string // the limit exceeded, eg max width 16384
+}
+
+func (e *LimitError) Error() string {
+	if e.Frames > 0 {
+		return fmt.Sprintf("image of %dx%d with %d frames exceeds the %s", e.Width, e.Height, e.Frames, e.Limit)
+	}
+	return fmt.Sprintf("image of %dx%d exceeds the %s", e.Width, e.Height, e.Limit)
+}
+
+// Check checks the dimensions of c against the limits, returning a *LimitError for the first exceeded.
+func (l Limits) Check(c image.Config) error {
+	switch {
+	case l.MaxWidth > 0 && c.Width > l.MaxWidth:
+		return &LimitError{Width: c.Width, Height: c.Height, Limit: fmt.Sprintf("max width %d", l.MaxWidth)}
+	case l.MaxHeight > 0 && c.Height > l.MaxHeight:
+		return &LimitError{Width: c.Width, Height: c.Height, Limit: fmt.Sprintf("max height %d", l.MaxHeight)}
+	case l.MaxPixels > 0 && int64(c.Width)*int64(c.Height) > l.MaxPixels:
+		return &LimitError{Width: c.Width, Height: c.Height, Limit: fmt.Sprintf("max of %d pixels", l.MaxPixels)}
+	}
+	return nil
+}
+
+// CheckFrames checks the dimensions of an image of c with frames frames against the limits as Check does, MaxPixels
+// applies to the frames together as they're all decoded to convert an animation.
+func (l Limits) CheckFrames(c image.Config, frames int) error {
+	if err := l.Check(c); err != nil {
+		return err
+	}
+	if frames > 1 && l.MaxPixels > 0 && int64(c.Width)*int64(c.Height)*int64(frames) > l.MaxPixels {
+		return &LimitError{
+			Width:  c.Width,
+			Height: c.Height,
+			Frames: frames,
+			Limit:  fmt.Sprintf("max of %d pixels", l.MaxPixels),
+		}
+	}
+	return nil
+}
+
+// DecodeConfig reads the header of the image in format f from r, and counts its frames when the format can have
+// several, checking them against ImageLimits. The returned reader replays what was read followed by the rest of r, for
+// decoding the image in full.
+func DecodeConfig(f Format, r io.Reader) (c image.Config, frames int, rdr io.Reader, err error) {
+	if f.DecodeConfig == nil {
+		return image.Config{}, 1, r, nil
+	}
+	buf := new(bytes.Buffer)
+	if c, err = f.DecodeConfig(io.TeeReader(r, buf)); err != nil {
+		return c, 0, nil, err
+	}
+	if err := ImageLimits.Check(c); err != nil {
+		return c, 0, nil, err
+	}
+	frames = 1
+	if f.Frames != nil {
+		// the frames follow the header, what's read counting them is kept to be replayed too
+		if frames, err = f.Frames(io.MultiReader(bytes.NewReader(buf.Bytes()), io.TeeReader(r, buf))); err != nil {
+			return c, 0, nil, err
+		}
+		if err := ImageLimits.CheckFrames(c, frames); err != nil {
+			return c, 0, nil, err
+		}
+	}
+	return c, frames, io.MultiReader(buf, r), nil
+}
+
+// IsRejected reports whether err is from Sniff or ValidateImage refusing the data, rather than failing to read it.
+func IsRejected(err error) bool {
+	_, limit := err.(*LimitError)
+	return limit || err == ErrUnrecognisedImageType || IsUnsupported(err)
+}
@@ -0,0 +1,122 @@
+package Service_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/asatisomnath/ProgImage/Service"
+	"hash/crc32"
+	"image"
+	"image/color/palette"
+	"image/gif"
+	"image/png"
+	"io/ioutil"
+	"testing"
+
+	_ "github.com/asatisomnath/ProgImage/Convertors/formats" // register formats, do not remove
+)
+
+// pngClaiming is a small PNG whose header claims it's w by h.
+func pngClaiming(t *testing.T, w, h uint32) []byte {
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+	d := buf.Bytes()
+	// the IHDR chunk follows the 8 byte signature, its data starts with the width and height
+	binary.BigEndian.PutUint32(d[16:], w)
+	binary.BigEndian.PutUint32(d[20:], h)
+	binary.BigEndian.PutUint32(d[29:], crc32.ChecksumIEEE(d[12:29]))
+	return d
+}
+
+func TestLimits_Check(t *testing.T) {
+	l := Service.Limits{MaxWidth: 100, MaxHeight: 50, MaxPixels: 2000}
+	for _, test := range []struct {
+		Width, Height int
+		Limit         string
+	}{
+		{Width: 40, Height: 50},
+		{Width: 101, Height: 1, Limit: "max width 100"},
+		{Width: 1, Height: 51, Limit: "max height 50"},
+		{Width: 100, Height: 21, Limit: "max of 2000 pixels"},
+	} {
+		err := l.Check(image.Config{Width: test.Width, Height: test.Height})
+		if test.Limit == "" {
+			if err != nil {
+				t.Errorf("%dx%d expected no error, got %v", test.Width, test.Height, err)
+			}
+			continue
+		}
+		if le, ok := err.(*Service.LimitError); !ok || le.Limit != test.Limit {
+			t.Errorf("%dx%d expected %s to be exceeded, got %v", test.Width, test.Height, test.Limit, err)
+		}
+	}
+
+	if err := (Service.Limits{}).Check(image.Config{Width: 1 << 30, Height: 1 << 30}); err != nil {
+		t.Errorf("expected no limits, got %v", err)
+	}
+}
+
+func TestLimits_CheckFrames(t *testing.T) {
+	l := Service.Limits{MaxWidth: 100, MaxHeight: 50, MaxPixels: 2000}
+	if err := l.CheckFrames(image.Config{Width: 10, Height: 10}, 20); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	err := l.CheckFrames(image.Config{Width: 10, Height: 10}, 21)
+	if le, ok := err.(*Service.LimitError); !ok || le.Frames != 21 || le.Limit != "max of 2000 pixels" {
+		t.Errorf("expected the frames together to exceed max of 2000 pixels, got %v", err)
+	}
+	if err := l.CheckFrames(image.Config{Width: 101, Height: 1}, 1); err == nil {
+		t.Error("expected each frame to be held to the limits")
+	}
+}
+
+// TestValidateImage_Frames checks an animation is refused from its frames together, before they're decoded.
+func TestValidateImage_Frames(t *testing.T) {
+	g := &gif.GIF{}
+	for i := 0; i < 3; i++ {
+		g.Image = append(g.Image, image.NewPaletted(image.Rect(0, 0, 10, 10), palette.Plan9))
+		g.Delay = append(g.Delay, 10)
+	}
+	buf := new(bytes.Buffer)
+	if err := gif.EncodeAll(buf, g); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(l Service.Limits) { Service.ImageLimits = l }(Service.ImageLimits)
+	Service.ImageLimits = Service.Limits{MaxPixels: 299}
+	_, err := Service.ValidateImage(bytes.NewReader(buf.Bytes()), ioutil.Discard)
+	if le, ok := err.(*Service.LimitError); !ok || le.Frames != 3 {
+		t.Fatalf("expected *ProgImage.LimitError for 3 frames, got %v", err)
+	}
+
+	Service.ImageLimits = Service.Limits{MaxPixels: 300}
+	out := new(bytes.Buffer)
+	if _, err := Service.ValidateImage(bytes.NewReader(buf.Bytes()), out); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), buf.Bytes()) {
+		t.Error("expected the frames read to count them to be written through")
+	}
+}
+
+// TestValidateImage_Bomb checks an image is refused from its header, before it's decoded.
+func TestValidateImage_Bomb(t *testing.T) {
+	d := pngClaiming(t, 50000, 50000)
+	_, err := Service.ValidateImage(bytes.NewReader(d), ioutil.Discard)
+	if _, ok := err.(*Service.LimitError); !ok {
+		t.Fatalf("expected *ProgImage.LimitError, got %v", err)
+	}
+	if !Service.IsRejected(err) {
+		t.Error("expected error to reject the image")
+	}
+
+	// within the limits the header read to check them is replayed to the decoder and written through
+	buf := new(bytes.Buffer)
+	if _, err := Service.ValidateImage(bytes.NewReader(pngClaiming(t, 1, 1)), buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != len(d) {
+		t.Errorf("expected %d bytes written, got %d", len(d), buf.Len())
+	}
+}
@@ -0,0 +1,59 @@
+package Service
+
+import (
+	"encoding/base64"
+	"errors"
+	"time"
+)
+
+// DefaultListLimit is the number of images listed when no limit is given.
+const DefaultListLimit = 100
+
+// MaxListLimit is the largest number of images that can be listed at once.
+const MaxListLimit = 1000
+
+// ErrInvalidCursor represents a listing cursor that wasn't issued by the ImageService.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ImageInfo describes a stored image without its data. ContentType is empty when the ImageService can't list it.
+type ImageInfo struct {
+	ID          string    `json:"id"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"contentType,omitempty"`
+	Uploaded    time.Time `json:"uploaded"`
+}
+
+// ImageList is a page of stored images. Cursor continues the listing from the end of the page and is empty when there
+// are no more images.
+type ImageList struct {
+	Images []ImageInfo `json:"images"`
+	Cursor string      `json:"cursor,omitempty"`
+}
+
+// EncodeCursor wraps a backend specific position in an opaque cursor.
+func EncodeCursor(position string) string {
+	if position == "" {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(position))
+}
+
+// DecodeCursor returns the backend specific position from a cursor made by EncodeCursor.
+func DecodeCursor(cursor string) (string, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", ErrInvalidCursor
+	}
+	return string(b), nil
+}
+
+// ListLimit returns limit clamped to the range an ImageService supports.
+func ListLimit(limit int) int {
+	if limit <= 0 {
+		return DefaultListLimit
+	}
+	if limit > MaxListLimit {
+		return MaxListLimit
+	}
+	return limit
+}
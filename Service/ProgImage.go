@@ -1,11 +1,16 @@
 package Service
 
+import "image"
 import "io"
 import "errors"
+import "time"
 
 // ErrImageNotFound represents an Convertors not found.
 var ErrImageNotFound = errors.New("Convertors not found")
 
+// ErrImageTooLarge is returned uploading an image too large for the store to hold at all.
+var ErrImageTooLarge = errors.New("image too large to store")
+
 // ErrUnrecognisedImageType represents Convertors data that can't be processed.
 var ErrUnrecognisedImageType = errors.New("unrecognised Convertors data")
 
@@ -16,13 +21,68 @@ type Image struct {
 	ContentType string
 }
 
+// ImageMeta describes a stored image, it's captured when the image is validated on upload.
+type ImageMeta struct {
+	ID          string    `json:"id"`
+	ContentType string    `json:"contentType"`
+	Width       int       `json:"width"`
+	Height      int       `json:"height"`
+	Format      string    `json:"format"`
+	ColorModel  string    `json:"colorModel"`
+	Size        int64     `json:"size"`
+	SHA256      string    `json:"sha256"`
+	Uploaded    time.Time `json:"uploaded"`
+}
+
+// UploadResult is the outcome of storing an image. Deduplicated is set when the data was already stored and the
+// existing ID has been reused.
+type UploadResult struct {
+	ID           string
+	Deduplicated bool
+}
+
 // ImageService is an interface for a service that can store and retrieve images.
 type ImageService interface {
 	Get(ID string) (Image, error)
-	Upload(imageReader io.Reader) (string, error)
+	Upload(imageReader io.Reader) (UploadResult, error)
+	Delete(ID string) error
+	List(cursor string, limit int) (ImageList, error)
+	Meta(ID string) (ImageMeta, error)
+}
+
+// VariantStore is an interface for a service that can persist converted copies of images so they don't need converting
+// again. Variants are keyed by the id of the original and a key describing the conversion, deleting the original
+// deletes its variants. PutVariant returns ErrImageNotFound when the original doesn't exist, including when it's
+// deleted while the variant is stored.
+type VariantStore interface {
+	GetVariant(ID, key string) (Image, error)
+	PutVariant(ID, key string, img Image) error
+	DeleteVariants(ID string) error
 }
 
-// ImageTypeTransformer is an interface that can transform images.
+// ImageTypeTransformer is an interface that can transform images. Transformers are applied in order to the decoded
+// image before it's encoded, an image is always re-encoded when there are any.
 type ImageTypeConverter interface {
-	Convert(Image, chan error) (Image, error)
-}
\ No newline at end of file
+	Convert(Image, chan error, ...Transformer) (Image, error)
+}
+
+// Transformer alters the pixels of a decoded image, eg resizing it. String describes the transformation and its
+// parameters, it's used to tell transformed copies of an image apart.
+type Transformer interface {
+	Transform(image.Image) (image.Image, error)
+	String() string
+}
+
+// Resolver is a Transformer whose effect depends on the image, eg where it crops. Resolve fixes the effect it would
+// have on an image as a Transformer, so the same can be applied to others alike, eg the frames of an animation.
+type Resolver interface {
+	Transformer
+	Resolve(image.Image) (Transformer, error)
+}
+// Sizer is a Transformer that can tell what it makes of an image of a size before it's applied, so the output can be
+// held to the limits and the memory reserved first. Size returns the size of the output, the bytes the transformation
+// allocates, the output among them, and the error Transform would give when it can tell.
+type Sizer interface {
+	Transformer
+	Size(image.Point) (out image.Point, n int64, err error)
+}
@@ -0,0 +1,121 @@
+package Service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// sniffLen is how much of the data is read to find its format, enough for the brands of an ftyp box.
+const sniffLen = 64
+
+// ErrNotAnImage is returned for data that isn't in any image format known of.
+var ErrNotAnImage = errors.New("data isn't in a known image format")
+
+// UnsupportedFormatError is returned for data in an image format that's known of but not supported, eg HEIC.
+type UnsupportedFormatError struct {
+	Name        string
+	ContentType string
+}
+
+func (e *UnsupportedFormatError) Error() string {
+	return fmt.Sprintf("unsupported image format %s (%s)", e.Name, e.ContentType)
+}
+
+// IsUnsupported reports whether err is for data that isn't in a supported format, rather than an image that's broken.
+func IsUnsupported(err error) bool {
+	var ufe *UnsupportedFormatError
+	return err == ErrNotAnImage || errors.As(err, &ufe)
+}
+
+// signature recognises an image format that isn't supported.
+type signature struct {
+	Name        string
+	ContentType string
+	Magic       []string // as Format.Magic
+	Brands      []string // ISO base media file brands, major or compatible, of an ftyp box
+}
+
+// unsupportedSignatures are image formats known of but not supported, in the order they're tried. AVIF files list the
+// HEIF brands as well as their own so they come first.
+var unsupportedSignatures = []signature{
+	{Name: "avif", ContentType: "image/avif", Brands: []string{"avif", "avis"}},
+	{Name: "heic", ContentType: "image/heic", Brands: []string{"heic", "heix", "heim", "heis", "hevc", "hevx"}},
+	{Name: "heif", ContentType: "image/heif", Brands: []string{"mif1", "msf1"}},
+	{Name: "jxl", ContentType: "image/jxl", Magic: []string{"\xff\x0a", "\x00\x00\x00\x0cJXL \r\n\x87\n"}},
+	{Name: "jp2", ContentType: "image/jp2", Magic: []string{"\x00\x00\x00\x0cjP  \r\n\x87\n"}},
+	{Name: "psd", ContentType: "image/vnd.adobe.photoshop", Magic: []string{"8BPS"}},
+	{Name: "ico", ContentType: "image/x-icon", Magic: []string{"\x00\x00\x01\x00"}},
+}
+
+func (s signature) match(head []byte) bool {
+	for _, m := range s.Magic {
+		if matchMagic(m, head) {
+			return true
+		}
+	}
+	for _, b := range ftypBrands(head) {
+		for _, sb := range s.Brands {
+			if b == sb {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ftypBrands are the major and compatible brands of the ftyp box head starts with, as far as head goes.
+func ftypBrands(head []byte) []string {
+	if len(head) < 12 || string(head[4:8]) != "ftyp" {
+		return nil
+	}
+	end := int(binary.BigEndian.Uint32(head))
+	if end > len(head) {
+		end = len(head)
+	}
+	brands := []string{string(head[8:12])}
+	// compatible brands follow the minor version
+	for i := 16; i+4 <= end; i += 4 {
+		brands = append(brands, string(head[i:i+4]))
+	}
+	return brands
+}
+
+// Sniff finds the registered format of the data from its magic number. The returned reader replays the header
+// followed by the rest of r. Data in a format that's known of but not registered gets an *UnsupportedFormatError,
+// anything else ErrNotAnImage.
+func Sniff(r io.Reader) (Format, io.Reader, error) {
+	b := make([]byte, sniffLen)
+	n, err := io.ReadFull(r, b)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return Format{}, nil, err
+	}
+	b = b[:n]
+
+	f, err := sniffHead(b)
+	if err != nil {
+		return f, nil, err
+	}
+	return f, io.MultiReader(bytes.NewReader(b), r), nil
+}
+
+// SniffContentType extracts the mime type from the header of the image data, see Sniff.
+func SniffContentType(r io.Reader) (string, io.Reader, error) {
+	f, rdr, err := Sniff(r)
+	return f.ContentType, rdr, err
+}
+
+// sniffHead finds the registered format of the data head starts, failing as Sniff does.
+func sniffHead(head []byte) (Format, error) {
+	if f, ok := SniffFormat(head); ok {
+		return f, nil
+	}
+	for _, s := range unsupportedSignatures {
+		if s.match(head) {
+			return Format{}, &UnsupportedFormatError{Name: s.Name, ContentType: s.ContentType}
+		}
+	}
+	return Format{}, ErrNotAnImage
+}
@@ -0,0 +1,103 @@
+package Service_test
+
+import (
+	"bytes"
+	"github.com/asatisomnath/ProgImage/Service"
+	"io/ioutil"
+	"testing"
+	"testing/iotest"
+
+	_ "github.com/asatisomnath/ProgImage/Convertors/formats" // register formats, do not remove
+)
+
+// ftyp is the start of an ISO base media file with an ftyp box of the brands.
+func ftyp(major string, compatible ...string) []byte {
+	b := []byte{0, 0, 0, byte(16 + 4*len(compatible))}
+	b = append(b, "ftyp"+major+"\x00\x00\x00\x00"...)
+	for _, c := range compatible {
+		b = append(b, c...)
+	}
+	// the next box
+	return append(b, "\x00\x00\x00\x08meta"...)
+}
+
+func TestSniff_Unsupported(t *testing.T) {
+	for _, test := range []struct {
+		Name   string
+		Data   []byte
+		Format string
+	}{
+		{Name: "heic", Data: ftyp("heic", "mif1", "heic"), Format: "heic"},
+		{Name: "heic compatible", Data: ftyp("mif1", "mif1", "heic"), Format: "heic"},
+		{Name: "heif", Data: ftyp("mif1", "mif1"), Format: "heif"},
+		{Name: "avif", Data: ftyp("avif", "avif", "mif1", "miaf"), Format: "avif"},
+		{Name: "avif compatible", Data: ftyp("mif1", "mif1", "miaf", "avif"), Format: "avif"},
+		{Name: "jxl", Data: []byte("\xff\x0a\xfa\x7f"), Format: "jxl"},
+		{Name: "psd", Data: []byte("8BPS\x00\x01"), Format: "psd"},
+		{Name: "ico", Data: []byte("\x00\x00\x01\x00\x01\x00\x10\x10"), Format: "ico"},
+	} {
+		t.Run(test.Name, func(t *testing.T) {
+			_, _, err := Service.Sniff(bytes.NewReader(test.Data))
+			ufe, ok := err.(*Service.UnsupportedFormatError)
+			if !ok {
+				t.Fatalf("expected *ProgImage.UnsupportedFormatError, got %v", err)
+			}
+			if ufe.Name != test.Format {
+				t.Errorf("expected %s, got %s", test.Format, ufe.Name)
+			}
+			if !Service.IsUnsupported(err) {
+				t.Error("expected error to be unsupported")
+			}
+		})
+	}
+}
+
+func TestSniff_NotAnImage(t *testing.T) {
+	for name, d := range map[string][]byte{
+		"empty":      {},
+		"text":       []byte("not an image"),
+		"other ftyp": ftyp("isom", "isom", "mp41"),
+		"riff":       []byte("RIFF\x24\x00\x00\x00WAVEfmt "),
+	} {
+		if _, _, err := Service.Sniff(bytes.NewReader(d)); err != Service.ErrNotAnImage {
+			t.Errorf("%s expected ProgImage.ErrNotAnImage, got %v", name, err)
+		}
+	}
+	if Service.IsUnsupported(Service.ErrUnrecognisedImageType) {
+		t.Error("expected broken images not to be unsupported")
+	}
+}
+
+// TestSniff_ShortReads checks the header is read in full from readers that return a little at a time.
+func TestSniff_ShortReads(t *testing.T) {
+	for _, item := range fileTests {
+		t.Run(item.Name, func(t *testing.T) {
+			d, err := ioutil.ReadFile(item.Path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			f, rdr, err := Service.Sniff(iotest.OneByteReader(bytes.NewReader(d)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f.ContentType != item.ContentType {
+				t.Errorf("expected content type to be %s, got %s", item.ContentType, f.ContentType)
+			}
+			if got, err := ioutil.ReadAll(rdr); err != nil {
+				t.Fatal(err)
+			} else if !bytes.Equal(d, got) {
+				t.Error("expected data to be replayed in full")
+			}
+		})
+	}
+}
+
+func TestSniff_WebpVariants(t *testing.T) {
+	for _, chunk := range []string{"VP8 ", "VP8L", "VP8X"} {
+		d := []byte("RIFF\x24\x00\x00\x00WEBP" + chunk + "\x00\x00\x00\x00")
+		if f, _, err := Service.Sniff(bytes.NewReader(d)); err != nil || f.Name != "webp" {
+			t.Errorf("%s expected webp, got %s %v", chunk, f.Name, err)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package Service
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"image"
+	"io"
+	"io/ioutil"
+)
+
+// MaxImageBytes is the largest image an ImageService will accept.
+const MaxImageBytes = 20 * 1024 * 1024 // 20mb
+
+// ValidateImage decodes the data to ensure we have a valid image within ImageLimits. Everything read from r is written to w so the data
+// can be persisted at the same time as it's validated. The returned ImageMeta is filled in from the decoded image, the
+// ID, ContentType and Uploaded fields are left for the caller.
+func ValidateImage(r io.Reader, w io.Writer) (ImageMeta, error) {
+	meta := ImageMeta{}
+	h := sha256.New()
+	cw := &countingWriter{}
+	tr := io.TeeReader(r, io.MultiWriter(w, h, cw))
+
+	br := bufio.NewReader(tr)
+	head, _ := br.Peek(sniffLen) // nolint: gas,errcheck
+	f, err := sniffHead(head)
+	if err != nil {
+		return meta, err
+	}
+	// the header is checked first so an image too large to decode isn't
+	_, _, rdr, err := DecodeConfig(f, br)
+	if err != nil {
+		if _, ok := err.(*LimitError); ok {
+			return meta, err
+		}
+		return meta, ErrUnrecognisedImageType
+	}
+	img, err := f.Decode(rdr)
+	if err != nil {
+		return meta, ErrUnrecognisedImageType
+	}
+
+	// decoders can stop short of the end of the data (eg the gif trailer), make sure w gets all of it
+	if _, err := io.Copy(ioutil.Discard, br); err != nil {
+		return meta, err
+	}
+
+	b := img.Bounds()
+	meta.Width = b.Dx()
+	meta.Height = b.Dy()
+	meta.Format = f.Name
+	meta.ColorModel = colorModelName(img)
+	meta.Size = cw.n
+	meta.SHA256 = hex.EncodeToString(h.Sum(nil))
+	return meta, nil
+}
+
+// colorModelName names the color model of the decoded image.
+func colorModelName(img image.Image) string {
+	switch img.(type) {
+	case *image.RGBA:
+		return "rgba"
+	case *image.RGBA64:
+		return "rgba64"
+	case *image.NRGBA:
+		return "nrgba"
+	case *image.NRGBA64:
+		return "nrgba64"
+	case *image.Alpha:
+		return "alpha"
+	case *image.Alpha16:
+		return "alpha16"
+	case *image.Gray:
+		return "gray"
+	case *image.Gray16:
+		return "gray16"
+	case *image.CMYK:
+		return "cmyk"
+	case *image.YCbCr:
+		return "ycbcr"
+	case *image.NYCbCrA:
+		return "nycbcra"
+	case *image.Paletted:
+		return "paletted"
+	default:
+		return "unknown"
+	}
+}
+
+// countingWriter counts the bytes written to it.
+type countingWriter struct {
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	cw.n += int64(len(p))
+	return len(p), nil
+}
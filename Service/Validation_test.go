@@ -0,0 +1,85 @@
+package Service_test
+
+import (
+	"bytes"
+	"github.com/asatisomnath/ProgImage/Service"
+	"io/ioutil"
+	"testing"
+
+	_ "github.com/asatisomnath/ProgImage/Convertors/formats" // register formats, do not remove
+)
+
+var fileTests = []struct {
+	Name        string
+	Path        string
+	ContentType string
+	Meta        Service.ImageMeta
+}{
+	{Name: "png", Path: "../testimages/test.png", ContentType: "image/png", Meta: Service.ImageMeta{
+		Width: 1000, Height: 476, Format: "png", ColorModel: "paletted", Size: 8408,
+		SHA256: "217cc82a48ed6bbe25941abda0074054ee79c352ba7aeef10ad7b380e7f8e2be",
+	}},
+	{Name: "gif", Path: "../testimages/test.gif", ContentType: "image/gif", Meta: Service.ImageMeta{
+		Width: 64, Height: 64, Format: "gif", ColorModel: "paletted", Size: 29322,
+		SHA256: "8e0ba1a49a7493d9999a54af561b53bd023ae1880d92c7d036de270648a628da",
+	}},
+	{Name: "jpg", Path: "../testimages/test.jpg", ContentType: "image/jpeg", Meta: Service.ImageMeta{
+		Width: 1920, Height: 1440, Format: "jpeg", ColorModel: "ycbcr", Size: 377930,
+		SHA256: "909c9f3bc90930e1f799d6378e3e768354aed526d47ca14408bbf216d7b4bc56",
+	}},
+	{Name: "bmp", Path: "../testimages/test.bmp", ContentType: "image/bmp", Meta: Service.ImageMeta{
+		Width: 64, Height: 64, Format: "bmp", ColorModel: "paletted", Size: 5174,
+		SHA256: "0adaca0b7ae7f0dc1f022045b64be41ab3d4e15b632bcde6afc14a25e18b2c2f",
+	}},
+	{Name: "tiff", Path: "../testimages/test.tiff", ContentType: "image/tiff", Meta: Service.ImageMeta{
+		Width: 64, Height: 64, Format: "tiff", ColorModel: "paletted", Size: 1806,
+		SHA256: "a4e53ae81ab458373bac66f38d46e6d364b0003198d0804dac3c8ecb4f70d911",
+	}},
+	{Name: "webp", Path: "../testimages/test.webp", ContentType: "image/webp", Meta: Service.ImageMeta{
+		Width: 150, Height: 100, Format: "webp", ColorModel: "ycbcr", Size: 2450,
+		SHA256: "af8e87f21fa9fcb8e74c12d31d61a56b1d8e06819038efd10119b0f0726bfab4",
+	}},
+}
+
+func TestSniffAndValidate(t *testing.T) {
+	for _, item := range fileTests {
+		t.Run(item.Name, func(t *testing.T) {
+			d, err := ioutil.ReadFile(item.Path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			contentType, rdr, err := Service.SniffContentType(bytes.NewReader(d))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if contentType != item.ContentType {
+				t.Errorf("expected content type to be %s, got %s", item.ContentType, contentType)
+			}
+
+			// everything, including the sniffed header, should be written through
+			buf := new(bytes.Buffer)
+			meta, err := Service.ValidateImage(rdr, buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(d, buf.Bytes()) {
+				t.Error("expected written data to be equal to initial data from file, data not equal")
+			}
+			if meta != item.Meta {
+				t.Errorf("expected meta to be %+v, got %+v", item.Meta, meta)
+			}
+		})
+	}
+}
+
+func TestSniffAndValidate_Invalid(t *testing.T) {
+	if _, _, err := Service.SniffContentType(bytes.NewReader([]byte("not an image"))); err != Service.ErrNotAnImage {
+		t.Errorf("expected ProgImage.ErrNotAnImage, got %v", err)
+	}
+
+	d := append([]byte("\x89PNG\x0D\x0A\x1A\x0A"), bytes.Repeat([]byte{0xff}, 64)...)
+	if _, err := Service.ValidateImage(bytes.NewReader(d), ioutil.Discard); err != Service.ErrUnrecognisedImageType {
+		t.Errorf("expected ProgImage.ErrUnrecognisedImageType, got %v", err)
+	}
+}
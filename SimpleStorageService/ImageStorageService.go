@@ -1,16 +1,13 @@
 package SimpleStorageService
 
 import (
-	"bytes"
 	"github.com/asatisomnath/ProgImage/Service"
-	"image"
-	_ "image/gif"
-	_ "image/jpeg"
-	_ "image/png"
 	"io"
+	"io/ioutil"
 	"log"
-	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/minio/minio-go"
 	"github.com/pkg/errors"
@@ -18,13 +15,34 @@ import (
 	"github.com/google/uuid"
 )
 
+// user metadata headers holding Service.ImageMeta
+const (
+	metaWidth      = "X-Amz-Meta-Width"
+	metaHeight     = "X-Amz-Meta-Height"
+	metaFormat     = "X-Amz-Meta-Format"
+	metaColorModel = "X-Amz-Meta-Color-Model"
+	metaSHA256     = "X-Amz-Meta-Sha256"
+	metaUploaded   = "X-Amz-Meta-Uploaded"
+)
+
 var _ Service.ImageService = &ImageService{}
+var _ Service.VariantStore = &ImageService{}
+
+// hashPrefix is the key prefix of the content addressed index, each object is named after the sha256 of an image
+// and holds its id. Listing skips it as only top level objects are listed.
+const hashPrefix = "hashes/"
+
+// variantPrefix is the key prefix of converted copies of images, variants of an image are stored under
+// variantPrefix/id/.
+const variantPrefix = "variants/"
 
-// ImageService implements ProgImage.ImageService by storing data in S3 (or other compatible api).
+// ImageService implements ProgImage.ImageService by storing data in S3 (or other compatible api). When
+// ContentAddressed is set uploads of data that's already stored reuse the existing id.
 type ImageService struct {
-	BucketName string
-	Client     *minio.Client
-	UUID       func() uuid.UUID
+	BucketName       string
+	ContentAddressed bool
+	Client           *minio.Client
+	UUID             func() uuid.UUID
 }
 
 // NewImageService provides an initialised ImageService.
@@ -51,6 +69,39 @@ func (is *ImageService) EnsureBucket() error {
 	return nil
 }
 
+// lookupHash returns the id of the stored image with the given sha256, or an empty string if there isn't one.
+func (is *ImageService) lookupHash(sum string) (string, error) {
+	obj, err := is.Client.GetObject(is.BucketName, hashPrefix+sum, minio.GetObjectOptions{})
+	if err != nil {
+		return "", errors.Wrapf(err, "error getting hash index %s", sum)
+	}
+	defer obj.Close() // nolint: errcheck
+
+	b, err := ioutil.ReadAll(obj)
+	if err != nil {
+		if isNoSuchKey(err) {
+			return "", nil
+		}
+		return "", errors.Wrapf(err, "error reading hash index %s", sum)
+	}
+
+	// the index can outlive the image
+	ID := string(b)
+	if _, err := is.Client.StatObject(is.BucketName, ID, minio.StatObjectOptions{}); err != nil {
+		if isNoSuchKey(err) {
+			return "", nil
+		}
+		return "", errors.Wrapf(err, "error getting image data %s", ID)
+	}
+	return ID, nil
+}
+
+// isNoSuchKey reports whether err is S3 telling us an object doesn't exist.
+func isNoSuchKey(err error) bool {
+	er, ok := err.(minio.ErrorResponse)
+	return ok && er.Code == "NoSuchKey"
+}
+
 // Get retrieves the Image with the given id.
 func (is *ImageService) Get(ID string) (Service.Image, error) {
 	ret := Service.Image{}
@@ -62,8 +113,7 @@ func (is *ImageService) Get(ID string) (Service.Image, error) {
 	// ensure the Convertors exists
 	info, err := obj.Stat()
 	if err != nil {
-		er, ok := err.(minio.ErrorResponse)
-		if ok && er.Code == "NoSuchKey" {
+		if isNoSuchKey(err) {
 			return ret, Service.ErrImageNotFound
 		}
 		return ret, errors.Wrapf(err, "error getting Convertors data %s", ID)
@@ -76,19 +126,19 @@ func (is *ImageService) Get(ID string) (Service.Image, error) {
 }
 
 // Store validates data is an Convertors (read into memory), persists the Convertors and returns the id.
-func (is *ImageService) Upload(rawImg io.Reader) (string, error) {
+func (is *ImageService) Upload(rawImg io.Reader) (Service.UploadResult, error) {
+	ret := Service.UploadResult{}
+
 	// limit max size
-	lr := io.LimitReader(rawImg, 20*1024*1024) // 20mb, refactor to config object so value can be set/modified
+	lr := io.LimitReader(rawImg, Service.MaxImageBytes)
 
 	// extract the mime type from the header
-	b := make([]byte, 20)
-	if _, err := lr.Read(b); err != io.EOF && err != nil {
-		return "", errors.Wrap(err, "unable to read Convertors data")
-	}
-	contentType := http.DetectContentType(b)
-	if !strings.HasPrefix(contentType, "Convertors") {
-		// not an Convertors, bail
-		return "", Service.ErrUnrecognisedImageType
+	contentType, rdr, err := Service.SniffContentType(lr)
+	if err != nil {
+		if Service.IsUnsupported(err) {
+			return ret, err
+		}
+		return ret, errors.Wrap(err, "unable to read Convertors data")
 	}
 
 	// create 2 readers of Convertors data, one is used to decode to ensure we have a valid Convertors, the other is used
@@ -98,9 +148,7 @@ func (is *ImageService) Upload(rawImg io.Reader) (string, error) {
 
 	// create 2 readers of rawImg (reads need to be syncronised, will block otherwise)
 	pr, pw := io.Pipe()
-	tr := io.TeeReader(io.MultiReader(bytes.NewReader(b), rawImg), pw)
 
-	var err error
 	errCh := make(chan error, 1)
 
 	u := is.UUID()
@@ -115,8 +163,12 @@ func (is *ImageService) Upload(rawImg io.Reader) (string, error) {
 
 	// decode the Convertors to ensure we have a valid Convertors
 	var uploadErr error
-	var decodeErr error
-	if _, _, decodeErr = image.Decode(tr); decodeErr != nil {
+	meta, decodeErr := Service.ValidateImage(rdr, pw)
+	if decodeErr != nil {
+		rejected := Service.ErrUnrecognisedImageType
+		if Service.IsRejected(decodeErr) {
+			rejected = decodeErr
+		}
 		// io.EOF to read side
 		pw.Close() // nolint: gas,errcheck
 		uploadErr = <-errCh
@@ -125,15 +177,15 @@ func (is *ImageService) Upload(rawImg io.Reader) (string, error) {
 		if err = is.Client.RemoveObject(is.BucketName, u.String()); err != nil {
 			if uploadErr != nil {
 				// Let's assume not uploaded to avoid further complexity in this example
-				return "", Service.ErrUnrecognisedImageType
+				return ret, rejected
 			}
 
 			// file not valid Convertors, file uploaded ok but delete failed
 			log.Printf("error deleting invalid Convertors %s, %s", u, err)
-			return "", Service.ErrUnrecognisedImageType
+			return ret, rejected
 		}
 
-		return "", Service.ErrUnrecognisedImageType
+		return ret, rejected
 	}
 
 	// nolint: gas,errcheck
@@ -141,8 +193,231 @@ func (is *ImageService) Upload(rawImg io.Reader) (string, error) {
 	uploadErr = <-errCh
 
 	if uploadErr != nil {
-		return "", errors.Wrap(uploadErr, "error uploading Convertors to s3")
+		return ret, errors.Wrap(uploadErr, "error uploading Convertors to s3")
+	}
+
+	if is.ContentAddressed {
+		// the hash isn't known until the data has been read, so the new copy is already uploaded
+		ID, err := is.lookupHash(meta.SHA256)
+		if err == nil && ID != "" {
+			if err := is.Client.RemoveObject(is.BucketName, u.String()); err != nil {
+				log.Printf("error deleting duplicate image %s, %s", u, err)
+			}
+			ret.ID = ID
+			ret.Deduplicated = true
+			return ret, nil
+		}
+		if err != nil {
+			log.Printf("error looking up image hash %s, %s", meta.SHA256, err)
+		}
+	}
+
+	// the metadata isn't known until the image has been decoded, copy the object over itself to attach it
+	meta.ID = u.String()
+	meta.ContentType = contentType
+	meta.Uploaded = time.Now().UTC()
+	if err := is.putMeta(meta); err != nil {
+		if rmErr := is.Client.RemoveObject(is.BucketName, u.String()); rmErr != nil {
+			log.Printf("error deleting image %s without metadata, %s", u, rmErr)
+		}
+		return ret, err
+	}
+
+	if is.ContentAddressed {
+		ID := strings.NewReader(meta.ID)
+		if _, err := is.Client.PutObject(is.BucketName, hashPrefix+meta.SHA256, ID, ID.Size(), minio.PutObjectOptions{ContentType: "text/plain"}); err != nil {
+			// the image is stored, it just won't be deduplicated against
+			log.Printf("error indexing image %s, %s", meta.ID, err)
+		}
+	}
+
+	ret.ID = meta.ID
+	return ret, nil
+}
+
+// putMeta replaces the user metadata of the stored image with meta.
+func (is *ImageService) putMeta(meta Service.ImageMeta) error {
+	headers := map[string]string{
+		"Content-Type":             meta.ContentType,
+		"X-Amz-Metadata-Directive": "REPLACE",
+		metaWidth:                  strconv.Itoa(meta.Width),
+		metaHeight:                 strconv.Itoa(meta.Height),
+		metaFormat:                 meta.Format,
+		metaColorModel:             meta.ColorModel,
+		metaSHA256:                 meta.SHA256,
+		metaUploaded:               meta.Uploaded.Format(time.RFC3339Nano),
+	}
+
+	core := minio.Core{Client: is.Client}
+	if _, err := core.CopyObject(is.BucketName, meta.ID, is.BucketName, meta.ID, headers); err != nil {
+		return errors.Wrapf(err, "error storing image metadata %s", meta.ID)
+	}
+	return nil
+}
+
+// Meta retrieves the metadata of the image with the given id.
+func (is *ImageService) Meta(ID string) (Service.ImageMeta, error) {
+	info, err := is.Client.StatObject(is.BucketName, ID, minio.StatObjectOptions{})
+	if err != nil {
+		if isNoSuchKey(err) {
+			return Service.ImageMeta{}, Service.ErrImageNotFound
+		}
+		return Service.ImageMeta{}, errors.Wrapf(err, "error getting image data %s", ID)
+	}
+	return imageMeta(info), nil
+}
+
+// imageMeta reads the ImageMeta stored as user metadata, falling back to the object info for anything missing.
+func imageMeta(info minio.ObjectInfo) Service.ImageMeta {
+	meta := Service.ImageMeta{
+		ID:          info.Key,
+		ContentType: info.ContentType,
+		Format:      info.Metadata.Get(metaFormat),
+		ColorModel:  info.Metadata.Get(metaColorModel),
+		Size:        info.Size,
+		SHA256:      info.Metadata.Get(metaSHA256),
+		Uploaded:    info.LastModified,
+	}
+	meta.Width, _ = strconv.Atoi(info.Metadata.Get(metaWidth))
+	meta.Height, _ = strconv.Atoi(info.Metadata.Get(metaHeight))
+	if t, err := time.Parse(time.RFC3339Nano, info.Metadata.Get(metaUploaded)); err == nil {
+		meta.Uploaded = t
+	}
+	return meta
+}
+
+// Delete removes the Image with the given id.
+func (is *ImageService) Delete(ID string) error {
+	// RemoveObject doesn't complain about missing objects, ensure the image exists
+	info, err := is.Client.StatObject(is.BucketName, ID, minio.StatObjectOptions{})
+	if err != nil {
+		if isNoSuchKey(err) {
+			return Service.ErrImageNotFound
+		}
+		return errors.Wrapf(err, "error getting image data %s", ID)
+	}
+
+	if err := is.Client.RemoveObject(is.BucketName, ID); err != nil {
+		return errors.Wrapf(err, "error deleting image %s", ID)
+	}
+	if err := is.DeleteVariants(ID); err != nil {
+		return err
+	}
+
+	// drop the hash index if it points at this image
+	if sum := info.Metadata.Get(metaSHA256); sum != "" {
+		indexed, err := is.lookupHash(sum)
+		if err == nil && indexed == "" {
+			if err := is.Client.RemoveObject(is.BucketName, hashPrefix+sum); err != nil {
+				return errors.Wrapf(err, "error deleting hash index %s", ID)
+			}
+		}
+	}
+	return nil
+}
+
+// List returns a page of stored images. Only top level objects are listed, the hashes/ and variants/ prefixes are
+// skipped, and the cursor is the key of the last image of the page. The content type isn't among what S3 lists, it's
+// left empty rather than fetched for each image.
+func (is *ImageService) List(cursor string, limit int) (Service.ImageList, error) {
+	ret := Service.ImageList{Images: []Service.ImageInfo{}}
+	after, err := Service.DecodeCursor(cursor)
+	if err != nil {
+		return ret, err
 	}
+	limit = Service.ListLimit(limit)
 
-	return u.String(), nil
+	core := minio.Core{Client: is.Client}
+	token := ""
+	for {
+		// the prefixes count towards the keys listed, so there may be fewer images than asked for, one more than the
+		// page is listed to tell whether another image follows
+		res, err := core.ListObjectsV2(is.BucketName, "", token, false, "/", limit+1, after)
+		if err != nil {
+			return ret, errors.Wrap(err, "error listing images")
+		}
+		for _, obj := range res.Contents {
+			if len(ret.Images) == limit {
+				ret.Cursor = Service.EncodeCursor(ret.Images[limit-1].ID)
+				return ret, nil
+			}
+			ret.Images = append(ret.Images, Service.ImageInfo{
+				ID:       obj.Key,
+				Size:     obj.Size,
+				Uploaded: obj.LastModified,
+			})
+		}
+		if !res.IsTruncated {
+			return ret, nil
+		}
+		token = res.NextContinuationToken
+	}
+}
+
+// GetVariant retrieves the variant of the image with the given id.
+func (is *ImageService) GetVariant(ID, key string) (Service.Image, error) {
+	ret := Service.Image{}
+	obj, err := is.Client.GetObject(is.BucketName, variantPrefix+ID+"/"+key, minio.GetObjectOptions{})
+	if err != nil {
+		return ret, errors.Wrapf(err, "error getting variant %s %s", ID, key)
+	}
+
+	info, err := obj.Stat()
+	if err != nil {
+		obj.Close() // nolint: errcheck
+		if isNoSuchKey(err) {
+			return ret, Service.ErrImageNotFound
+		}
+		return ret, errors.Wrapf(err, "error getting variant data %s %s", ID, key)
+	}
+
+	ret.ID = ID
+	ret.Data = obj
+	ret.ContentType = info.ContentType
+	return ret, nil
+}
+
+// PutVariant stores a variant of the image with the given id.
+func (is *ImageService) PutVariant(ID, key string, img Service.Image) error {
+	// don't leave variants of deleted images behind
+	if _, err := is.Client.StatObject(is.BucketName, ID, minio.StatObjectOptions{}); err != nil {
+		if isNoSuchKey(err) {
+			return Service.ErrImageNotFound
+		}
+		return errors.Wrapf(err, "error getting image data %s", ID)
+	}
+
+	_, err := is.Client.PutObject(
+		is.BucketName, variantPrefix+ID+"/"+key,
+		img.Data, -1,
+		minio.PutObjectOptions{ContentType: img.ContentType},
+	)
+	if err != nil {
+		return errors.Wrapf(err, "error uploading variant %s %s", ID, key)
+	}
+
+	// the original may have been deleted, along with its variants, since it was checked
+	if _, err := is.Client.StatObject(is.BucketName, ID, minio.StatObjectOptions{}); isNoSuchKey(err) {
+		if err := is.DeleteVariants(ID); err != nil {
+			return err
+		}
+		return Service.ErrImageNotFound
+	}
+	return nil
+}
+
+// DeleteVariants removes all variants of the image with the given id.
+func (is *ImageService) DeleteVariants(ID string) error {
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+
+	for obj := range is.Client.ListObjectsV2(is.BucketName, variantPrefix+ID+"/", true, doneCh) {
+		if obj.Err != nil {
+			return errors.Wrapf(obj.Err, "error listing variants %s", ID)
+		}
+		if err := is.Client.RemoveObject(is.BucketName, obj.Key); err != nil {
+			return errors.Wrapf(err, "error deleting variant %s", obj.Key)
+		}
+	}
+	return nil
 }
@@ -8,6 +8,7 @@ import (
 	"os"
 	"testing"
 
+	_ "github.com/asatisomnath/ProgImage/Convertors/formats" // register formats, do not remove
 	"github.com/asatisomnath/ProgImage/SimpleStorageService"
 	"github.com/google/uuid"
 	"github.com/minio/minio-go"
@@ -71,9 +72,9 @@ var fileTests = []struct {
 	Path        string
 	ContentType string
 }{
-	{Name: "png", Path: "../testimages/test.png", ContentType: "Convertors/png"},
-	{Name: "gif", Path: "../testimages/test.gif", ContentType: "Convertors/gif"},
-	{Name: "jpg", Path: "../testimages/test.jpg", ContentType: "Convertors/jpeg"},
+	{Name: "png", Path: "../testimages/test.png", ContentType: "image/png"},
+	{Name: "gif", Path: "../testimages/test.gif", ContentType: "image/gif"},
+	{Name: "jpg", Path: "../testimages/test.jpg", ContentType: "image/jpeg"},
 }
 
 // Test storing and retrieving images of each type, a missed import will cause a failure, see
@@ -110,10 +111,11 @@ func TestImageService_StoreGetImage(t *testing.T) {
 			initial := bytes.NewReader(d)
 
 			// store then retrieve using id
-			id, err := is.Upload(initial)
+			res, err := is.Upload(initial)
 			if err != nil {
 				t.Fatal(err)
 			}
+			id := res.ID
 			if id == "" {
 				t.Error("expected id to be populated, got empty string")
 			}
@@ -164,8 +166,8 @@ func TestImageService_StoreNoData(t *testing.T) {
 	}
 
 	r := bytes.NewReader([]byte{})
-	if _, err := is.Upload(r); err != Service.ErrUnrecognisedImageType {
-		t.Errorf("expected ProgImage.ErrUnrecognisedImageType, got %s", err)
+	if _, err := is.Upload(r); err != Service.ErrNotAnImage {
+		t.Errorf("expected ProgImage.ErrNotAnImage, got %s", err)
 	}
 }
 
@@ -181,4 +183,268 @@ func TestImageService_GetNotExists(t *testing.T) {
 	if _, err := is.Get("foo"); err != Service.ErrImageNotFound {
 		t.Errorf("expected ProgImage.ErrImageNotFound, got %s", err)
 	}
-}
\ No newline at end of file
+}
+func TestImageService_Delete(t *testing.T) {
+	c := checkEnvsAndGetClient(t)
+	setup(t, c)
+
+	is := SimpleStorageService.NewImageService(testBucketName, c, uuid.New)
+	if err := is.EnsureBucket(); err != nil {
+		t.Fatal(err)
+	}
+
+	fp, err := os.Open("../testimages/test.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fp.Close()
+
+	res, err := is.Upload(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := res.ID
+	if err := is.Delete(id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := is.Get(id); err != Service.ErrImageNotFound {
+		t.Errorf("expected ProgImage.ErrImageNotFound, got %s", err)
+	}
+	if err := is.Delete(id); err != Service.ErrImageNotFound {
+		t.Errorf("expected ProgImage.ErrImageNotFound, got %s", err)
+	}
+}
+
+func TestImageService_List(t *testing.T) {
+	c := checkEnvsAndGetClient(t)
+	setup(t, c)
+
+	is := SimpleStorageService.NewImageService(testBucketName, c, uuid.New)
+	if err := is.EnsureBucket(); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := ioutil.ReadFile("../testimages/test.gif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := map[string]bool{}
+	var id string
+	for i := 0; i < 4; i++ {
+		res, err := is.Upload(bytes.NewReader(d))
+		if err != nil {
+			t.Fatal(err)
+		}
+		id = res.ID
+		stored[id] = true
+	}
+	// variants are stored under a prefix that isn't listed
+	variant := Service.Image{ID: id, ContentType: "image/png", Data: bytes.NewReader([]byte("variant"))}
+	if err := is.PutVariant(id, "png", variant); err != nil {
+		t.Fatal(err)
+	}
+
+	// page through 2 at a time
+	listed := map[string]bool{}
+	cursor := ""
+	for pages := 1; ; pages++ {
+		list, err := is.List(cursor, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list.Images) > 2 {
+			t.Errorf("expected at most 2 images, got %d", len(list.Images))
+		}
+		for _, info := range list.Images {
+			if info.Size != int64(len(d)) {
+				t.Errorf("expected size to be %d, got %d", len(d), info.Size)
+			}
+			if info.ContentType != "" {
+				t.Errorf("expected content type not to be listed, got %s", info.ContentType)
+			}
+			if info.Uploaded.IsZero() {
+				t.Error("expected uploaded time to be set")
+			}
+			listed[info.ID] = true
+		}
+		if list.Cursor == "" {
+			// the last page is full, there's no cursor to an empty one
+			if pages != 2 {
+				t.Errorf("expected 2 pages, got %d", pages)
+			}
+			break
+		}
+		cursor = list.Cursor
+	}
+
+	if len(listed) != len(stored) {
+		t.Errorf("expected %d images listed, got %d", len(stored), len(listed))
+	}
+	for id := range stored {
+		if !listed[id] {
+			t.Errorf("expected %s to be listed", id)
+		}
+	}
+
+	if _, err := is.List("not a cursor!", 0); err != Service.ErrInvalidCursor {
+		t.Errorf("expected ProgImage.ErrInvalidCursor, got %v", err)
+	}
+}
+
+func TestImageService_Meta(t *testing.T) {
+	c := checkEnvsAndGetClient(t)
+	setup(t, c)
+
+	is := SimpleStorageService.NewImageService(testBucketName, c, uuid.New)
+	if err := is.EnsureBucket(); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := ioutil.ReadFile("../testimages/test.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := is.Upload(bytes.NewReader(d))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := res.ID
+
+	meta, err := is.Meta(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.ID != id || meta.ContentType != "image/jpeg" || meta.Format != "jpeg" || meta.ColorModel != "ycbcr" {
+		t.Errorf("unexpected meta %+v", meta)
+	}
+	if meta.Width != 1920 || meta.Height != 1440 {
+		t.Errorf("expected 1920x1440, got %dx%d", meta.Width, meta.Height)
+	}
+	if meta.Size != int64(len(d)) {
+		t.Errorf("expected size to be %d, got %d", len(d), meta.Size)
+	}
+	if meta.SHA256 != "909c9f3bc90930e1f799d6378e3e768354aed526d47ca14408bbf216d7b4bc56" {
+		t.Errorf("unexpected sha256 %s", meta.SHA256)
+	}
+	if meta.Uploaded.IsZero() {
+		t.Error("expected uploaded time to be set")
+	}
+
+	if _, err := is.Meta("foo"); err != Service.ErrImageNotFound {
+		t.Errorf("expected ProgImage.ErrImageNotFound, got %v", err)
+	}
+}
+
+func TestImageService_ContentAddressed(t *testing.T) {
+	c := checkEnvsAndGetClient(t)
+	setup(t, c)
+
+	is := SimpleStorageService.NewImageService(testBucketName, c, uuid.New)
+	if err := is.EnsureBucket(); err != nil {
+		t.Fatal(err)
+	}
+	is.ContentAddressed = true
+
+	d, err := ioutil.ReadFile("../testimages/test.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := is.Upload(bytes.NewReader(d))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Deduplicated {
+		t.Error("expected first upload not to be deduplicated")
+	}
+
+	second, err := is.Upload(bytes.NewReader(d))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !second.Deduplicated || second.ID != first.ID {
+		t.Errorf("expected second upload to be deduplicated to %s, got %+v", first.ID, second)
+	}
+
+	// different data gets a new id
+	g, err := ioutil.ReadFile("../testimages/test.gif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := is.Upload(bytes.NewReader(g))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.Deduplicated || other.ID == first.ID {
+		t.Errorf("expected different data not to be deduplicated, got %+v", other)
+	}
+
+	// once deleted the data is stored again
+	if err := is.Delete(first.ID); err != nil {
+		t.Fatal(err)
+	}
+	third, err := is.Upload(bytes.NewReader(d))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third.Deduplicated || third.ID == first.ID {
+		t.Errorf("expected upload after delete not to be deduplicated, got %+v", third)
+	}
+}
+
+func TestImageService_Variants(t *testing.T) {
+	c := checkEnvsAndGetClient(t)
+	setup(t, c)
+
+	is := SimpleStorageService.NewImageService(testBucketName, c, uuid.New)
+	if err := is.EnsureBucket(); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := ioutil.ReadFile("../testimages/test.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := is.Upload(bytes.NewReader(d))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := res.ID
+
+	if _, err := is.GetVariant(id, "gif"); err != Service.ErrImageNotFound {
+		t.Errorf("expected ProgImage.ErrImageNotFound, got %v", err)
+	}
+
+	variant := []byte("some converted data")
+	if err := is.PutVariant(id, "gif", Service.Image{ContentType: "image/gif", Data: bytes.NewReader(variant)}); err != nil {
+		t.Fatal(err)
+	}
+	v, err := is.GetVariant(id, "gif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.ContentType != "image/gif" {
+		t.Errorf("expected variant content type to be image/gif, got %s", v.ContentType)
+	}
+	got, err := ioutil.ReadAll(v.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, ok := v.Data.(io.Closer); ok {
+		c.Close()
+	}
+	if !bytes.Equal(variant, got) {
+		t.Errorf("expected variant data to be %q, got %q", variant, got)
+	}
+
+	// variants can't outlive their image
+	if err := is.PutVariant("foo", "gif", Service.Image{ContentType: "image/gif", Data: bytes.NewReader(variant)}); err != Service.ErrImageNotFound {
+		t.Errorf("expected ProgImage.ErrImageNotFound, got %v", err)
+	}
+	if err := is.Delete(id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := is.GetVariant(id, "gif"); err != Service.ErrImageNotFound {
+		t.Errorf("expected ProgImage.ErrImageNotFound after delete, got %v", err)
+	}
+}
@@ -7,10 +7,16 @@ import (
 	"os/signal"
 	"time"
 
+	"github.com/asatisomnath/ProgImage/Cache"
 	"github.com/asatisomnath/ProgImage/Connection"
+	primage "github.com/asatisomnath/ProgImage/Convertors"
+	"github.com/asatisomnath/ProgImage/FileStorageService"
+	"github.com/asatisomnath/ProgImage/MemoryStorageService"
+	"github.com/asatisomnath/ProgImage/Service"
 	"github.com/asatisomnath/ProgImage/SimpleStorageService"
 	"github.com/google/uuid"
 	"github.com/minio/minio-go"
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 )
 
@@ -20,6 +26,20 @@ var accessKey string
 var secretKey string
 var endpoint string
 var secure *bool
+var storage string
+var dataDir string
+var memoryMaxBytes int64
+var contentAddressed bool
+var convertCacheBytes int64
+var cacheDir string
+var cacheSize int64
+var cacheControl string
+var encodeDefaults primage.Options
+var encodeCaps primage.Options
+var negotiate []string
+var maxMegapixels float64
+var convertMemoryBytes int64
+var convertMemoryWait time.Duration
 
 func init() {
 	rootCmd.AddCommand(serverCmd)
@@ -29,10 +49,61 @@ func init() {
 	serverCmd.Flags().StringVarP(&secretKey, "secretkey", "s", "miniostorage", "Storage secret key")
 	serverCmd.Flags().StringVarP(&endpoint, "endpoint", "e", "", "Storage endpoint")
 	secure = serverCmd.Flags().Bool("secure", true, "Secure storage eg TLS")
-	err := serverCmd.MarkFlagRequired("endpoint")
-	if err != nil {
-		fmt.Fprintf(os.Stderr, err.Error()) // nolint: errcheck,gas
-		os.Exit(1)
+	serverCmd.Flags().StringVar(&storage, "storage", "s3", "Storage backend, one of s3, fs or memory")
+	serverCmd.Flags().StringVar(&dataDir, "data-dir", "data", "Directory images are stored in (fs storage)")
+	serverCmd.Flags().Int64Var(&memoryMaxBytes, "memory-max-bytes", 0, "Max bytes held before evicting images, 0 for no limit (memory storage)")
+	serverCmd.Flags().BoolVar(&contentAddressed, "content-addressed", false, "Reuse the id of identical images instead of storing them again")
+	serverCmd.Flags().Int64Var(&convertCacheBytes, "convert-cache-bytes", 0, "Max bytes of converted images cached in memory, 0 to disable")
+	serverCmd.Flags().StringVar(&cacheDir, "cache-dir", "", "Directory originals are cached in on disk, unset to disable")
+	serverCmd.Flags().Int64Var(&cacheSize, "cache-size", 1<<30, "Max bytes of originals cached on disk")
+	serverCmd.Flags().StringVar(&cacheControl, "cache-control", Connection.DefaultCacheControl, "Cache-Control header sent with images, empty to omit")
+	serverCmd.Flags().IntVar(&encodeDefaults.Quality, "jpeg-quality", 0, "JPEG quality used when a request doesn't give one, 0 for the encoder's default")
+	serverCmd.Flags().IntVar(&encodeCaps.Quality, "max-jpeg-quality", 0, "Highest JPEG quality a request can ask for, 0 for no cap")
+	serverCmd.Flags().StringVar(&encodeDefaults.Compression, "png-compression", "", "PNG compression used when a request doesn't give one, one of none, speed, default or best")
+	serverCmd.Flags().StringVar(&encodeCaps.Compression, "max-png-compression", "", "Most PNG compression effort a request can ask for, unset for no cap")
+	serverCmd.Flags().IntVar(&encodeDefaults.Colors, "gif-colors", 0, "GIF palette size used when a request doesn't give one, 0 for the encoder's default")
+	serverCmd.Flags().IntVar(&encodeCaps.Colors, "max-gif-colors", 0, "Largest GIF palette a request can ask for, 0 for no cap")
+	serverCmd.Flags().StringVar(&encodeDefaults.Quantizer, "gif-quantizer", "", "GIF palette used when a request doesn't give one, mediancut (the default) or plan9")
+	serverCmd.Flags().StringVar(&encodeDefaults.Dither, "gif-dither", "", "GIF dithering used when a request doesn't give one, none, floydsteinberg (the default) or bayer")
+	serverCmd.Flags().IntVar(&Service.ImageLimits.MaxWidth, "max-width", Service.DefaultLimits.MaxWidth, "Widest image that's decoded, 0 for no limit")
+	serverCmd.Flags().IntVar(&Service.ImageLimits.MaxHeight, "max-height", Service.DefaultLimits.MaxHeight, "Tallest image that's decoded, 0 for no limit")
+	serverCmd.Flags().Float64Var(&maxMegapixels, "max-megapixels", float64(Service.DefaultLimits.MaxPixels)/1e6, "Largest image in megapixels that's decoded, 0 for no limit")
+	serverCmd.Flags().Int64Var(&convertMemoryBytes, "convert-memory-bytes", 1<<30, "Max bytes of decoded pixels, and what they're transformed into, held by conversions at once, 0 for no limit")
+	serverCmd.Flags().DurationVar(&convertMemoryWait, "convert-memory-wait", 5*time.Second, "How long a conversion waits for memory before it's refused")
+	serverCmd.Flags().StringSliceVar(&negotiate, "negotiate", nil, "Formats offered by Accept to GETs without an extension, most preferred first and the last as the fallback, eg webp,jpg")
+}
+
+// newImageService creates the ImageService for the selected storage backend.
+func newImageService() (Service.ImageService, error) {
+	switch storage {
+	case "s3":
+		if endpoint == "" {
+			return nil, errors.New(`required flag "endpoint" not set`)
+		}
+		c, err := minio.New(endpoint, accessKey, secretKey, *secure)
+		if err != nil {
+			return nil, err
+		}
+
+		is := SimpleStorageService.NewImageService(bucketName, c, uuid.New)
+		is.ContentAddressed = contentAddressed
+		if err := is.EnsureBucket(); err != nil {
+			fmt.Fprintf(os.Stdout, "error checking bucket exists: %+v\n", err) // nolint: gas,errcheck
+		}
+		return is, nil
+	case "fs":
+		is := FileStorageService.NewImageService(dataDir, uuid.New)
+		is.ContentAddressed = contentAddressed
+		if err := is.EnsureRoot(); err != nil {
+			return nil, err
+		}
+		return is, nil
+	case "memory":
+		is := MemoryStorageService.NewImageService(memoryMaxBytes, uuid.New)
+		is.ContentAddressed = contentAddressed
+		return is, nil
+	default:
+		return nil, errors.Errorf("unknown storage %q", storage)
 	}
 }
 
@@ -42,17 +113,59 @@ var serverCmd = &cobra.Command{
 	Long:  "Runs an Convertors processing Connection server",
 	Args:  cobra.NoArgs,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		c, err := minio.New(endpoint, accessKey, secretKey, *secure)
+		if err := encodeDefaults.Validate(); err != nil {
+			return errors.Wrap(err, "invalid encoding default")
+		}
+		if err := encodeCaps.Validate(); err != nil {
+			return errors.Wrap(err, "invalid encoding cap")
+		}
+		Service.ImageLimits.MaxPixels = int64(maxMegapixels * 1e6)
+
+		is, err := newImageService()
 		if err != nil {
 			return err
 		}
 
-		uuid.New()
-		is := SimpleStorageService.NewImageService(bucketName, c, uuid.New)
-		if err := is.EnsureBucket(); err != nil {
-			fmt.Fprintf(os.Stdout, "error checking bucket exists: %+v\n", err) // nolint: gas,errcheck
+		var served Service.ImageService = is
+		if cacheDir != "" {
+			c := Cache.NewImageService(is, cacheDir, cacheSize)
+			if err := c.Load(); err != nil {
+				return err
+			}
+			served = c
+		}
+
+		ih := Connection.NewImageHandler(served)
+		// the cache only holds originals, variants stay with the backend
+		ih.Variants, _ = is.(Service.VariantStore)
+		ih.CacheControl = cacheControl
+		for i, ext := range negotiate {
+			f, ok := Service.FormatByExt(ext)
+			if _, conv := ih.Converters[f.Ext]; !ok || !conv {
+				return errors.Errorf("unable to negotiate unknown format %q", ext)
+			}
+			// aliases such as jpeg are offered as the format's own extension
+			negotiate[i] = f.Ext
+		}
+		ih.Negotiate = negotiate
+		ih.EncodeDefaults, ih.EncodeCaps = encodeDefaults, encodeCaps
+		var budget *primage.Budget
+		if convertMemoryBytes > 0 {
+			budget = primage.NewBudget(convertMemoryBytes, convertMemoryWait)
+		}
+		ih.Budget = budget
+		for ext, conv := range ih.Converters {
+			if c, ok := conv.(primage.Converter); ok {
+				c.Defaults, c.Caps, c.Budget = encodeDefaults, encodeCaps, budget
+				ih.Converters[ext] = c
+			}
+		}
+		if convertCacheBytes > 0 {
+			cc := Cache.NewConverterCache(convertCacheBytes)
+			for ext, conv := range ih.Converters {
+				ih.Converters[ext] = cc.Wrap(ext, conv)
+			}
 		}
-		ih := Connection.NewImageHandler(is)
 		s := Connection.Server{
 			ImageHandler: *ih,
 			Addr:         addr,
@@ -0,0 +1,264 @@
+package Transformers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/asatisomnath/ProgImage/Service"
+	"strings"
+
+	primage "github.com/asatisomnath/ProgImage/Convertors"
+	"github.com/asatisomnath/ProgImage/Transformers/crop"
+	"github.com/asatisomnath/ProgImage/Transformers/orient"
+	"github.com/asatisomnath/ProgImage/Transformers/resize"
+	"github.com/pkg/errors"
+)
+
+// MaxSteps is the most steps a pipeline can have.
+const MaxSteps = 16
+
+// formatStep is the step choosing the format the image is encoded to, it's not a transformation.
+const formatStep = "format"
+
+// Step describes an operation that can appear in a pipeline. Positional names the argument a bare value is given to,
+// eg the 300 in resize:300 when it's "w", leave it empty when the step has none.
+type Step struct {
+	Positional string
+	Parse      func(args map[string]string) (Service.Transformer, error)
+}
+
+// Steps are the operations available to pipelines by name, format is handled by the pipeline itself.
+var Steps = map[string]Step{
+	"crop": {
+		Positional: "size",
+		Parse: func(args map[string]string) (Service.Transformer, error) {
+			return crop.Parse(args)
+		},
+	},
+	"smartcrop": {
+		Positional: "aspect",
+		Parse: func(args map[string]string) (Service.Transformer, error) {
+			return crop.ParseSmart(args)
+		},
+	},
+	"encode": {
+		Parse: func(args map[string]string) (Service.Transformer, error) {
+			return primage.ParseOptions(args)
+		},
+	},
+	"frame": {
+		Positional: "n",
+		Parse: func(args map[string]string) (Service.Transformer, error) {
+			return primage.ParseFrame(args)
+		},
+	},
+	"flip": {
+		Positional: "dir",
+		Parse: func(args map[string]string) (Service.Transformer, error) {
+			return orient.ParseFlip(args)
+		},
+	},
+	"orient": {
+		Positional: "mode",
+		Parse:      orient.ParseOrient,
+	},
+	"resize": {
+		Positional: "w",
+		Parse: func(args map[string]string) (Service.Transformer, error) {
+			return resize.Parse(args)
+		},
+	},
+	"rotate": {
+		Positional: "degrees",
+		Parse: func(args map[string]string) (Service.Transformer, error) {
+			return orient.ParseRotate(args)
+		},
+	},
+}
+
+// Pipeline is a parsed list of steps. It's run by passing Transformers to the ProgImage.ImageTypeConverter for Format,
+// which decodes the image once, applies them in order and encodes the result once. An empty Format keeps the format
+// of the image.
+type Pipeline struct {
+	Transformers []Service.Transformer
+	Format       string
+
+	formatIndex int
+}
+
+// StepError is a pipeline step that couldn't be parsed.
+type StepError struct {
+	Index   int    `json:"index"`
+	Step    string `json:"step"`
+	Message string `json:"message"`
+}
+
+func (e *StepError) Error() string {
+	return fmt.Sprintf("step %d (%s): %s", e.Index, e.Step, e.Message)
+}
+
+// RawStep is an unparsed step, an op name and its arguments.
+type RawStep struct {
+	Op   string
+	Args map[string]string
+
+	text string // as given, for errors
+}
+
+func (s RawStep) String() string {
+	if s.text != "" {
+		return s.text
+	}
+	return s.Op
+}
+
+// SplitPath splits a path of steps such as resize:w=300/format:png into raw steps. Each step is an op name optionally
+// followed by a colon and comma separated arguments, either name=value or a single bare value.
+func SplitPath(path string) ([]RawStep, error) {
+	var ret []RawStep
+	for _, text := range strings.Split(path, "/") {
+		if text == "" {
+			continue
+		}
+		i := len(ret)
+		op, args := text, ""
+		if c := strings.Index(text, ":"); c >= 0 {
+			op, args = text[:c], text[c+1:]
+		}
+		s := RawStep{Op: op, Args: map[string]string{}, text: text}
+
+		for _, arg := range strings.Split(args, ",") {
+			if arg == "" {
+				continue
+			}
+			k, v := "", arg
+			if e := strings.Index(arg, "="); e >= 0 {
+				k, v = arg[:e], arg[e+1:]
+			}
+			if _, ok := s.Args[k]; ok {
+				return nil, &StepError{Index: i, Step: text, Message: fmt.Sprintf("argument %q given more than once", k)}
+			}
+			s.Args[k] = v
+		}
+		ret = append(ret, s)
+	}
+	return ret, nil
+}
+
+// DecodeSteps decodes a JSON list of steps, each either a path style string such as "resize:w=300" or an object
+// naming the op alongside its arguments such as {"op": "resize", "w": 300}.
+func DecodeSteps(data []byte) ([]RawStep, error) {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, errors.Wrap(err, "steps must be a list")
+	}
+
+	var ret []RawStep
+	for i, r := range raw {
+		var text string
+		if err := json.Unmarshal(r, &text); err == nil {
+			s, err := SplitPath(text)
+			if err != nil {
+				err.(*StepError).Index = i
+				return nil, err
+			}
+			if len(s) != 1 {
+				return nil, &StepError{Index: i, Step: text, Message: "expected a single step"}
+			}
+			ret = append(ret, s[0])
+			continue
+		}
+
+		obj := map[string]interface{}{}
+		d := json.NewDecoder(bytes.NewReader(r))
+		d.UseNumber()
+		if err := d.Decode(&obj); err != nil {
+			return nil, &StepError{Index: i, Step: string(r), Message: "step must be a string or an object"}
+		}
+		op, _ := obj["op"].(string)
+		if op == "" {
+			return nil, &StepError{Index: i, Step: string(r), Message: "op is required"}
+		}
+		s := RawStep{Op: op, Args: map[string]string{}, text: string(r)}
+		for k, v := range obj {
+			if k != "op" {
+				s.Args[k] = fmt.Sprint(v)
+			}
+		}
+		ret = append(ret, s)
+	}
+	return ret, nil
+}
+
+// Parse builds a Pipeline from raw steps. formats reports whether an image can be encoded to the named format.
+func Parse(steps []RawStep, formats func(string) bool) (Pipeline, error) {
+	p := Pipeline{formatIndex: -1}
+	if len(steps) == 0 {
+		return p, &StepError{Index: 0, Message: "no steps given"}
+	}
+	if len(steps) > MaxSteps {
+		return p, &StepError{Index: MaxSteps, Step: steps[MaxSteps].String(), Message: fmt.Sprintf("at most %d steps are allowed", MaxSteps)}
+	}
+
+	for i, s := range steps {
+		fail := func(msg string) error {
+			return &StepError{Index: i, Step: s.String(), Message: msg}
+		}
+
+		if s.Op == formatStep {
+			if p.formatIndex >= 0 {
+				return p, fail("format given more than once")
+			}
+			format := s.Args["type"]
+			if v, ok := s.Args[""]; ok {
+				format = v
+			}
+			if len(s.Args) != 1 || format == "" {
+				return p, fail("format takes a single type, eg format:png")
+			}
+			if !formats(format) {
+				return p, fail(fmt.Sprintf("unsupported format %q", format))
+			}
+			p.Format = format
+			p.formatIndex = i
+			continue
+		}
+
+		step, ok := Steps[s.Op]
+		if !ok {
+			return p, fail(fmt.Sprintf("unknown op %q", s.Op))
+		}
+		args := s.Args
+		if v, ok := args[""]; ok {
+			if step.Positional == "" {
+				return p, fail(fmt.Sprintf("%s doesn't take a bare argument", s.Op))
+			}
+			if _, ok := args[step.Positional]; ok {
+				return p, fail(fmt.Sprintf("argument %q given more than once", step.Positional))
+			}
+			args = copyArgs(args)
+			delete(args, "")
+			args[step.Positional] = v
+		}
+
+		tr, err := step.Parse(args)
+		if err != nil {
+			return p, fail(err.Error())
+		}
+		p.Transformers = append(p.Transformers, tr)
+	}
+	return p, nil
+}
+
+// FormatIndex is the index of the format step, -1 when there isn't one.
+func (p Pipeline) FormatIndex() int {
+	return p.formatIndex
+}
+
+func copyArgs(args map[string]string) map[string]string {
+	ret := make(map[string]string, len(args))
+	for k, v := range args {
+		ret[k] = v
+	}
+	return ret
+}
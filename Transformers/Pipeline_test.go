@@ -0,0 +1,129 @@
+package Transformers_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/asatisomnath/ProgImage/Transformers"
+	"github.com/asatisomnath/ProgImage/Transformers/resize"
+)
+
+func formats(f string) bool {
+	return f == "png" || f == "jpg" || f == "gif" || f == "webp"
+}
+
+func TestSplitPath(t *testing.T) {
+	steps, err := Transformers.SplitPath("/resize:w=300,h=200/resize:120/format:png/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		Op   string
+		Args map[string]string
+	}{
+		{Op: "resize", Args: map[string]string{"w": "300", "h": "200"}},
+		{Op: "resize", Args: map[string]string{"": "120"}},
+		{Op: "format", Args: map[string]string{"": "png"}},
+	}
+	if len(steps) != len(expected) {
+		t.Fatalf("expected %d steps, got %d", len(expected), len(steps))
+	}
+	for i, s := range steps {
+		if s.Op != expected[i].Op || !reflect.DeepEqual(s.Args, expected[i].Args) {
+			t.Errorf("expected step %d to be %+v, got %+v", i, expected[i], s)
+		}
+	}
+
+	if _, err := Transformers.SplitPath("resize:w=1/resize:w=1,w=2"); err == nil {
+		t.Error("expected error for repeated argument")
+	} else if se, ok := err.(*Transformers.StepError); !ok || se.Index != 1 || se.Step != "resize:w=1,w=2" {
+		t.Errorf("expected step error for step 1, got %v", err)
+	}
+}
+
+func TestDecodeSteps(t *testing.T) {
+	steps, err := Transformers.DecodeSteps([]byte(`["resize:w=300", {"op": "resize", "h": 200, "fit": "inside"}, {"op": "format", "type": "gif"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := Transformers.Parse(steps, formats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"resize:w=300,h=0,fit=cover,filter=lanczos3",
+		"resize:w=0,h=200,fit=inside,filter=lanczos3",
+	}
+	if len(p.Transformers) != len(expected) {
+		t.Fatalf("expected %d transformers, got %d", len(expected), len(p.Transformers))
+	}
+	for i, tr := range p.Transformers {
+		if tr.String() != expected[i] {
+			t.Errorf("expected %s, got %s", expected[i], tr)
+		}
+	}
+	if p.Format != "gif" || p.FormatIndex() != 2 {
+		t.Errorf("expected format gif at 2, got %s at %d", p.Format, p.FormatIndex())
+	}
+
+	for _, body := range []string{`{}`, `"resize"`, `[1]`, `[{"w": 1}]`, `["resize:1/format:png"]`} {
+		if _, err := Transformers.DecodeSteps([]byte(body)); err == nil {
+			t.Errorf("expected error decoding %s", body)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	steps, err := Transformers.SplitPath("resize:400/format:jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := Transformers.Parse(steps, formats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Transformers) != 1 || p.Transformers[0].String() != (resize.Resize{Width: 400}).String() {
+		t.Errorf("unexpected transformers %v", p.Transformers)
+	}
+	if p.Format != "jpg" {
+		t.Errorf("expected format jpg, got %s", p.Format)
+	}
+
+	tests := []struct {
+		Path  string
+		Index int
+	}{
+		{Path: "", Index: 0},
+		{Path: "resize:w=100/blur:3", Index: 1},
+		{Path: "crop:400x400/crop:x=1", Index: 1},
+		{Path: "smartcrop:16", Index: 0},
+		{Path: "flip:h/rotate:45", Index: 1},
+		{Path: "encode:q=101", Index: 0},
+		{Path: "encode:70", Index: 0},
+		{Path: "frame:a", Index: 0},
+		{Path: "resize:w=abc", Index: 0},
+		{Path: "resize:w=100/resize:fit=cover", Index: 1},
+		{Path: "resize:w=100,size=3", Index: 0},
+		{Path: "resize:100,w=200", Index: 0},
+		{Path: "format:png/resize:w=10/format:gif", Index: 2},
+		{Path: "format:heic", Index: 0},
+		{Path: "format", Index: 0},
+		{Path: "resize:1/resize:1/resize:1/resize:1/resize:1/resize:1/resize:1/resize:1/resize:1/resize:1/resize:1/resize:1/resize:1/resize:1/resize:1/resize:1/resize:1", Index: Transformers.MaxSteps},
+	}
+	for _, item := range tests {
+		t.Run(item.Path, func(t *testing.T) {
+			steps, err := Transformers.SplitPath(item.Path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = Transformers.Parse(steps, formats)
+			se, ok := err.(*Transformers.StepError)
+			if !ok {
+				t.Fatalf("expected step error, got %v", err)
+			}
+			if se.Index != item.Index || se.Message == "" {
+				t.Errorf("expected error for step %d, got %+v", item.Index, se)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package crop
+
+import (
+	"fmt"
+	"github.com/asatisomnath/ProgImage/Service"
+	"image"
+	"image/draw"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var _ Service.Sizer = Crop{}
+
+// OutsideError is returned for a crop that misses the image altogether.
+type OutsideError struct {
+	Width, Height int // of the image
+}
+
+func (e *OutsideError) Error() string {
+	return fmt.Sprintf("crop is outside the %dx%d image", e.Width, e.Height)
+}
+
+// Crop is a ProgImage.Transformer that cuts out a Width by Height rectangle at X, Y from the top left of the image,
+// or from its centre when Centered is set. The rectangle is clipped to the image.
+type Crop struct {
+	X, Y          int
+	Width, Height int
+	Centered      bool
+}
+
+// Parse creates a Crop from named arguments, x, y, w and h, or size as WxH for a centred crop, validating it.
+func Parse(args map[string]string) (Crop, error) {
+	c := Crop{}
+	if size, ok := args["size"]; ok {
+		if len(args) != 1 {
+			return c, errors.New("size can't be combined with other arguments")
+		}
+		wh := strings.SplitN(strings.ToLower(size), "x", 2)
+		if len(wh) != 2 {
+			return c, errors.New("size must be WxH, eg 400x300")
+		}
+		args = map[string]string{"w": wh[0], "h": wh[1]}
+		c.Centered = true
+	}
+
+	for k, v := range args {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return c, errors.Errorf("%s must be an integer", k)
+		}
+		switch k {
+		case "x":
+			c.X = n
+		case "y":
+			c.Y = n
+		case "w":
+			c.Width = n
+		case "h":
+			c.Height = n
+		default:
+			return c, errors.Errorf("unknown argument %q", k)
+		}
+	}
+	return c, c.Validate()
+}
+
+// ParseRect creates a Crop from x,y,w,h.
+func ParseRect(s string) (Crop, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return Crop{}, errors.New("crop must be x,y,w,h")
+	}
+	return Parse(map[string]string{"x": parts[0], "y": parts[1], "w": parts[2], "h": parts[3]})
+}
+
+// Validate checks the crop can be applied.
+func (c Crop) Validate() error {
+	if c.X < 0 || c.Y < 0 {
+		return errors.New("x and y can't be negative")
+	}
+	if c.Width < 1 || c.Height < 1 {
+		return errors.New("width and height must be at least 1")
+	}
+	return nil
+}
+
+// String describes the crop.
+func (c Crop) String() string {
+	if c.Centered {
+		return fmt.Sprintf("crop:size=%dx%d", c.Width, c.Height)
+	}
+	return fmt.Sprintf("crop:x=%d,y=%d,w=%d,h=%d", c.X, c.Y, c.Width, c.Height)
+}
+
+// Transform crops img, an *OutsideError is returned when the crop misses it.
+func (c Crop) Transform(img image.Image) (image.Image, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	r, err := c.rect(img.Bounds())
+	if err != nil {
+		return nil, err
+	}
+	return subImage(img, r), nil
+}
+
+// Size is the size of the crop of an image of size p. The decoded images share their pixels with the crop, so it
+// allocates nothing.
+func (c Crop) Size(p image.Point) (image.Point, int64, error) {
+	if err := c.Validate(); err != nil {
+		return p, 0, err
+	}
+	r, err := c.rect(image.Rectangle{Max: p})
+	if err != nil {
+		return p, 0, err
+	}
+	return r.Size(), 0, nil
+}
+
+// rect is the part of b cropped.
+func (c Crop) rect(b image.Rectangle) (image.Rectangle, error) {
+	x, y := c.X, c.Y
+	if c.Centered {
+		x, y = (b.Dx()-c.Width)/2, (b.Dy()-c.Height)/2
+		if x < 0 {
+			x = 0
+		}
+		if y < 0 {
+			y = 0
+		}
+	}
+	r := image.Rect(x, y, x+c.Width, y+c.Height).Add(b.Min).Intersect(b)
+	if r.Empty() {
+		return r, &OutsideError{Width: b.Dx(), Height: b.Dy()}
+	}
+	return r, nil
+}
+
+// subImage returns the part of img within r, sharing pixels when the image type allows.
+func subImage(img image.Image, r image.Rectangle) image.Image {
+	if s, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		return s.SubImage(r)
+	}
+	rgba := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, r.Min, draw.Src)
+	return rgba
+}
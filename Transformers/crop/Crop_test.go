@@ -0,0 +1,179 @@
+package crop_test
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+
+	"github.com/asatisomnath/ProgImage/Transformers/crop"
+)
+
+// uniform creates a w by h image filled with c.
+func uniform(w, h int, c color.Color) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, img.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+	return img
+}
+
+// checkerboard fills r of img with black and white squares of side n.
+func checkerboard(img draw.Image, r image.Rectangle, n int) {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if ((x-r.Min.X)/n+(y-r.Min.Y)/n)%2 == 0 {
+				img.Set(x, y, color.Black)
+			} else {
+				img.Set(x, y, color.White)
+			}
+		}
+	}
+}
+
+func TestCrop(t *testing.T) {
+	src := uniform(400, 200, color.White)
+	src.Set(10, 20, color.Black)
+
+	tests := []struct {
+		Name   string
+		Crop   crop.Crop
+		Bounds image.Rectangle
+	}{
+		{Name: "exact", Crop: crop.Crop{X: 10, Y: 20, Width: 100, Height: 50}, Bounds: image.Rect(10, 20, 110, 70)},
+		{Name: "clipped", Crop: crop.Crop{X: 350, Y: 150, Width: 100, Height: 100}, Bounds: image.Rect(350, 150, 400, 200)},
+		{Name: "centered", Crop: crop.Crop{Width: 100, Height: 100, Centered: true}, Bounds: image.Rect(150, 50, 250, 150)},
+		{Name: "centered larger", Crop: crop.Crop{Width: 100, Height: 300, Centered: true}, Bounds: image.Rect(150, 0, 250, 200)},
+	}
+	for _, item := range tests {
+		t.Run(item.Name, func(t *testing.T) {
+			out, err := item.Crop.Transform(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b := out.Bounds(); b != item.Bounds {
+				t.Errorf("expected %v, got %v", item.Bounds, b)
+			}
+			if p, _, err := item.Crop.Size(src.Bounds().Size()); err != nil || p != item.Bounds.Size() {
+				t.Errorf("expected the size of the crop, got %v, %v", p, err)
+			}
+		})
+	}
+
+	out, err := crop.Crop{X: 10, Y: 20, Width: 2, Height: 2}.Transform(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, _, _, _ := out.At(out.Bounds().Min.X, out.Bounds().Min.Y).RGBA(); r != 0 {
+		t.Errorf("expected the crop to start at the black pixel, got red %d", r)
+	}
+
+	if _, err := (crop.Crop{X: 400, Y: 0, Width: 10, Height: 10}).Transform(src); err == nil {
+		t.Error("expected error for crop outside the image")
+	} else if _, ok := err.(*crop.OutsideError); !ok {
+		t.Errorf("expected *crop.OutsideError, got %v", err)
+	}
+	if _, _, err := (crop.Crop{X: 400, Y: 0, Width: 10, Height: 10}).Size(src.Bounds().Size()); err == nil {
+		t.Error("expected error sizing a crop outside the image")
+	} else if _, ok := err.(*crop.OutsideError); !ok {
+		t.Errorf("expected *crop.OutsideError sizing it, got %v", err)
+	}
+}
+
+func TestSmartCrop(t *testing.T) {
+	// detail in the right third of a flat landscape image
+	src := uniform(600, 200, color.Gray{Y: 128})
+	checkerboard(src, image.Rect(420, 20, 580, 180), 10)
+
+	out, err := crop.SmartCrop{AspectWidth: 1, AspectHeight: 1}.Transform(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := out.Bounds()
+	if b.Dx() != 200 || b.Dy() != 200 {
+		t.Fatalf("expected 200x200, got %dx%d", b.Dx(), b.Dy())
+	}
+	if p, n, err := (crop.SmartCrop{AspectWidth: 1, AspectHeight: 1}).Size(src.Bounds().Size()); err != nil || p != b.Size() || n <= 0 {
+		t.Errorf("expected the size of the crop and the memory scoring takes, got %v taking %d, %v", p, n, err)
+	}
+	if b.Min.X < 380 || b.Max.X > 600 {
+		t.Errorf("expected the crop to cover the detail, got %v", b)
+	}
+
+	// resolved, the same region is cropped from anything else
+	tr, err := crop.SmartCrop{AspectWidth: 1, AspectHeight: 1}.Resolve(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, err = tr.Transform(uniform(600, 200, color.White)); err != nil {
+		t.Fatal(err)
+	} else if out.Bounds() != b {
+		t.Errorf("expected the resolved crop to be %v, got %v", b, out.Bounds())
+	}
+
+	// nothing to choose between, so the centre
+	out, err = crop.SmartCrop{AspectWidth: 1, AspectHeight: 2}.Transform(uniform(300, 400, color.White))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := out.Bounds(); b != image.Rect(50, 0, 250, 400) {
+		t.Errorf("expected a centred crop, got %v", b)
+	}
+
+	// already the right shape
+	out, err = crop.SmartCrop{AspectWidth: 16, AspectHeight: 9}.Transform(uniform(160, 90, color.White))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := out.Bounds(); b != image.Rect(0, 0, 160, 90) {
+		t.Errorf("expected the whole image, got %v", b)
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		Args     map[string]string
+		Expected string
+	}{
+		{Args: map[string]string{"x": "1", "y": "2", "w": "3", "h": "4"}, Expected: "crop:x=1,y=2,w=3,h=4"},
+		{Args: map[string]string{"w": "3", "h": "4"}, Expected: "crop:x=0,y=0,w=3,h=4"},
+		{Args: map[string]string{"size": "400X300"}, Expected: "crop:size=400x300"},
+		{Args: map[string]string{"size": "400"}},
+		{Args: map[string]string{"size": "400x300", "x": "1"}},
+		{Args: map[string]string{"x": "-1", "w": "1", "h": "1"}},
+		{Args: map[string]string{"w": "0", "h": "1"}},
+		{Args: map[string]string{"w": "a", "h": "1"}},
+		{Args: map[string]string{"w": "1", "h": "1", "z": "1"}},
+	}
+	for _, item := range tests {
+		c, err := crop.Parse(item.Args)
+		if item.Expected == "" {
+			if err == nil {
+				t.Errorf("expected error for %v", item.Args)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error for %v: %v", item.Args, err)
+		} else if c.String() != item.Expected {
+			t.Errorf("expected %s, got %s", item.Expected, c)
+		}
+	}
+
+	if c, err := crop.ParseRect("5,6,7,8"); err != nil || c != (crop.Crop{X: 5, Y: 6, Width: 7, Height: 8}) {
+		t.Errorf("unexpected crop %+v, %v", c, err)
+	}
+	if _, err := crop.ParseRect("5,6,7"); err == nil {
+		t.Error("expected error for missing height")
+	}
+
+	if sc, err := crop.ParseSmart(map[string]string{"aspect": "16:12"}); err != nil || sc.String() != "smartcrop:aspect=4:3" {
+		t.Errorf("unexpected smart crop %v, %v", sc, err)
+	}
+	for _, aspect := range []string{"16", "a:b", "0:1", "1000:1"} {
+		if _, err := crop.ParseAspect(aspect); err == nil {
+			t.Errorf("expected error for aspect %s", aspect)
+		}
+	}
+	if _, err := crop.ParseSmart(map[string]string{}); err == nil {
+		t.Error("expected error for missing aspect")
+	}
+}
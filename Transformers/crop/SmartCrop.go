@@ -0,0 +1,314 @@
+package crop
+
+import (
+	"fmt"
+	"github.com/asatisomnath/ProgImage/Service"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/asatisomnath/ProgImage/Transformers/resize"
+	"github.com/pkg/errors"
+)
+
+// analysisSize is the longest side images are scaled down to for scoring.
+const analysisSize = 256
+
+// entropyBlock is the side of the square blocks luminance entropy is measured over, in analysis pixels.
+const entropyBlock = 8
+
+// entropyBins is the number of luminance levels entropy is measured with.
+const entropyBins = 16
+
+var (
+	_ Service.Resolver = SmartCrop{}
+	_ Service.Sizer    = SmartCrop{}
+)
+
+// SmartCrop is a ProgImage.Transformer that crops images to the aspect ratio AspectWidth:AspectHeight, keeping as
+// much of the image as possible. The region kept is the one with the most edges and luminance entropy, favouring the
+// centre when there's nothing to choose between them.
+type SmartCrop struct {
+	AspectWidth  int
+	AspectHeight int
+}
+
+// ParseSmart creates a SmartCrop from named arguments, aspect as W:H, validating it.
+func ParseSmart(args map[string]string) (SmartCrop, error) {
+	for k := range args {
+		if k != "aspect" {
+			return SmartCrop{}, errors.Errorf("unknown argument %q", k)
+		}
+	}
+	aspect, ok := args["aspect"]
+	if !ok {
+		return SmartCrop{}, errors.New("aspect is required, eg 16:9")
+	}
+	return ParseAspect(aspect)
+}
+
+// ParseAspect creates a SmartCrop from an aspect ratio given as W:H.
+func ParseAspect(s string) (SmartCrop, error) {
+	wh := strings.SplitN(s, ":", 2)
+	if len(wh) != 2 {
+		return SmartCrop{}, errors.New("aspect must be W:H, eg 16:9")
+	}
+	w, errW := strconv.Atoi(wh[0])
+	h, errH := strconv.Atoi(wh[1])
+	if errW != nil || errH != nil {
+		return SmartCrop{}, errors.New("aspect must be W:H, eg 16:9")
+	}
+	sc := SmartCrop{AspectWidth: w, AspectHeight: h}
+	return sc, sc.Validate()
+}
+
+// Validate checks the smart crop can be applied.
+func (sc SmartCrop) Validate() error {
+	if sc.AspectWidth < 1 || sc.AspectHeight < 1 {
+		return errors.New("aspect must be positive")
+	}
+	if r := float64(sc.AspectWidth) / float64(sc.AspectHeight); r > 100 || r < 0.01 {
+		return errors.New("aspect must be between 1:100 and 100:1")
+	}
+	return nil
+}
+
+// String describes the smart crop, with the aspect ratio in its lowest terms.
+func (sc SmartCrop) String() string {
+	d := gcd(sc.AspectWidth, sc.AspectHeight)
+	if d == 0 {
+		d = 1
+	}
+	return fmt.Sprintf("smartcrop:aspect=%d:%d", sc.AspectWidth/d, sc.AspectHeight/d)
+}
+
+// Transform crops img to the aspect ratio.
+func (sc SmartCrop) Transform(img image.Image) (image.Image, error) {
+	if err := sc.Validate(); err != nil {
+		return nil, err
+	}
+	r, ok := sc.region(img)
+	if !ok {
+		return img, nil
+	}
+	return subImage(img, r), nil
+}
+
+// Resolve picks the region of img to keep, returning the Crop of it so the same region can be cut from other images,
+// eg every frame of an animation.
+func (sc SmartCrop) Resolve(img image.Image) (Service.Transformer, error) {
+	if err := sc.Validate(); err != nil {
+		return nil, err
+	}
+	b := img.Bounds()
+	r, ok := sc.region(img)
+	if !ok {
+		r = b
+	}
+	r = r.Sub(b.Min)
+	return Crop{X: r.Min.X, Y: r.Min.Y, Width: r.Dx(), Height: r.Dy()}, nil
+}
+
+// Size is the size of the crop of an image of size p. The crop shares the pixels of the decoded images, only scoring
+// them allocates.
+func (sc SmartCrop) Size(p image.Point) (image.Point, int64, error) {
+	if err := sc.Validate(); err != nil {
+		return p, 0, err
+	}
+	if p.X == 0 || p.Y == 0 {
+		return p, 0, nil
+	}
+	cw, ch := sc.size(p.X, p.Y)
+	if cw == p.X && ch == p.Y {
+		return p, 0, nil
+	}
+	aw, ah := analysis(p.X, p.Y)
+	_, n, err := resize.Resize{Width: aw, Height: ah, Fit: resize.FitFill, Filter: resize.Bilinear}.Size(p)
+	if err != nil {
+		return p, 0, err
+	}
+	// the luminance and scores of the scaled image, as float64s
+	return image.Pt(cw, ch), n + int64(aw)*int64(ah)*16, nil
+}
+
+// size is the size of the largest region of an sw by sh image with the aspect ratio.
+func (sc SmartCrop) size(sw, sh int) (int, int) {
+	aspect := float64(sc.AspectWidth) / float64(sc.AspectHeight)
+	if float64(sw)/float64(sh) > aspect {
+		return clampInt(int(math.Round(float64(sh)*aspect)), 1, sw), sh
+	}
+	return sw, clampInt(int(math.Round(float64(sw)/aspect)), 1, sh)
+}
+
+// analysis is the size an sw by sh image is scaled down to for scoring.
+func analysis(sw, sh int) (int, int) {
+	scale := math.Min(1, analysisSize/float64(maxInt(sw, sh)))
+	return clampInt(int(math.Round(float64(sw)*scale)), 1, sw), clampInt(int(math.Round(float64(sh)*scale)), 1, sh)
+}
+
+// region finds the region of img to keep, ok is false when it's all kept.
+func (sc SmartCrop) region(img image.Image) (image.Rectangle, bool) {
+	b := img.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	if sw == 0 || sh == 0 {
+		return b, false
+	}
+
+	// the largest region with the aspect ratio spans the image one way, it only moves along the other
+	cw, ch := sc.size(sw, sh)
+	if cw == sw && ch == sh {
+		return b, false
+	}
+
+	aw, ah := analysis(sw, sh)
+	scores := score(resize.Scale(img, aw, ah, resize.Bilinear))
+
+	// sum the scores across the axis the region spans
+	horizontal := cw < sw
+	n := ah
+	if horizontal {
+		n = aw
+	}
+	lines := make([]float64, n)
+	for y := 0; y < ah; y++ {
+		for x := 0; x < aw; x++ {
+			if horizontal {
+				lines[x] += scores[y*aw+x]
+			} else {
+				lines[y] += scores[y*aw+x]
+			}
+		}
+	}
+
+	full, size := sw, cw
+	if !horizontal {
+		full, size = sh, ch
+	}
+	window := clampInt(int(math.Round(float64(size)*float64(n)/float64(full))), 1, n)
+	offset := bestWindow(lines, window)
+
+	pos := clampInt(int(math.Round(float64(offset)*float64(full)/float64(n))), 0, full-size)
+	r := image.Rect(0, pos, cw, pos+ch)
+	if horizontal {
+		r = image.Rect(pos, 0, pos+cw, ch)
+	}
+	return r.Add(b.Min), true
+}
+
+// bestWindow finds the offset of the run of window lines with the highest total, preferring the most central of
+// equally good runs.
+func bestWindow(lines []float64, window int) int {
+	var sum float64
+	for _, v := range lines[:window] {
+		sum += v
+	}
+
+	const epsilon = 1e-9
+	center := float64(len(lines)-window) / 2
+	best, bestSum := 0, sum
+	for i := 1; i+window <= len(lines); i++ {
+		sum += lines[i+window-1] - lines[i-1]
+		switch {
+		case sum > bestSum+epsilon:
+			best, bestSum = i, sum
+		case sum > bestSum-epsilon && math.Abs(float64(i)-center) < math.Abs(float64(best)-center):
+			best, bestSum = i, sum
+		}
+	}
+	return best
+}
+
+// score rates how interesting each pixel of img is from its edges and the entropy of the luminance around it. Each
+// measure is normalised to sum to 1 over the image so they carry equal weight.
+func score(img *image.RGBA) []float64 {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	lum := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		row := img.Pix[y*img.Stride:]
+		for x := 0; x < w; x++ {
+			p := row[x*4:]
+			// Rec. 601 luma, pixels are premultiplied so transparent areas are dark
+			lum[y*w+x] = (0.299*float64(p[0]) + 0.587*float64(p[1]) + 0.114*float64(p[2])) / 255
+		}
+	}
+	at := func(x, y int) float64 {
+		return lum[clampInt(y, 0, h-1)*w+clampInt(x, 0, w-1)]
+	}
+
+	edges := make([]float64, w*h)
+	var edgeTotal float64
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			// sobel
+			gx := at(x+1, y-1) + 2*at(x+1, y) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x-1, y) - at(x-1, y+1)
+			gy := at(x-1, y+1) + 2*at(x, y+1) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x, y-1) - at(x+1, y-1)
+			e := math.Sqrt(gx*gx + gy*gy)
+			edges[y*w+x] = e
+			edgeTotal += e
+		}
+	}
+
+	entropy := make([]float64, w*h)
+	var entropyTotal float64
+	for by := 0; by < h; by += entropyBlock {
+		for bx := 0; bx < w; bx += entropyBlock {
+			var hist [entropyBins]int
+			n := 0
+			for y := by; y < by+entropyBlock && y < h; y++ {
+				for x := bx; x < bx+entropyBlock && x < w; x++ {
+					hist[clampInt(int(lum[y*w+x]*entropyBins), 0, entropyBins-1)]++
+					n++
+				}
+			}
+			var e float64
+			for _, c := range hist {
+				if c > 0 {
+					p := float64(c) / float64(n)
+					e -= p * math.Log2(p)
+				}
+			}
+			for y := by; y < by+entropyBlock && y < h; y++ {
+				for x := bx; x < bx+entropyBlock && x < w; x++ {
+					entropy[y*w+x] = e
+					entropyTotal += e
+				}
+			}
+		}
+	}
+
+	scores := make([]float64, w*h)
+	for i := range scores {
+		if edgeTotal > 0 {
+			scores[i] += edges[i] / edgeTotal
+		}
+		if entropyTotal > 0 {
+			scores[i] += entropy[i] / entropyTotal
+		}
+	}
+	return scores
+}
+
+func clampInt(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
@@ -0,0 +1,87 @@
+package orient
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// HeaderSize is how much of the start of a JPEG ReadOrientation needs to find the EXIF orientation, it's always in
+// one of the first couple of segments and a segment is at most 64kb.
+const HeaderSize = 128 * 1024 // 128kb
+
+const tagOrientation = 0x0112
+
+// ReadOrientation finds the EXIF orientation in the start of a JPEG, data. It's 1, upright, when there isn't one or
+// it can't be read.
+func ReadOrientation(data []byte) Orientation {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+
+	for p := 2; p+4 <= len(data); {
+		if data[p] != 0xFF {
+			return 1
+		}
+		marker := data[p+1]
+		if marker == 0xFF { // fill byte
+			p++
+			continue
+		}
+		if marker == 0xDA || marker == 0xD9 { // image data or the end, there's no EXIF after them
+			return 1
+		}
+		size := int(binary.BigEndian.Uint16(data[p+2:]))
+		if size < 2 {
+			return 1
+		}
+		end := p + 2 + size
+		if end > len(data) {
+			end = len(data)
+		}
+		if seg := data[p+4 : end]; marker == 0xE1 && bytes.HasPrefix(seg, []byte("Exif\x00\x00")) {
+			return tiffOrientation(seg[6:])
+		}
+		p += 2 + size
+	}
+	return 1
+}
+
+// tiffOrientation finds the orientation tag in the first IFD of the TIFF structure EXIF is stored in.
+func tiffOrientation(tiff []byte) Orientation {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	n := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < n; i++ {
+		e := ifd + 2 + i*12
+		if e+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[e:]) != tagOrientation {
+			continue
+		}
+		// a single SHORT, stored at the start of the value
+		if order.Uint16(tiff[e+2:]) != 3 || order.Uint32(tiff[e+4:]) != 1 {
+			return 1
+		}
+		if o := Orientation(order.Uint16(tiff[e+8:])); o >= 1 && o <= 8 {
+			return o
+		}
+		return 1
+	}
+	return 1
+}
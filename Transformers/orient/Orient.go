@@ -0,0 +1,252 @@
+package orient
+
+import (
+	"fmt"
+	"github.com/asatisomnath/ProgImage/Service"
+	"image"
+	"image/draw"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Direction is the direction an image is flipped in.
+type Direction string
+
+const (
+	// Horizontal mirrors the image left to right.
+	Horizontal Direction = "h"
+	// Vertical mirrors the image top to bottom.
+	Vertical Direction = "v"
+)
+
+var (
+	_ Service.Sizer = Rotate{}
+	_ Service.Sizer = Flip{}
+	_ Service.Sizer = Orientation(1)
+	_ Service.Sizer = Keep
+)
+
+// Rotate is a ProgImage.Transformer that turns images clockwise by Degrees, one of 90, 180 or 270.
+type Rotate struct {
+	Degrees int
+}
+
+// ParseRotate creates a Rotate from named arguments, degrees, validating it.
+func ParseRotate(args map[string]string) (Rotate, error) {
+	r := Rotate{}
+	for k, v := range args {
+		if k != "degrees" {
+			return r, errors.Errorf("unknown argument %q", k)
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return r, errors.New("degrees must be 90, 180 or 270")
+		}
+		r.Degrees = n
+	}
+	return r, r.Validate()
+}
+
+// Validate checks the rotation can be applied.
+func (r Rotate) Validate() error {
+	switch r.Degrees {
+	case 90, 180, 270:
+		return nil
+	}
+	return errors.New("degrees must be 90, 180 or 270")
+}
+
+// String describes the rotation.
+func (r Rotate) String() string {
+	return fmt.Sprintf("rotate:degrees=%d", r.Degrees)
+}
+
+// Transform rotates img.
+func (r Rotate) Transform(img image.Image) (image.Image, error) {
+	if err := r.Validate(); err != nil {
+		return nil, err
+	}
+	switch r.Degrees {
+	case 90:
+		return Orientation(6).Transform(img)
+	case 180:
+		return Orientation(3).Transform(img)
+	default:
+		return Orientation(8).Transform(img)
+	}
+}
+
+// Size is the size of an image of size p rotated.
+func (r Rotate) Size(p image.Point) (image.Point, int64, error) {
+	if err := r.Validate(); err != nil {
+		return p, 0, err
+	}
+	switch r.Degrees {
+	case 90:
+		return Orientation(6).Size(p)
+	case 180:
+		return Orientation(3).Size(p)
+	default:
+		return Orientation(8).Size(p)
+	}
+}
+
+// Flip is a ProgImage.Transformer that mirrors images in Direction.
+type Flip struct {
+	Direction Direction
+}
+
+// ParseFlip creates a Flip from named arguments, dir as h or v, validating it.
+func ParseFlip(args map[string]string) (Flip, error) {
+	f := Flip{}
+	for k, v := range args {
+		if k != "dir" {
+			return f, errors.Errorf("unknown argument %q", k)
+		}
+		f.Direction = Direction(strings.ToLower(v))
+	}
+	return f, f.Validate()
+}
+
+// Validate checks the flip can be applied.
+func (f Flip) Validate() error {
+	if f.Direction != Horizontal && f.Direction != Vertical {
+		return errors.New("dir must be h or v")
+	}
+	return nil
+}
+
+// String describes the flip.
+func (f Flip) String() string {
+	return fmt.Sprintf("flip:dir=%s", f.Direction)
+}
+
+// Transform flips img.
+func (f Flip) Transform(img image.Image) (image.Image, error) {
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+	if f.Direction == Horizontal {
+		return Orientation(2).Transform(img)
+	}
+	return Orientation(4).Transform(img)
+}
+
+// Size is the size of an image of size p flipped, the same.
+func (f Flip) Size(p image.Point) (image.Point, int64, error) {
+	if err := f.Validate(); err != nil {
+		return p, 0, err
+	}
+	return Orientation(2).Size(p)
+}
+
+// Orientation is a ProgImage.Transformer that corrects images stored with an EXIF orientation, from 1 for upright to
+// 8, so they're displayed upright without it. Values out of range are left as they are.
+type Orientation int
+
+// String describes the correction.
+func (o Orientation) String() string {
+	return fmt.Sprintf("orient:exif=%d", int(o))
+}
+
+// Transform corrects img.
+func (o Orientation) Transform(img image.Image) (image.Image, error) {
+	if o < 2 || o > 8 {
+		return img, nil
+	}
+
+	src := toNRGBA(img)
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	dw, dh := sw, sh
+	if o >= 5 {
+		dw, dh = sh, sw
+	}
+
+	// at maps a pixel of the corrected image to the one it comes from
+	var at func(x, y int) (int, int)
+	switch o {
+	case 2: // mirrored
+		at = func(x, y int) (int, int) { return sw - 1 - x, y }
+	case 3: // upside down
+		at = func(x, y int) (int, int) { return sw - 1 - x, sh - 1 - y }
+	case 4: // mirrored upside down
+		at = func(x, y int) (int, int) { return x, sh - 1 - y }
+	case 5: // mirrored on its side
+		at = func(x, y int) (int, int) { return y, x }
+	case 6: // on its left side
+		at = func(x, y int) (int, int) { return y, sh - 1 - x }
+	case 7: // mirrored on its right side
+		at = func(x, y int) (int, int) { return sw - 1 - y, sh - 1 - x }
+	default: // on its right side
+		at = func(x, y int) (int, int) { return sw - 1 - y, x }
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		row := dst.Pix[y*dst.Stride:]
+		for x := 0; x < dw; x++ {
+			sx, sy := at(x, y)
+			copy(row[x*4:x*4+4], src.Pix[sy*src.Stride+sx*4:])
+		}
+	}
+	return dst, nil
+}
+
+// Size is the size of an image of size p corrected. It's copied as NRGBA to be turned into another.
+func (o Orientation) Size(p image.Point) (image.Point, int64, error) {
+	if o < 2 || o > 8 {
+		return p, 0, nil
+	}
+	n := int64(p.X) * int64(p.Y) * 4 * 2
+	if o >= 5 {
+		return image.Pt(p.Y, p.X), n, nil
+	}
+	return p, n, nil
+}
+
+// Keep is a ProgImage.Transformer that leaves images as they are. Passing it to a ProgImage.ImageTypeConverter opts
+// out of correcting the EXIF orientation of the image.
+var Keep = keep{}
+
+type keep struct{}
+
+// ParseOrient creates the orient step from named arguments, mode, which can only be keep.
+func ParseOrient(args map[string]string) (Service.Transformer, error) {
+	for k, v := range args {
+		if k != "mode" {
+			return nil, errors.Errorf("unknown argument %q", k)
+		}
+		if strings.ToLower(v) != "keep" {
+			return nil, errors.New("mode must be keep")
+		}
+	}
+	if len(args) == 0 {
+		return nil, errors.New("mode is required, eg orient:keep")
+	}
+	return Keep, nil
+}
+
+func (keep) String() string {
+	return "orient:keep"
+}
+
+func (keep) Transform(img image.Image) (image.Image, error) {
+	return img, nil
+}
+
+func (keep) Size(p image.Point) (image.Point, int64, error) {
+	return p, 0, nil
+}
+
+// toNRGBA returns img as an NRGBA with bounds starting at 0, 0, converting it if needed.
+func toNRGBA(img image.Image) *image.NRGBA {
+	if nrgba, ok := img.(*image.NRGBA); ok && nrgba.Bounds().Min == (image.Point{}) {
+		return nrgba
+	}
+	b := img.Bounds()
+	nrgba := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(nrgba, nrgba.Bounds(), img, b.Min, draw.Src)
+	return nrgba
+}
@@ -0,0 +1,198 @@
+package orient_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"github.com/asatisomnath/ProgImage/Service"
+	"github.com/asatisomnath/ProgImage/Transformers/orient"
+)
+
+// marked creates a 3x2 image with distinct pixels, it's
+//
+//	0 1 2
+//	3 4 5
+//
+// with the red channel holding the number.
+func marked() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 3; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(y*3 + x), A: 255})
+		}
+	}
+	return img
+}
+
+// layout reads back the numbers of a transformed marked image, row by row.
+func layout(img image.Image) [][]uint8 {
+	b := img.Bounds()
+	var ret [][]uint8
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		var row []uint8
+		for x := b.Min.X; x < b.Max.X; x++ {
+			row = append(row, color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA).R)
+		}
+		ret = append(ret, row)
+	}
+	return ret
+}
+
+func TestTransform(t *testing.T) {
+	tests := []struct {
+		Name        string
+		Transformer Service.Sizer
+		Layout      [][]uint8
+	}{
+		{Name: "exif 1", Transformer: orient.Orientation(1), Layout: [][]uint8{{0, 1, 2}, {3, 4, 5}}},
+		{Name: "exif 2", Transformer: orient.Orientation(2), Layout: [][]uint8{{2, 1, 0}, {5, 4, 3}}},
+		{Name: "exif 3", Transformer: orient.Orientation(3), Layout: [][]uint8{{5, 4, 3}, {2, 1, 0}}},
+		{Name: "exif 4", Transformer: orient.Orientation(4), Layout: [][]uint8{{3, 4, 5}, {0, 1, 2}}},
+		{Name: "exif 5", Transformer: orient.Orientation(5), Layout: [][]uint8{{0, 3}, {1, 4}, {2, 5}}},
+		{Name: "exif 6", Transformer: orient.Orientation(6), Layout: [][]uint8{{3, 0}, {4, 1}, {5, 2}}},
+		{Name: "exif 7", Transformer: orient.Orientation(7), Layout: [][]uint8{{5, 2}, {4, 1}, {3, 0}}},
+		{Name: "exif 8", Transformer: orient.Orientation(8), Layout: [][]uint8{{2, 5}, {1, 4}, {0, 3}}},
+		{Name: "exif 9", Transformer: orient.Orientation(9), Layout: [][]uint8{{0, 1, 2}, {3, 4, 5}}},
+		{Name: "rotate 90", Transformer: orient.Rotate{Degrees: 90}, Layout: [][]uint8{{3, 0}, {4, 1}, {5, 2}}},
+		{Name: "rotate 180", Transformer: orient.Rotate{Degrees: 180}, Layout: [][]uint8{{5, 4, 3}, {2, 1, 0}}},
+		{Name: "rotate 270", Transformer: orient.Rotate{Degrees: 270}, Layout: [][]uint8{{2, 5}, {1, 4}, {0, 3}}},
+		{Name: "flip h", Transformer: orient.Flip{Direction: orient.Horizontal}, Layout: [][]uint8{{2, 1, 0}, {5, 4, 3}}},
+		{Name: "flip v", Transformer: orient.Flip{Direction: orient.Vertical}, Layout: [][]uint8{{3, 4, 5}, {0, 1, 2}}},
+		{Name: "keep", Transformer: orient.Keep, Layout: [][]uint8{{0, 1, 2}, {3, 4, 5}}},
+	}
+	for _, item := range tests {
+		t.Run(item.Name, func(t *testing.T) {
+			out, err := item.Transformer.Transform(marked())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if l := layout(out); !equal(l, item.Layout) {
+				t.Errorf("expected %v, got %v", item.Layout, l)
+			}
+			if p, _, err := item.Transformer.Size(marked().Bounds().Size()); err != nil || p != out.Bounds().Size() {
+				t.Errorf("expected the size of the output, got %v, %v", p, err)
+			}
+		})
+	}
+
+	// sub images are read from their own bounds
+	sub := marked().SubImage(image.Rect(1, 0, 3, 2))
+	out, err := orient.Rotate{Degrees: 90}.Transform(sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := layout(out); !equal(l, [][]uint8{{4, 1}, {5, 2}}) {
+		t.Errorf("unexpected sub image rotation %v", l)
+	}
+
+	if _, err := (orient.Rotate{Degrees: 45}).Transform(marked()); err == nil {
+		t.Error("expected error rotating by 45")
+	}
+}
+
+func TestParse(t *testing.T) {
+	if r, err := orient.ParseRotate(map[string]string{"degrees": "270"}); err != nil || r.String() != "rotate:degrees=270" {
+		t.Errorf("unexpected rotate %v, %v", r, err)
+	}
+	if f, err := orient.ParseFlip(map[string]string{"dir": "V"}); err != nil || f.String() != "flip:dir=v" {
+		t.Errorf("unexpected flip %v, %v", f, err)
+	}
+	if o, err := orient.ParseOrient(map[string]string{"mode": "keep"}); err != nil || o != orient.Keep {
+		t.Errorf("unexpected orient %v, %v", o, err)
+	}
+
+	for _, args := range []map[string]string{{}, {"degrees": "-90"}, {"degrees": "x"}, {"deg": "90"}} {
+		if _, err := orient.ParseRotate(args); err == nil {
+			t.Errorf("expected error rotating with %v", args)
+		}
+	}
+	for _, args := range []map[string]string{{}, {"dir": "d"}, {"direction": "h"}} {
+		if _, err := orient.ParseFlip(args); err == nil {
+			t.Errorf("expected error flipping with %v", args)
+		}
+	}
+	for _, args := range []map[string]string{{}, {"mode": "auto"}, {"keep": "1"}} {
+		if _, err := orient.ParseOrient(args); err == nil {
+			t.Errorf("expected error orienting with %v", args)
+		}
+	}
+}
+
+// exifJPEG encodes img as a JPEG with an EXIF orientation, in order byte order. A JFIF segment comes first as it does
+// from most cameras.
+func exifJPEG(t *testing.T, img image.Image, o uint16, order binary.ByteOrder) []byte {
+	buf := bytes.Buffer{}
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	enc := buf.Bytes()
+
+	tiff := make([]byte, 8+2+12+4)
+	if order == binary.LittleEndian {
+		copy(tiff, "II*\x00")
+	} else {
+		copy(tiff, "MM\x00*")
+	}
+	order.PutUint32(tiff[4:], 8)
+	order.PutUint16(tiff[8:], 1)
+	order.PutUint16(tiff[10:], 0x0112)
+	order.PutUint16(tiff[12:], 3)
+	order.PutUint32(tiff[14:], 1)
+	order.PutUint16(tiff[18:], o)
+	app1 := append([]byte("Exif\x00\x00"), tiff...)
+
+	out := []byte{0xFF, 0xD8}
+	out = append(out, 0xFF, 0xE0, 0x00, 0x10)
+	out = append(out, "JFIF\x00\x01\x01\x00\x00\x01\x00\x01\x00\x00"...)
+	out = append(out, 0xFF, 0xE1, byte((len(app1)+2)>>8), byte(len(app1)+2))
+	out = append(out, app1...)
+	return append(out, enc[2:]...)
+}
+
+func TestReadOrientation(t *testing.T) {
+	img := marked()
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		for o := uint16(1); o <= 8; o++ {
+			data := exifJPEG(t, img, o, order)
+			if got := orient.ReadOrientation(data); got != orient.Orientation(o) {
+				t.Errorf("expected orientation %d in %v, got %d", o, order, got)
+			}
+			if _, err := jpeg.Decode(bytes.NewReader(data)); err != nil {
+				t.Fatalf("test JPEG doesn't decode, %v", err)
+			}
+		}
+	}
+
+	buf := bytes.Buffer{}
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string][]byte{
+		"no exif":   buf.Bytes(),
+		"not jpeg":  []byte("\x89PNG\r\n\x1a\n"),
+		"empty":     nil,
+		"truncated": exifJPEG(t, img, 6, binary.BigEndian)[:30],
+		"bad value": exifJPEG(t, img, 12, binary.BigEndian),
+	}
+	for name, data := range tests {
+		if got := orient.ReadOrientation(data); got != 1 {
+			t.Errorf("expected orientation 1 for %s, got %d", name, got)
+		}
+	}
+}
+
+func equal(a, b [][]uint8) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,104 @@
+package resize
+
+import (
+	"math"
+)
+
+// Filter is a resampling filter. Kernel gives the weight of a source pixel at distance x, it's zero beyond Support.
+// A nil Kernel samples the nearest source pixel.
+type Filter struct {
+	Name    string
+	Support float64
+	Kernel  func(x float64) float64
+}
+
+// Nearest picks the nearest source pixel, it's the fastest filter and keeps hard edges.
+var Nearest = Filter{Name: "nearest"}
+
+// Bilinear interpolates linearly between neighbouring pixels.
+var Bilinear = Filter{Name: "bilinear", Support: 1, Kernel: func(x float64) float64 {
+	x = math.Abs(x)
+	if x < 1 {
+		return 1 - x
+	}
+	return 0
+}}
+
+// CatmullRom is a sharp cubic filter.
+var CatmullRom = Filter{Name: "catmullrom", Support: 2, Kernel: func(x float64) float64 {
+	x = math.Abs(x)
+	switch {
+	case x < 1:
+		return 1.5*x*x*x - 2.5*x*x + 1
+	case x < 2:
+		return -0.5*x*x*x + 2.5*x*x - 4*x + 2
+	}
+	return 0
+}}
+
+// Lanczos3 is a windowed sinc filter with 3 lobes, it gives the best quality at the highest cost.
+var Lanczos3 = Filter{Name: "lanczos3", Support: 3, Kernel: func(x float64) float64 {
+	x = math.Abs(x)
+	if x < 3 {
+		return sinc(x) * sinc(x/3)
+	}
+	return 0
+}}
+
+// Filters are the filters by name.
+var Filters = map[string]Filter{
+	Nearest.Name:    Nearest,
+	Bilinear.Name:   Bilinear,
+	CatmullRom.Name: CatmullRom,
+	Lanczos3.Name:   Lanczos3,
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	x *= math.Pi
+	return math.Sin(x) / x
+}
+
+// contribution is the weighted run of source pixels that make up a destination pixel.
+type contribution struct {
+	start   int
+	weights []float32
+}
+
+// contributions works out which source pixels contribute to each destination pixel when scaling src pixels to dst.
+// When shrinking the filter is widened so every source pixel is taken into account.
+func contributions(dst, src int, f Filter) []contribution {
+	scale := float64(src) / float64(dst)
+	filterScale := math.Max(scale, 1)
+	support := f.Support * filterScale
+
+	ret := make([]contribution, dst)
+	for i := range ret {
+		center := (float64(i) + 0.5) * scale
+		left := int(math.Floor(center - support))
+		if left < 0 {
+			left = 0
+		}
+		right := int(math.Ceil(center + support))
+		if right > src {
+			right = src
+		}
+
+		weights := make([]float32, right-left)
+		var sum float64
+		for j := left; j < right; j++ {
+			w := f.Kernel((float64(j) + 0.5 - center) / filterScale)
+			weights[j-left] = float32(w)
+			sum += w
+		}
+		if sum != 0 {
+			for j := range weights {
+				weights[j] /= float32(sum)
+			}
+		}
+		ret[i] = contribution{start: left, weights: weights}
+	}
+	return ret
+}
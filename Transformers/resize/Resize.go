@@ -0,0 +1,309 @@
+package resize
+
+import (
+	"fmt"
+	"github.com/asatisomnath/ProgImage/Service"
+	"image"
+	"image/draw"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// MaxDimension is the largest width or height an image can be resized to.
+const MaxDimension = 10000
+
+// Fit is how an image is fitted to both a width and a height.
+type Fit string
+
+const (
+	// FitCover scales the image to cover the size, keeping its aspect ratio, and crops what's left over.
+	FitCover Fit = "cover"
+	// FitContain scales the image to fit within the size, keeping its aspect ratio, and pads it with transparency.
+	FitContain Fit = "contain"
+	// FitFill stretches the image to the size.
+	FitFill Fit = "fill"
+	// FitInside scales the image to fit within the size, keeping its aspect ratio, so it may be smaller.
+	FitInside Fit = "inside"
+)
+
+// Fits are the valid fits.
+var Fits = map[Fit]bool{FitCover: true, FitContain: true, FitFill: true, FitInside: true}
+
+var _ Service.Sizer = Resize{}
+
+// Resize is a ProgImage.Transformer that scales images to Width and Height. When only one is set the other follows
+// the aspect ratio of the image and Fit doesn't apply. Fit defaults to FitCover and Filter to Lanczos3.
+type Resize struct {
+	Width  int
+	Height int
+	Fit    Fit
+	Filter Filter
+}
+
+// Parse creates a Resize from named arguments, w, h, fit and filter, validating it.
+func Parse(args map[string]string) (Resize, error) {
+	r := Resize{}
+	for k, v := range args {
+		switch k {
+		case "w", "h":
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return r, errors.Errorf("%s must be an integer", k)
+			}
+			if k == "w" {
+				r.Width = n
+			} else {
+				r.Height = n
+			}
+		case "fit":
+			r.Fit = Fit(strings.ToLower(v))
+		case "filter":
+			f, ok := Filters[strings.ToLower(v)]
+			if !ok {
+				return r, errors.Errorf("unknown filter %q", v)
+			}
+			r.Filter = f
+		default:
+			return r, errors.Errorf("unknown argument %q", k)
+		}
+	}
+	return r, r.Validate()
+}
+
+// Validate checks the resize can be applied.
+func (r Resize) Validate() error {
+	if r.Width < 0 || r.Height < 0 || r.Width > MaxDimension || r.Height > MaxDimension {
+		return errors.Errorf("width and height must be between 1 and %d", MaxDimension)
+	}
+	if r.Width == 0 && r.Height == 0 {
+		return errors.New("width or height is required")
+	}
+	if r.Fit != "" && !Fits[r.Fit] {
+		return errors.Errorf("unknown fit %q", r.Fit)
+	}
+	if r.Filter.Name != "" {
+		if _, ok := Filters[r.Filter.Name]; !ok {
+			return errors.Errorf("unknown filter %q", r.Filter.Name)
+		}
+	}
+	return nil
+}
+
+// String describes the resize with its defaults filled in.
+func (r Resize) String() string {
+	r = r.withDefaults()
+	return fmt.Sprintf("resize:w=%d,h=%d,fit=%s,filter=%s", r.Width, r.Height, r.Fit, r.Filter.Name)
+}
+
+// Transform scales img. The image it's scaled to and the output are held to Service.ImageLimits, a *Service.LimitError
+// is returned before anything is scaled when either is over them.
+func (r Resize) Transform(img image.Image) (image.Image, error) {
+	if err := r.Validate(); err != nil {
+		return nil, err
+	}
+	r = r.withDefaults()
+
+	b := img.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	if sw == 0 || sh == 0 {
+		return img, nil
+	}
+	s, out := r.size(sw, sh)
+	if err := checkLimits(s, out); err != nil {
+		return nil, err
+	}
+
+	scaledImg := Scale(img, s.X, s.Y, r.Filter)
+	switch {
+	case r.Width == 0 || r.Height == 0 || r.Fit == FitFill || r.Fit == FitInside:
+		return scaledImg, nil
+	case r.Fit == FitCover:
+		x, y := (s.X-out.X)/2, (s.Y-out.Y)/2
+		return scaledImg.SubImage(image.Rect(x, y, x+out.X, y+out.Y)), nil
+	default: // FitContain
+		canvas := image.NewRGBA(image.Rect(0, 0, out.X, out.Y))
+		x, y := (out.X-s.X)/2, (out.Y-s.Y)/2
+		draw.Draw(canvas, image.Rect(x, y, x+s.X, y+s.Y), scaledImg, image.Point{}, draw.Src)
+		return canvas, nil
+	}
+}
+
+// Size is the size an image of size p is resized to. It's copied as RGBA to be scaled, horizontally into a buffer of
+// float32s then vertically, and padded onto a canvas to contain it. A *Service.LimitError is returned as Transform
+// does.
+func (r Resize) Size(p image.Point) (image.Point, int64, error) {
+	if err := r.Validate(); err != nil {
+		return p, 0, err
+	}
+	r = r.withDefaults()
+	if p.X == 0 || p.Y == 0 {
+		return p, 0, nil
+	}
+	s, out := r.size(p.X, p.Y)
+	if err := checkLimits(s, out); err != nil {
+		return p, 0, err
+	}
+	n := rgbaBytes(p) + rgbaBytes(s)
+	if r.Filter.Kernel != nil {
+		n += rgbaBytes(image.Pt(s.X, p.Y)) * 4
+	}
+	if r.Width != 0 && r.Height != 0 && r.Fit == FitContain {
+		n += rgbaBytes(out)
+	}
+	return out, n, nil
+}
+
+// checkLimits holds the sizes an image is scaled to and output at to Service.ImageLimits.
+func checkLimits(sizes ...image.Point) error {
+	for _, p := range sizes {
+		if err := Service.ImageLimits.Check(image.Config{Width: p.X, Height: p.Y}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rgbaBytes is the memory an RGBA of size p takes.
+func rgbaBytes(p image.Point) int64 {
+	return int64(p.X) * int64(p.Y) * 4
+}
+
+// size works out the size an sw by sh image is scaled to and the size of the output, they differ when it's cropped to
+// cover or padded to contain. r has its defaults filled in.
+func (r Resize) size(sw, sh int) (s, out image.Point) {
+	switch {
+	case r.Height == 0:
+		s = image.Pt(r.Width, scaled(sh, float64(r.Width)/float64(sw)))
+		return s, s
+	case r.Width == 0:
+		s = image.Pt(scaled(sw, float64(r.Height)/float64(sh)), r.Height)
+		return s, s
+	}
+
+	sx, sy := float64(r.Width)/float64(sw), float64(r.Height)/float64(sh)
+	out = image.Pt(r.Width, r.Height)
+	switch r.Fit {
+	case FitFill:
+		return out, out
+	case FitCover:
+		f := math.Max(sx, sy)
+		return image.Pt(scaled(sw, f), scaled(sh, f)), out
+	case FitContain:
+		f := math.Min(sx, sy)
+		return image.Pt(scaled(sw, f), scaled(sh, f)), out
+	default: // FitInside
+		f := math.Min(sx, sy)
+		s = image.Pt(scaled(sw, f), scaled(sh, f))
+		return s, s
+	}
+}
+
+func (r Resize) withDefaults() Resize {
+	if r.Fit == "" {
+		r.Fit = FitCover
+	}
+	if r.Filter.Name == "" {
+		r.Filter = Lanczos3
+	} else {
+		// pick up the kernel when only the name was given
+		r.Filter = Filters[r.Filter.Name]
+	}
+	return r
+}
+
+// scaled is n scaled by s, it's at least 1 so a side never disappears.
+func scaled(n int, s float64) int {
+	v := int(math.Round(float64(n) * s))
+	if v < 1 {
+		return 1
+	}
+	return v
+}
+
+// Scale resamples img to w by h pixels with filter f.
+func Scale(img image.Image, w, h int, f Filter) *image.RGBA {
+	src := toRGBA(img)
+	if f.Kernel == nil {
+		return nearest(src, w, h)
+	}
+
+	sb := src.Bounds()
+	sw, sh := sb.Dx(), sb.Dy()
+
+	// scale horizontally into tmp, then vertically into dst, working in premultiplied alpha
+	tmp := make([]float32, w*sh*4)
+	for y, cs := 0, contributions(w, sw, f); y < sh; y++ {
+		row := src.Pix[y*src.Stride:]
+		for x, c := range cs {
+			var r, g, b, a float32
+			for i, wt := range c.weights {
+				p := row[(c.start+i)*4:]
+				r += float32(p[0]) * wt
+				g += float32(p[1]) * wt
+				b += float32(p[2]) * wt
+				a += float32(p[3]) * wt
+			}
+			t := tmp[(y*w+x)*4:]
+			t[0], t[1], t[2], t[3] = r, g, b, a
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y, c := range contributions(h, sh, f) {
+		row := dst.Pix[y*dst.Stride:]
+		for x := 0; x < w; x++ {
+			var r, g, b, a float32
+			for i, wt := range c.weights {
+				t := tmp[((c.start+i)*w+x)*4:]
+				r += t[0] * wt
+				g += t[1] * wt
+				b += t[2] * wt
+				a += t[3] * wt
+			}
+			alpha := clamp(a, 255)
+			p := row[x*4:]
+			// negative lobes can overshoot, colour can't exceed alpha when premultiplied
+			p[0], p[1], p[2], p[3] = clamp(r, alpha), clamp(g, alpha), clamp(b, alpha), alpha
+		}
+	}
+	return dst
+}
+
+func nearest(src *image.RGBA, w, h int) *image.RGBA {
+	sb := src.Bounds()
+	sx, sy := float64(sb.Dx())/float64(w), float64(sb.Dy())/float64(h)
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		srow := src.Pix[int((float64(y)+0.5)*sy)*src.Stride:]
+		drow := dst.Pix[y*dst.Stride:]
+		for x := 0; x < w; x++ {
+			copy(drow[x*4:x*4+4], srow[int((float64(x)+0.5)*sx)*4:])
+		}
+	}
+	return dst
+}
+
+// toRGBA returns img as an RGBA with bounds starting at 0, 0, converting it if needed.
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Bounds().Min == (image.Point{}) {
+		return rgba
+	}
+	b := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, b.Min, draw.Src)
+	return rgba
+}
+
+func clamp(v float32, max uint8) uint8 {
+	if v <= 0 {
+		return 0
+	}
+	if v >= float32(max) {
+		return max
+	}
+	return uint8(v + 0.5)
+}
@@ -0,0 +1,218 @@
+package resize_test
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/jpeg"
+	"os"
+	"testing"
+
+	"github.com/asatisomnath/ProgImage/Service"
+	"github.com/asatisomnath/ProgImage/Transformers/resize"
+)
+
+// uniform creates a w by h image filled with c.
+func uniform(w, h int, c color.Color) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, img.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+	return img
+}
+
+func TestResize_Dimensions(t *testing.T) {
+	src := uniform(400, 200, color.White)
+
+	tests := []struct {
+		Name          string
+		Resize        resize.Resize
+		Width, Height int
+	}{
+		{Name: "width only", Resize: resize.Resize{Width: 100}, Width: 100, Height: 50},
+		{Name: "height only", Resize: resize.Resize{Height: 100}, Width: 200, Height: 100},
+		{Name: "upscale", Resize: resize.Resize{Width: 800}, Width: 800, Height: 400},
+		{Name: "cover", Resize: resize.Resize{Width: 100, Height: 100, Fit: resize.FitCover}, Width: 100, Height: 100},
+		{Name: "default fit", Resize: resize.Resize{Width: 100, Height: 100}, Width: 100, Height: 100},
+		{Name: "contain", Resize: resize.Resize{Width: 100, Height: 100, Fit: resize.FitContain}, Width: 100, Height: 100},
+		{Name: "fill", Resize: resize.Resize{Width: 100, Height: 100, Fit: resize.FitFill}, Width: 100, Height: 100},
+		{Name: "inside", Resize: resize.Resize{Width: 100, Height: 100, Fit: resize.FitInside}, Width: 100, Height: 50},
+		{Name: "tiny", Resize: resize.Resize{Width: 1}, Width: 1, Height: 1},
+	}
+	for _, item := range tests {
+		t.Run(item.Name, func(t *testing.T) {
+			out, err := item.Resize.Transform(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b := out.Bounds(); b.Dx() != item.Width || b.Dy() != item.Height {
+				t.Errorf("expected %dx%d, got %dx%d", item.Width, item.Height, b.Dx(), b.Dy())
+			}
+			if p, n, err := item.Resize.Size(src.Bounds().Size()); err != nil || p != out.Bounds().Size() || n <= 0 {
+				t.Errorf("expected the size of the output, got %v taking %d, %v", p, n, err)
+			}
+		})
+	}
+}
+
+func TestResize_Contain(t *testing.T) {
+	out, err := resize.Resize{Width: 100, Height: 100, Fit: resize.FitContain}.Transform(uniform(400, 200, color.White))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// letterboxed top and bottom
+	for _, p := range []image.Point{{50, 0}, {50, 24}, {50, 75}, {50, 99}} {
+		if _, _, _, a := out.At(p.X, p.Y).RGBA(); a != 0 {
+			t.Errorf("expected %v to be transparent, got alpha %d", p, a)
+		}
+	}
+	for _, p := range []image.Point{{0, 25}, {50, 50}, {99, 74}} {
+		if r, _, _, a := out.At(p.X, p.Y).RGBA(); a != 0xffff || r != 0xffff {
+			t.Errorf("expected %v to be white, got %v", p, out.At(p.X, p.Y))
+		}
+	}
+}
+
+func TestResize_Filters(t *testing.T) {
+	c := color.NRGBA{R: 200, G: 100, B: 50, A: 255}
+	src := uniform(97, 61, c)
+
+	for name, f := range resize.Filters {
+		t.Run(name, func(t *testing.T) {
+			for _, size := range [][2]int{{30, 20}, {250, 140}} {
+				out := resize.Scale(src, size[0], size[1], f)
+				// a flat colour stays flat whatever the weights
+				for y := 0; y < size[1]; y++ {
+					for x := 0; x < size[0]; x++ {
+						got := color.NRGBAModel.Convert(out.At(x, y)).(color.NRGBA)
+						if got != c {
+							t.Fatalf("expected %v at %d,%d, got %v", c, x, y, got)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestResize_Nearest(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	src.Set(0, 0, color.RGBA{R: 255, A: 255})
+	src.Set(1, 0, color.RGBA{G: 255, A: 255})
+	src.Set(0, 1, color.RGBA{B: 255, A: 255})
+	src.Set(1, 1, color.RGBA{A: 255})
+
+	out := resize.Scale(src, 4, 4, resize.Nearest)
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			if out.At(x, y) != src.At(x/2, y/2) {
+				t.Errorf("expected %v at %d,%d, got %v", src.At(x/2, y/2), x, y, out.At(x, y))
+			}
+		}
+	}
+}
+
+func TestResize_Photo(t *testing.T) {
+	fp, err := os.Open("../../testimages/test.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fp.Close()
+	src, _, err := image.Decode(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, f := range resize.Filters {
+		t.Run(name, func(t *testing.T) {
+			out, err := resize.Resize{Width: 200, Filter: f}.Transform(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b := out.Bounds(); b.Dx() != 200 || b.Dy() != 150 {
+				t.Errorf("expected 200x150, got %dx%d", b.Dx(), b.Dy())
+			}
+		})
+	}
+}
+
+func TestResize_Validate(t *testing.T) {
+	tests := []struct {
+		Name   string
+		Resize resize.Resize
+	}{
+		{Name: "no size", Resize: resize.Resize{}},
+		{Name: "negative", Resize: resize.Resize{Width: -1}},
+		{Name: "too big", Resize: resize.Resize{Width: resize.MaxDimension + 1}},
+		{Name: "unknown fit", Resize: resize.Resize{Width: 10, Fit: "stretch"}},
+		{Name: "unknown filter", Resize: resize.Resize{Width: 10, Filter: resize.Filter{Name: "box"}}},
+	}
+	for _, item := range tests {
+		t.Run(item.Name, func(t *testing.T) {
+			if err := item.Resize.Validate(); err == nil {
+				t.Error("expected error, didn't get one")
+			}
+			if _, err := item.Resize.Transform(uniform(10, 10, color.White)); err == nil {
+				t.Error("expected transform error, didn't get one")
+			}
+		})
+	}
+}
+
+func TestResize_Limits(t *testing.T) {
+	defer func(l Service.Limits) { Service.ImageLimits = l }(Service.ImageLimits)
+	Service.ImageLimits = Service.Limits{MaxPixels: 10000}
+
+	tests := []struct {
+		Name   string
+		Resize resize.Resize
+		Src    image.Image
+		Err    bool
+	}{
+		{Name: "within", Resize: resize.Resize{Width: 100, Height: 100, Filter: resize.Nearest}, Src: uniform(10, 10, color.White)},
+		{Name: "upscaled", Resize: resize.Resize{Width: 101, Height: 100}, Src: uniform(10, 10, color.White), Err: true},
+		{Name: "covered", Resize: resize.Resize{Width: 101, Height: 1, Fit: resize.FitCover}, Src: uniform(10, 10, color.White), Err: true},
+		{Name: "contained", Resize: resize.Resize{Width: 100, Height: 1, Fit: resize.FitContain, Filter: resize.Nearest}, Src: uniform(10, 10, color.White)},
+	}
+	for _, item := range tests {
+		t.Run(item.Name, func(t *testing.T) {
+			_, err := item.Resize.Transform(item.Src)
+			if _, ok := err.(*Service.LimitError); ok != item.Err {
+				t.Errorf("expected limit error %t, got %v", item.Err, err)
+			}
+			_, _, err = item.Resize.Size(item.Src.Bounds().Size())
+			if _, ok := err.(*Service.LimitError); ok != item.Err {
+				t.Errorf("expected limit error %t sizing it, got %v", item.Err, err)
+			}
+		})
+	}
+}
+
+func TestResize_Size(t *testing.T) {
+	// the 100x50 image copied, the float32s scaled across 200 columns of it and the 200x100 result
+	_, n, err := resize.Resize{Width: 200}.Size(image.Pt(100, 50))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := int64(100*50*4 + 200*50*16 + 200*100*4); n != expected {
+		t.Errorf("expected %d bytes, got %d", expected, n)
+	}
+
+	// nearest doesn't need the float32s, padding to contain takes a canvas
+	_, n, err = resize.Resize{Width: 200, Height: 200, Fit: resize.FitContain, Filter: resize.Nearest}.Size(image.Pt(100, 50))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := int64(100*50*4 + 200*100*4 + 200*200*4); n != expected {
+		t.Errorf("expected %d bytes, got %d", expected, n)
+	}
+}
+
+func TestResize_String(t *testing.T) {
+	if s := (resize.Resize{Width: 100}).String(); s != "resize:w=100,h=0,fit=cover,filter=lanczos3" {
+		t.Errorf("unexpected string %s", s)
+	}
+	r := resize.Resize{Width: 100, Height: 50, Fit: resize.FitFill, Filter: resize.Nearest}
+	if s := r.String(); s != "resize:w=100,h=50,fit=fill,filter=nearest" {
+		t.Errorf("unexpected string %s", s)
+	}
+}